@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package bridge defines a small, Matterbridge-style abstraction for relaying
+// messages between a ratchet Client and other chat networks (IRC, Matrix,
+// XMPP, Mumble, Rocket.Chat, ...). A Bridge only needs to know how to connect
+// to its network, accept outbound messages, and emit inbound ones; wiring a
+// Bridge to ratchet peers/rooms is the job of the gateway package.
+package bridge
+
+import (
+	"context"
+	"fmt"
+)
+
+// Message is the network-agnostic envelope exchanged with a Bridge.
+type Message struct {
+	// Channel is the bridge-side room/channel/conversation identifier.
+	Channel string
+
+	// Nick is the display name of the message's author on the bridge side.
+	Nick string
+
+	// Text is the plain text body of the message.
+	Text string
+}
+
+// Bridge relays Messages to and from a single external chat network.
+//
+// Connect MUST be called before Send or Receive are used and may block until
+// the underlying network session is ready. Receive returns a channel that is
+// closed once the Bridge's connection ends.
+type Bridge interface {
+	Connect(ctx context.Context) error
+	Send(ctx context.Context, m Message) error
+	Receive() <-chan Message
+}
+
+// Factory creates a Bridge from a string-keyed configuration, as loaded from
+// a gateway config file.
+type Factory func(cfg map[string]string) (Bridge, error)
+
+// Registry plugs Bridge implementations in by name so gateway configs can
+// refer to them without the gateway package importing every protocol driver.
+type Registry struct {
+	factories map[string]Factory
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{factories: make(map[string]Factory)}
+}
+
+// Register adds a Bridge Factory under name, overwriting any prior entry.
+func (r *Registry) Register(name string, factory Factory) {
+	r.factories[name] = factory
+}
+
+// New builds a Bridge registered under name using cfg.
+func (r *Registry) New(name string, cfg map[string]string) (Bridge, error) {
+	factory, ok := r.factories[name]
+	if !ok {
+		return nil, fmt.Errorf("bridge: no such bridge %q", name)
+	}
+	return factory(cfg)
+}
+
+// DefaultRegistry is pre-populated with the bridges shipped in this module.
+var DefaultRegistry = func() *Registry {
+	r := NewRegistry()
+	r.Register("null", func(cfg map[string]string) (Bridge, error) { return NewNull(), nil })
+	return r
+}()
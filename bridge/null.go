@@ -0,0 +1,35 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package bridge
+
+import "context"
+
+// Null is a Bridge that never talks to a real network. Send echoes the
+// message straight back out on Receive, which makes it useful for exercising
+// the gateway wiring in tests without a live IRC/Matrix/etc. connection.
+type Null struct {
+	recv chan Message
+}
+
+// NewNull returns a ready to use Null bridge.
+func NewNull() *Null {
+	return &Null{recv: make(chan Message, 16)}
+}
+
+func (n *Null) Connect(ctx context.Context) error {
+	return nil
+}
+
+func (n *Null) Send(ctx context.Context, m Message) error {
+	select {
+	case n.recv <- m:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (n *Null) Receive() <-chan Message {
+	return n.recv
+}
@@ -7,7 +7,9 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"mime"
 	"os"
+	"path/filepath"
 
 	"github.com/keys-pub/keys"
 	"go.salty.im/saltyim"
@@ -16,96 +18,135 @@ import (
 	"go.salty.im/ratchet/session"
 )
 
-func Offer(ctx context.Context, keyfile string, state string, them string) error {
+func Offer(ctx context.Context, keyfile, store, state, them string, opts ...client.Option) error {
 	me, key, err := ReadSaltyIdentity(keyfile)
 	if err != nil {
 		return fmt.Errorf("reading keyfile: %w", err)
 	}
 
-	sm, close, err := session.NewSessionManager(state, me, key)
+	sm, close, err := session.Open(store, state, me, key)
 	if err != nil {
 		return err
 	}
 	defer close()
 
-	c, err := client.New(sm, me)
+	c, err := client.New(sm, me, opts...)
 	if err != nil {
 		return err
 	}
-	client.On(c, func(ctx context.Context, m client.OnOfferSent) { fmt.Println(m.Raw) })
+	client.Subscribe(c, func(ctx context.Context, m client.OnOfferSent) error { fmt.Println(m.Raw); return nil })
 
 	_, err = c.Chat(ctx, them)
 	return err
 }
 
-func Send(ctx context.Context, keyfile, state, them, input string) error {
+func Send(ctx context.Context, keyfile, store, state, them, input string, opts ...client.Option) error {
 	me, key, err := ReadSaltyIdentity(keyfile)
 	if err != nil {
 		return fmt.Errorf("reading keyfile: %w", err)
 	}
 
-	sm, close, err := session.NewSessionManager(state, me, key)
+	sm, close, err := session.Open(store, state, me, key)
 	if err != nil {
 		return err
 	}
 	defer close()
 
-	c, err := client.New(sm, me)
+	c, err := client.New(sm, me, opts...)
 	if err != nil {
 		return err
 	}
 
-	client.On(c, func(ctx context.Context, m client.OnMessageSent) { fmt.Println(m.Sealed) })
+	client.Subscribe(c, func(ctx context.Context, m client.OnMessageSent) error { fmt.Println(m.Sealed); return nil })
 
 	err = c.Send(ctx, them, input)
 
 	return err
 }
 
-func Recv(ctx context.Context, keyfile, state, them, input string) error {
+func SendFile(ctx context.Context, keyfile, store, state, them, path string, opts ...client.Option) error {
 	me, key, err := ReadSaltyIdentity(keyfile)
 	if err != nil {
 		return fmt.Errorf("reading keyfile: %w", err)
 	}
 
-	sm, close, err := session.NewSessionManager(state, me, key)
+	sm, close, err := session.Open(store, state, me, key)
 	if err != nil {
 		return err
 	}
 	defer close()
 
-	c, err := client.New(sm, me)
+	c, err := client.New(sm, me, opts...)
 	if err != nil {
 		return err
 	}
 
-	client.On(c, func(ctx context.Context, m client.OnMessageReceived) { fmt.Println(m.Msg.Literal()) })
-	client.On(c, func(ctx context.Context, m client.OnOfferReceived) { fmt.Println(m.PendingAck) })
-	client.On(c, func(ctx context.Context, m client.OnSessionStarted) { fmt.Println("Session Started with ", m.Them) })
-	client.On(c, func(ctx context.Context, m client.OnSessionClosed) { fmt.Println("Session Closed with ", m.Them) })
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	client.Subscribe(c, func(ctx context.Context, m client.OnBlobReceived) error { fmt.Println(m.Path); return nil })
+
+	ctype := mime.TypeByExtension(filepath.Ext(path))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+
+	return c.SendBlob(ctx, them, ctype, filepath.Base(path), f)
+}
+
+func Recv(ctx context.Context, keyfile, store, state, them, input string, opts ...client.Option) error {
+	me, key, err := ReadSaltyIdentity(keyfile)
+	if err != nil {
+		return fmt.Errorf("reading keyfile: %w", err)
+	}
+
+	sm, close, err := session.Open(store, state, me, key)
+	if err != nil {
+		return err
+	}
+	defer close()
+
+	c, err := client.New(sm, me, opts...)
+	if err != nil {
+		return err
+	}
+
+	client.Subscribe(c, func(ctx context.Context, m client.OnMessageReceived) error { fmt.Println(m.Msg.Literal()); return nil })
+	client.Subscribe(c, func(ctx context.Context, m client.OnOfferReceived) error { fmt.Println(m.PendingAck); return nil })
+	client.Subscribe(c, func(ctx context.Context, m client.OnSessionStarted) error {
+		fmt.Println("Session Started with ", m.Them)
+		return nil
+	})
+	client.Subscribe(c, func(ctx context.Context, m client.OnSessionClosed) error {
+		fmt.Println("Session Closed with ", m.Them)
+		return nil
+	})
 
 	err = c.Input(client.OnInput{Position: 1, Payload: input})
 
 	return err
 }
 
-func Close(ctx context.Context, keyfile, state, them string) error {
+func Close(ctx context.Context, keyfile, store, state, them string, opts ...client.Option) error {
 	me, key, err := ReadSaltyIdentity(keyfile)
 	if err != nil {
 		return fmt.Errorf("reading keyfile: %w", err)
 	}
 
-	sm, close, err := session.NewSessionManager(state, me, key)
+	sm, close, err := session.Open(store, state, me, key)
 	if err != nil {
 		return err
 	}
 	defer close()
 
-	c, err := client.New(sm, me)
+	c, err := client.New(sm, me, opts...)
 	if err != nil {
 		return err
 	}
-	client.On(c, func(ctx context.Context, m client.OnMessageSent) { fmt.Println(m.Sealed) })
+	client.Subscribe(c, func(ctx context.Context, m client.OnMessageSent) error { fmt.Println(m.Sealed); return nil })
 
 	err = c.Close(ctx, them)
 
@@ -0,0 +1,262 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/oklog/ulid/v2"
+	"go.sour.is/pkg/xdg"
+)
+
+// Blob frames carry a file attachment's bytes inside the plaintext of an
+// ordinary pairwise ratchet session, the same way group.go's !GRP!...!PUG!
+// frames carry group chat traffic: no new transport or key-agreement is
+// needed, handleRatchet just has one more wrapper to recognize before it
+// falls through to lextwt.ParseSalty.
+const (
+	blobFramePrefix = "!BLB!"
+	blobFrameSuffix = "!BLB!"
+)
+
+// blobChunkSize bounds how much of a file rides in a single ratchet frame.
+const blobChunkSize = 16 * 1024
+
+// blobFrame is one chunk of a blob transfer. Mime and Name are only set on
+// seq 0; SHA256, a hex-encoded digest of the whole payload, is only set on
+// the last chunk (seq == Total-1), so the receiver can verify nothing was
+// dropped or reordered before it writes anything to disk.
+type blobFrame struct {
+	BlobID ulid.ULID
+	Seq    uint32
+	Total  uint32
+	Mime   string `json:",omitempty"`
+	Name   string `json:",omitempty"`
+	SHA256 string `json:",omitempty"`
+	Data   []byte
+}
+
+func isBlobFrame(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(blobFramePrefix)) && bytes.HasSuffix(b, []byte(blobFrameSuffix))
+}
+
+func encodeBlobFrame(frame blobFrame) ([]byte, error) {
+	b, err := json.Marshal(frame)
+	if err != nil {
+		return nil, fmt.Errorf("marshal blob frame: %w", err)
+	}
+
+	out := blobFramePrefix + base64.StdEncoding.EncodeToString(b) + blobFrameSuffix
+	return []byte(out), nil
+}
+
+func decodeBlobFrame(b []byte) (blobFrame, error) {
+	body := bytes.TrimSuffix(bytes.TrimPrefix(b, []byte(blobFramePrefix)), []byte(blobFrameSuffix))
+
+	raw, err := base64.StdEncoding.DecodeString(string(body))
+	if err != nil {
+		return blobFrame{}, fmt.Errorf("decode blob frame: %w", err)
+	}
+
+	var frame blobFrame
+	if err := json.Unmarshal(raw, &frame); err != nil {
+		return blobFrame{}, fmt.Errorf("unmarshal blob frame: %w", err)
+	}
+	return frame, nil
+}
+
+// blobAssembly accumulates a blob transfer's chunks until Total of them
+// have arrived.
+type blobAssembly struct {
+	Mime   string
+	Name   string
+	SHA256 string
+	Total  uint32
+	Chunks map[uint32][]byte
+}
+
+// newBlobCache returns the LRU of in-flight blobAssembly state keyed by
+// blob ID that Client.blobs holds: a peer that never finishes a transfer
+// (or never starts one, on a reordered/dropped final chunk) shouldn't be
+// able to grow this without bound.
+func newBlobCache() *lru.Cache[ulid.ULID, *blobAssembly] {
+	c, err := lru.New[ulid.ULID, *blobAssembly](64)
+	if err != nil {
+		// Only returns an error for a non-positive size, which 64 never is.
+		panic(err)
+	}
+	return c
+}
+
+// OnBlobReceived fires once a blob transfer's final chunk has arrived, its
+// checksum has verified, and it has been written under Path.
+type OnBlobReceived struct {
+	Them string
+	Path string
+	Mime string
+	Name string
+	Size int64
+}
+
+// SendBlob sends r's entire contents to them as a mime-typed attachment,
+// split into blobChunkSize frames over the existing pairwise session. them
+// must already have an established session, same as Send.
+func (c *Client) SendBlob(ctx context.Context, them, mime, name string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read blob: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	id := ulid.Make()
+
+	total := uint32(len(data) / blobChunkSize)
+	if len(data)%blobChunkSize != 0 || total == 0 {
+		total++
+	}
+
+	for seq := uint32(0); seq < total; seq++ {
+		start := int(seq) * blobChunkSize
+		end := start + blobChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		frame := blobFrame{
+			BlobID: id,
+			Seq:    seq,
+			Total:  total,
+			Data:   data[start:end],
+		}
+		if seq == 0 {
+			frame.Mime = mime
+			frame.Name = name
+		}
+		if seq == total-1 {
+			frame.SHA256 = hex.EncodeToString(sum[:])
+		}
+
+		if err := c.sendBlobFrame(ctx, them, frame); err != nil {
+			return fmt.Errorf("send blob %s chunk %d/%d: %w", id, seq+1, total, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) sendBlobFrame(ctx context.Context, them string, frame blobFrame) error {
+	wire, err := encodeBlobFrame(frame)
+	if err != nil {
+		return err
+	}
+
+	return c.sm.Use(ctx, func(ctx context.Context, sm SessionManager) error {
+		session, err := sm.Get(sm.ByName(them))
+		if err != nil {
+			return fmt.Errorf("no established session with %s: %w", them, err)
+		}
+
+		data, err := session.Send(wire)
+		if err != nil {
+			return err
+		}
+
+		if err := c.sendMsg(ctx, session, data); err != nil {
+			return err
+		}
+
+		return sm.Put(session)
+	})
+}
+
+// dispatchBlobFrame is called from handleRatchet once a !BLB! frame has
+// come back from an established pairwise session's ReceiveMsg. It holds
+// chunks in c.blobs until Total have arrived for that BlobID, then
+// verifies the trailing SHA256 before writing anything to disk.
+func (c *Client) dispatchBlobFrame(ctx context.Context, them string, plaintext []byte) error {
+	frame, err := decodeBlobFrame(plaintext)
+	if err != nil {
+		return fmt.Errorf("decode blob frame from %s: %w", them, err)
+	}
+
+	asm, ok := c.blobs.Get(frame.BlobID)
+	if !ok {
+		asm = &blobAssembly{Total: frame.Total, Chunks: make(map[uint32][]byte, frame.Total)}
+		c.blobs.Add(frame.BlobID, asm)
+	}
+	if frame.Mime != "" {
+		asm.Mime = frame.Mime
+	}
+	if frame.Name != "" {
+		asm.Name = frame.Name
+	}
+	if frame.SHA256 != "" {
+		asm.SHA256 = frame.SHA256
+	}
+	asm.Chunks[frame.Seq] = frame.Data
+
+	if uint32(len(asm.Chunks)) < asm.Total {
+		return nil
+	}
+	c.blobs.Remove(frame.BlobID)
+
+	var buf bytes.Buffer
+	for seq := uint32(0); seq < asm.Total; seq++ {
+		chunk, ok := asm.Chunks[seq]
+		if !ok {
+			return fmt.Errorf("blob %s from %s: missing chunk %d/%d", frame.BlobID, them, seq+1, asm.Total)
+		}
+		buf.Write(chunk)
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	if hex.EncodeToString(sum[:]) != asm.SHA256 {
+		return fmt.Errorf("blob %s from %s: checksum mismatch", frame.BlobID, them)
+	}
+
+	path, err := writeBlob(them, frame.BlobID, asm.Name, buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("write blob %s from %s: %w", frame.BlobID, them, err)
+	}
+
+	return Emit(ctx, c, OnBlobReceived{
+		Them: them,
+		Path: path,
+		Mime: asm.Mime,
+		Name: asm.Name,
+		Size: int64(buf.Len()),
+	})
+}
+
+// writeBlob writes data under $XDG_DATA_HOME/ratchet/inbox/<them>/<id>-<name>,
+// creating the peer's inbox directory if needed, and returns the path written.
+func writeBlob(them string, id ulid.ULID, name string, data []byte) (string, error) {
+	dir := xdg.Get(xdg.EnvDataHome, filepath.Join("ratchet", "inbox", them))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	// name comes from the peer's blobFrame and is never trusted: take only
+	// its final path element so a crafted "../../../.ssh/authorized_keys"
+	// can't walk the write out of dir.
+	name = filepath.Base(name)
+	if name == "" || name == "." || name == string(filepath.Separator) {
+		name = id.String()
+	}
+	path := filepath.Join(dir, id.String()+"-"+name)
+
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,165 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/oklog/ulid/v2"
+)
+
+// TestBlobFrameRoundTrip exercises encodeBlobFrame/decodeBlobFrame, the wire
+// encoding sendBlobFrame and dispatchBlobFrame sit on top of.
+func TestBlobFrameRoundTrip(t *testing.T) {
+	is := is.New(t)
+
+	frame := blobFrame{
+		BlobID: ulid.Make(),
+		Seq:    1,
+		Total:  3,
+		Mime:   "text/plain",
+		Name:   "hello.txt",
+		SHA256: "deadbeef",
+		Data:   []byte("some chunk"),
+	}
+
+	wire, err := encodeBlobFrame(frame)
+	is.NoErr(err)
+	is.True(isBlobFrame(wire))
+
+	got, err := decodeBlobFrame(wire)
+	is.NoErr(err)
+	is.Equal(got, frame)
+}
+
+// TestDispatchBlobFrameReassembles drives dispatchBlobFrame with the same
+// chunks SendBlob would produce for data spanning several blobChunkSize
+// frames, out of order, and checks the reassembled file is written intact
+// and OnBlobReceived fires once, on the final chunk.
+func TestDispatchBlobFrameReassembles(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	c := &Client{blobs: newBlobCache(), on: make(map[any]any)}
+
+	var received OnBlobReceived
+	n := 0
+	Subscribe(c, func(ctx context.Context, e OnBlobReceived) error {
+		received = e
+		n++
+		return nil
+	})
+
+	data := bytes.Repeat([]byte("x"), blobChunkSize+1)
+	sum := sha256.Sum256(data)
+	id := ulid.Make()
+
+	frames := []blobFrame{
+		{BlobID: id, Seq: 0, Total: 2, Mime: "application/octet-stream", Name: "big.bin", Data: data[:blobChunkSize]},
+		{BlobID: id, Seq: 1, Total: 2, SHA256: hex.EncodeToString(sum[:]), Data: data[blobChunkSize:]},
+	}
+
+	// Deliver the final chunk first: dispatchBlobFrame must hold it until
+	// the earlier one arrives rather than writing a partial file.
+	wire, err := encodeBlobFrame(frames[1])
+	is.NoErr(err)
+	is.NoErr(c.dispatchBlobFrame(ctx, "bob@sour.is", wire))
+	is.Equal(n, 0)
+
+	wire, err = encodeBlobFrame(frames[0])
+	is.NoErr(err)
+	is.NoErr(c.dispatchBlobFrame(ctx, "bob@sour.is", wire))
+	is.Equal(n, 1)
+
+	is.Equal(received.Them, "bob@sour.is")
+	is.Equal(received.Mime, "application/octet-stream")
+	is.Equal(received.Name, "big.bin")
+	is.Equal(received.Size, int64(len(data)))
+
+	got, err := os.ReadFile(received.Path)
+	is.NoErr(err)
+	is.True(bytes.Equal(got, data))
+	is.Equal(filepath.Base(filepath.Dir(received.Path)), "bob@sour.is")
+}
+
+// TestDispatchBlobFrameRejectsCorruption checks that a final chunk whose
+// trailing SHA256 doesn't match the reassembled bytes is rejected and never
+// written to disk.
+func TestDispatchBlobFrameRejectsCorruption(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	c := &Client{blobs: newBlobCache()}
+
+	id := ulid.Make()
+	frame := blobFrame{
+		BlobID: id,
+		Seq:    0,
+		Total:  1,
+		Name:   "tampered.bin",
+		SHA256: hex.EncodeToString(sha256.New().Sum(nil)), // digest of empty, not of Data
+		Data:   []byte("not empty"),
+	}
+
+	wire, err := encodeBlobFrame(frame)
+	is.NoErr(err)
+
+	err = c.dispatchBlobFrame(ctx, "bob@sour.is", wire)
+	is.True(err != nil)
+
+	dir := filepath.Join(os.Getenv("XDG_DATA_HOME"), "ratchet", "inbox", "bob@sour.is")
+	_, statErr := os.Stat(dir)
+	is.True(os.IsNotExist(statErr))
+}
+
+// TestDispatchBlobFrameRejectsPathTraversal checks that a crafted Name
+// carrying ".." path segments can't walk writeBlob's output out of the
+// peer's inbox directory.
+func TestDispatchBlobFrameRejectsPathTraversal(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	home := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", home)
+
+	c := &Client{blobs: newBlobCache(), on: make(map[any]any)}
+
+	Subscribe(c, func(ctx context.Context, e OnBlobReceived) error { return nil })
+
+	data := []byte("gotcha")
+	sum := sha256.Sum256(data)
+
+	frame := blobFrame{
+		BlobID: ulid.Make(),
+		Seq:    0,
+		Total:  1,
+		Name:   "../../../../../../tmp/evil.sh",
+		SHA256: hex.EncodeToString(sum[:]),
+		Data:   data,
+	}
+
+	wire, err := encodeBlobFrame(frame)
+	is.NoErr(err)
+	is.NoErr(c.dispatchBlobFrame(ctx, "bob@sour.is", wire))
+
+	_, err = os.Stat(filepath.Join("/tmp", "evil.sh"))
+	is.True(os.IsNotExist(err))
+
+	inbox := filepath.Join(home, "ratchet", "inbox", "bob@sour.is")
+	entries, err := os.ReadDir(inbox)
+	is.NoErr(err)
+	is.Equal(len(entries), 1)
+	is.Equal(filepath.Base(entries[0].Name()), entries[0].Name()) // single path element, no traversal
+	is.True(!bytes.Contains([]byte(entries[0].Name()), []byte("..")))
+}
@@ -9,17 +9,21 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	stdsync "sync"
 	"time"
 
+	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/keys-pub/keys"
 	"github.com/oklog/ulid/v2"
 	"go.mills.io/salty"
+	"go.salty.im/ratchet/group"
 	"go.salty.im/ratchet/locker"
+	"go.salty.im/ratchet/obfs"
 	"go.salty.im/ratchet/session"
+	"go.salty.im/ratchet/sync"
 	"go.salty.im/ratchet/xochimilco"
 	"go.salty.im/saltyim"
 	"go.yarn.social/lextwt"
-	"golang.org/x/sync/errgroup"
 )
 
 type SessionManager interface {
@@ -30,6 +34,15 @@ type SessionManager interface {
 	Put(sess *session.Session) error
 	Delete(sess *session.Session) error
 	Sessions() []session.Pair[string, ulid.ULID]
+
+	// Register maps name to a specific id, for a synced session whose id
+	// was assigned by another of the user's devices.
+	Register(name string, id ulid.ULID)
+
+	// Peers lists the user's other devices that session mutations should be
+	// synced to. Returning none opts the SessionManager out of multi-device
+	// sync.
+	Peers() []session.Device
 }
 
 type (
@@ -46,9 +59,15 @@ type Client struct {
 	sm   *locker.Locked[SessionManager]
 	addr Addr
 
-	driver Driver
+	driver      Driver
+	transport   Transport
+	obfuscators []obfs.Obfuscator
 
-	on map[any][]any
+	groups *locker.Locked[group.Manager]
+	blobs  *lru.Cache[ulid.ULID, *blobAssembly]
+
+	onMu stdsync.Mutex
+	on   map[any]any
 }
 type Option interface {
 	ApplyClient(*Client)
@@ -56,6 +75,15 @@ type Option interface {
 
 type Driver interface{ Run(context.Context) error }
 
+// Transport delivers an outbound payload — a sealed ratchet offer/ack/data
+// frame, or a SaltPack-encrypted message — to endpoint. It exists so a
+// Driver (e.g. driver-grpc) can supply its own outbound path instead of the
+// default plain HTTP POST, and so SendSalty isn't hard-wired to
+// saltyim.Send.
+type Transport interface {
+	Send(ctx context.Context, endpoint, payload string) error
+}
+
 type withDriver struct {
 	Driver
 }
@@ -68,6 +96,53 @@ func (d withDriver) ApplyClient(c *Client) {
 	c.driver = d.Driver
 }
 
+type withTransport struct {
+	Transport
+}
+
+// WithTransport overrides how outbound payloads are delivered, replacing
+// the default plain HTTP POST for both ratchet frames and SaltPack
+// messages.
+func WithTransport(t Transport) withTransport {
+	return withTransport{t}
+}
+
+func (t withTransport) ApplyClient(c *Client) {
+	c.transport = t.Transport
+}
+
+type withObfuscator struct {
+	obfuscators []obfs.Obfuscator
+}
+
+// WithObfuscator disguises outbound ratchet frames and SaltPack messages
+// with the given obfs.Obfuscator(s) before they reach the Transport, so the
+// `!RAT!...!CHT!` framing and the SaltPack armor preamble don't stand out
+// on the wire. The first Obfuscator is used to Wrap outbound payloads; all
+// of them are tried, in order, to Unwrap inbound ones. Passing none leaves
+// the wire format unobfuscated.
+func WithObfuscator(o ...obfs.Obfuscator) withObfuscator {
+	return withObfuscator{o}
+}
+
+func (o withObfuscator) ApplyClient(c *Client) {
+	c.obfuscators = append(c.obfuscators, o.obfuscators...)
+}
+
+type withGroupManager struct {
+	group.Manager
+}
+
+// WithGroupManager overrides the storage backend for group.Session state,
+// replacing the default in-process group.MemManager.
+func WithGroupManager(gm group.Manager) withGroupManager {
+	return withGroupManager{gm}
+}
+
+func (g withGroupManager) ApplyClient(c *Client) {
+	c.groups = locker.New(g.Manager)
+}
+
 func New(sm SessionManager, me string, opts ...Option) (*Client, error) {
 	addr, err := saltyim.LookupAddr(me)
 	if err != nil {
@@ -75,19 +150,24 @@ func New(sm SessionManager, me string, opts ...Option) (*Client, error) {
 	}
 
 	c := &Client{
-		sm:     locker.New(sm),
-		addr:   addr,
-		driver: nilDriver{},
-		on:     make(map[any][]any),
+		sm:        locker.New(sm),
+		addr:      addr,
+		driver:    nilDriver{},
+		transport: httpTransport{},
+		groups:    locker.New[group.Manager](group.NewMemManager()),
+		blobs:     newBlobCache(),
+		on:        make(map[any]any),
 	}
 
 	for _, o := range opts {
 		o.ApplyClient(c)
 	}
 
-	On(c, c.handleSaltPack)
-	On(c, c.handleRatchet)
-	On(c, c.handleOther)
+	Subscribe(c, c.handleSaltPack)
+	Subscribe(c, c.handleRatchet)
+	Subscribe(c, c.handleSync)
+	Subscribe(c, c.handleOther)
+	Subscribe(c, c.handleGroupMemberDeparture)
 
 	return c, nil
 }
@@ -99,27 +179,6 @@ func (c *Client) Me() saltyim.Addr {
 	return c.addr
 }
 
-func On[T any](c *Client, fn func(context.Context, T)) {
-	var id T
-	c.on[id] = append(c.on[id], fn)
-}
-
-func dispatch[T any](ctx context.Context, c *Client, args T) error {
-	var id T
-	hdlrs := c.on[id]
-
-	wg, ctx := errgroup.WithContext(ctx)
-
-	for i := range hdlrs {
-		hdlr := hdlrs[i].(func(context.Context, T))
-		wg.Go(func() error {
-			hdlr(ctx, args)
-			return nil
-		})
-	}
-	return wg.Wait()
-}
-
 type OnInput struct {
 	Position int64
 	Payload  string
@@ -166,6 +225,12 @@ type OnSessionClosed struct {
 	ID   ulid.ULID
 	Them string
 }
+type OnSessionSync struct {
+	ID     ulid.ULID
+	Them   string
+	Device string
+	Frame  sync.Frame
+}
 type OnSaltyTextReceived struct {
 	Pubkey *Pubkey
 	Msg    *Msg
@@ -188,35 +253,45 @@ func (c *Client) Use(ctx context.Context, fn func(context.Context, SessionManage
 	return c.sm.Use(ctx, fn)
 }
 
+// Chat reports its progress as OnProgress{Phase: "offer"} when it has to
+// start a new session (which includes the addr/key lookup sm.New does
+// internally) and OnProgress{Phase: "ack"} when it flushes a pending ack
+// from an offer already on disk, establishing the session.
 func (c *Client) Chat(ctx context.Context, them string) (bool, error) {
+	op := ulid.Make()
 	established := false
 	return established, c.sm.Use(ctx, func(ctx context.Context, sm SessionManager) error {
 		session, err := sm.Get(sm.ByName(them))
 
 		// handle initiating a new chat
 		if err != nil && errors.Is(err, os.ErrNotExist) {
-			session, err = sm.New(them)
-			if err != nil {
-				return err
-			}
-			msg, err := session.Offer()
-			if err != nil {
-				return err
-			}
-
-			err = c.sendMsg(session, msg)
-			if err != nil {
-				return err
-			}
-			err = sm.Put(session)
-			if err != nil {
-				return err
-			}
-
-			return dispatch(ctx, c, OnOfferSent{
-				ID:   toULID(session.LocalUUID),
-				Them: them,
-				Raw:  msg,
+			return c.progress(ctx, op, them, "offer", ulid.ULID{}, func() error {
+				session, err = sm.New(them)
+				if err != nil {
+					return err
+				}
+				msg, err := session.Offer()
+				if err != nil {
+					return err
+				}
+
+				err = c.sendMsg(ctx, session, msg)
+				if err != nil {
+					return err
+				}
+				err = sm.Put(session)
+				if err != nil {
+					return err
+				}
+				if err := c.sendSync(ctx, sm, session, sync.OpUpdate); err != nil {
+					return err
+				}
+
+				return Emit(ctx, c, OnOfferSent{
+					ID:   toULID(session.LocalUUID),
+					Them: them,
+					Raw:  msg,
+				})
 			})
 		}
 		if err != nil {
@@ -225,19 +300,24 @@ func (c *Client) Chat(ctx context.Context, them string) (bool, error) {
 
 		// handle a pending ack from offer.
 		if len(session.PendingAck) > 0 {
-			err = c.sendMsg(session, session.PendingAck)
-			if err != nil {
-				return err
-			}
-
-			session.PendingAck = ""
-			err = sm.Put(session)
-			if err != nil {
-				return err
-			}
-			established = true
-
-			return dispatch(ctx, c, OnSessionStarted{toULID(session.LocalUUID), them})
+			return c.progress(ctx, op, them, "ack", toULID(session.LocalUUID), func() error {
+				err = c.sendMsg(ctx, session, session.PendingAck)
+				if err != nil {
+					return err
+				}
+
+				session.PendingAck = ""
+				err = sm.Put(session)
+				if err != nil {
+					return err
+				}
+				if err := c.sendSync(ctx, sm, session, sync.OpUpdate); err != nil {
+					return err
+				}
+				established = true
+
+				return Emit(ctx, c, OnSessionStarted{toULID(session.LocalUUID), them})
+			})
 		}
 
 		return err
@@ -263,7 +343,7 @@ func (c *Client) Send(ctx context.Context, them, text string, events ...*Event)
 			return err
 		}
 
-		err = c.sendMsg(session, data)
+		err = c.sendMsg(ctx, session, data)
 		if err != nil {
 			return err
 		}
@@ -272,8 +352,11 @@ func (c *Client) Send(ctx context.Context, them, text string, events ...*Event)
 		if err != nil {
 			return err
 		}
+		if err := c.sendSync(ctx, sm, session, sync.OpUpdate); err != nil {
+			return err
+		}
 
-		return dispatch(ctx, c, OnMessageSent{
+		return Emit(ctx, c, OnMessageSent{
 			ID:     msgID,
 			Them:   them,
 			Raw:    msg.Literal(),
@@ -294,12 +377,12 @@ func (c *Client) Close(ctx context.Context, them string) error {
 			return err
 		}
 
-		err = c.sendMsg(session, msg)
+		err = c.sendMsg(ctx, session, msg)
 		if err != nil {
 			return err
 		}
 
-		err = dispatch(ctx, c, OnCloseSent{
+		err = Emit(ctx, c, OnCloseSent{
 			ID:     toULID(session.LocalUUID),
 			Them:   them,
 			Sealed: msg,
@@ -308,11 +391,15 @@ func (c *Client) Close(ctx context.Context, them string) error {
 			return err
 		}
 
-		err = dispatch(ctx, c, OnSessionClosed{toULID(session.LocalUUID), them})
+		err = Emit(ctx, c, OnSessionClosed{toULID(session.LocalUUID), them})
 		if err != nil {
 			return err
 		}
 
+		if err := c.sendSync(ctx, sm, session, sync.OpDelete); err != nil {
+			return err
+		}
+
 		err = sm.Delete(session)
 		if err != nil {
 			return err
@@ -321,34 +408,46 @@ func (c *Client) Close(ctx context.Context, them string) error {
 		return err
 	})
 }
+
+// SendSalty reports OnProgress{Phase: "lookup"} around resolving them's
+// address and key, then OnProgress{Phase: "send"} around encrypting and
+// posting the message.
 func (c *Client) SendSalty(ctx context.Context, them, text string, events ...*Event) error {
-	addr, err := saltyim.LookupAddr(them)
-	if err != nil {
+	op := ulid.Make()
+
+	var addr saltyim.Addr
+	if err := c.progress(ctx, op, them, "lookup", ulid.ULID{}, func() error {
+		var err error
+		addr, err = saltyim.LookupAddr(them)
+		return err
+	}); err != nil {
 		return err
 	}
 
 	return c.sm.Use(ctx, func(ctx context.Context, sm SessionManager) error {
-		msg := lextwt.NewSaltyText(
-			lextwt.NewDateTime(time.Now(), ""),
-			lextwt.NewSaltyUser(addr.User(), addr.Domain()),
-			toElems(lextwt.NewText(text), events)...,
-		)
+		return c.progress(ctx, op, them, "send", ulid.ULID{}, func() error {
+			msg := lextwt.NewSaltyText(
+				lextwt.NewDateTime(time.Now(), ""),
+				lextwt.NewSaltyUser(addr.User(), addr.Domain()),
+				toElems(lextwt.NewText(text), events)...,
+			)
 
-		b, err := salty.Encrypt(sm.Identity(), []byte(msg.Literal()), []string{addr.Key().ID().String()})
-		if err != nil {
-			return fmt.Errorf("error encrypting message to %s: %w", addr, err)
-		}
+			b, err := salty.Encrypt(sm.Identity(), []byte(msg.Literal()), []string{addr.Key().ID().String()})
+			if err != nil {
+				return fmt.Errorf("error encrypting message to %s: %w", addr, err)
+			}
 
-		err = saltyim.Send(addr.Endpoint().String(), string(b), addr.Cap())
-		if err != nil {
-			return err
-		}
+			err = c.transport.Send(ctx, addr.Endpoint().String(), c.wrap(string(b)))
+			if err != nil {
+				return err
+			}
 
-		return dispatch(ctx, c, OnSaltySent{
-			Them: them,
-			Addr: addr,
-			Raw:  msg.Literal(),
-			Msg:  msg,
+			return Emit(ctx, c, OnSaltySent{
+				Them: them,
+				Addr: addr,
+				Raw:  msg.Literal(),
+				Msg:  msg,
+			})
 		})
 	})
 }
@@ -365,185 +464,372 @@ func (c *Client) Input(in OnInput) error {
 	ctx, cancel := c.Context()
 	defer cancel()
 
-	return dispatch(ctx, c, in)
+	return Emit(ctx, c, in)
 }
 
-func (c *Client) handleSaltPack(ctx context.Context, in OnInput) {
-	input := string(in.Payload)
+// handleSaltPack reports its decrypt-through-dispatch work as a single
+// OnProgress{Phase: "receive"}, since SaltPack messages carry no session ID
+// to report before they're decrypted.
+func (c *Client) handleSaltPack(ctx context.Context, in OnInput) error {
+	input := c.unwrap(string(in.Payload))
 
 	if !strings.HasPrefix(input, "BEGIN SALTPACK ENCRYPTED MESSAGE.") {
-		return
+		return nil
 	}
 
+	op := ulid.Make()
+
 	err := c.sm.Use(ctx, func(ctx context.Context, sm SessionManager) error {
-		// Update session manager position in stream if supported.
-		if s, ok := sm.(interface{ SetPosition(int64) }); ok {
-			s.SetPosition(in.Position + 1)
-		}
+		return c.progress(ctx, op, "", "receive", ulid.ULID{}, func() error {
+			// Update session manager position in stream if supported.
+			if s, ok := sm.(interface{ SetPosition(int64) }); ok {
+				s.SetPosition(in.Position + 1)
+			}
 
-		text, key, err := salty.Decrypt(sm.Identity(), []byte(in.Payload))
-		if err != nil {
-			return err
-		}
+			text, key, err := salty.Decrypt(sm.Identity(), []byte(input))
+			if err != nil {
+				return err
+			}
 
-		msg, err := lextwt.ParseSalty(string(text))
-		if err != nil {
-			return err
-		}
+			// Sync frames reuse the SaltPack envelope; handleSync owns those.
+			if isSyncFrame(text) {
+				return nil
+			}
 
-		switch msg := msg.(type) {
-		case *lextwt.SaltyEvent:
-			return dispatch(ctx, c, OnSaltyEventReceived{key, msg})
+			msg, err := lextwt.ParseSalty(string(text))
+			if err != nil {
+				return err
+			}
 
-		case *lextwt.SaltyText:
-			return dispatch(ctx, c, OnSaltyTextReceived{key, msg})
+			switch msg := msg.(type) {
+			case *lextwt.SaltyEvent:
+				return Emit(ctx, c, OnSaltyEventReceived{key, msg})
 
-		}
+			case *lextwt.SaltyText:
+				return Emit(ctx, c, OnSaltyTextReceived{key, msg})
 
-		return nil
+			}
+
+			return nil
+		})
 	})
 
 	if err != nil {
-		dispatch(ctx, c, err)
+		Emit(ctx, c, err)
 	}
+	return err
 }
 
-func (c *Client) handleRatchet(ctx context.Context, in OnInput) {
-	input := string(in.Payload)
+// handleRatchet reports the whole unseal-through-dispatch pipeline as a
+// single OnProgress{Phase: "receive"}, tagged with the wire frame's session
+// ID as soon as readMsg has parsed it.
+func (c *Client) handleRatchet(ctx context.Context, in OnInput) error {
+	input := c.unwrap(string(in.Payload))
 
 	if !(strings.HasPrefix(input, "!RAT!") && strings.HasSuffix(input, "!CHT!")) {
-		return
+		return nil
 	}
 
+	op := ulid.Make()
+
 	id, xmsg, err := readMsg(input)
 	if err != nil {
 		err = fmt.Errorf("reading msg: %w", err)
-		dispatch(ctx, c, err)
+		Emit(ctx, c, err)
 
-		return
+		return err
 	}
 
 	err = c.sm.Use(ctx, func(ctx context.Context, sm SessionManager) error {
-		// Update session manager position in stream if supported.
-		if s, ok := sm.(interface{ SetPosition(int64) }); ok {
-			s.SetPosition(in.Position + 1)
-		}
+		return c.progress(ctx, op, "", "receive", id, func() error {
+			return c.receiveRatchet(ctx, sm, in, id, xmsg)
+		})
+	})
 
-		// unseal message if required.
-		if sealed, ok := xmsg.(interface {
-			Unseal(priv, pub *[32]byte) (m xochimilco.Msg, err error)
-		}); ok {
-			xmsg, err = sealed.Unseal(
-				sm.Identity().X25519Key().Bytes32(),
-				sm.Identity().X25519Key().PublicKey().Bytes32(),
-			)
-			if err != nil {
-				return err
-			}
-		}
+	if err != nil {
+		Emit(ctx, c, err)
+	}
+	return err
+}
 
-		var sess *session.Session
+func (c *Client) receiveRatchet(ctx context.Context, sm SessionManager, in OnInput, id ulid.ULID, xmsg xochimilco.Msg) error {
+	var err error
 
-		// offer messages have a nick embeded in the payload.
-		if offer, ok := xmsg.(interface {
-			Nick() string
-		}); ok {
-			sess, err = sm.New(offer.Nick())
-			if err != nil {
-				return fmt.Errorf("get session: %w", err)
-			}
-		} else {
-			sess, err = sm.Get(id)
-			if errors.Is(err, os.ErrNotExist) {
-				return nil
-			}
-			if err != nil {
-				return fmt.Errorf("get session: %w", err)
-			}
+	// Update session manager position in stream if supported.
+	if s, ok := sm.(interface{ SetPosition(int64) }); ok {
+		s.SetPosition(in.Position + 1)
+	}
+
+	// unseal message if required.
+	if sealed, ok := xmsg.(interface {
+		Unseal(priv, pub *[32]byte) (m xochimilco.Msg, err error)
+	}); ok {
+		xmsg, err = sealed.Unseal(
+			sm.Identity().X25519Key().Bytes32(),
+			sm.Identity().X25519Key().PublicKey().Bytes32(),
+		)
+		if err != nil {
+			return err
 		}
+	}
+
+	var sess *session.Session
 
-		if sess == nil {
+	// offer messages have a nick embeded in the payload.
+	if offer, ok := xmsg.(interface {
+		Nick() string
+	}); ok {
+		sess, err = sm.New(offer.Nick())
+		if err != nil {
+			return fmt.Errorf("get session: %w", err)
+		}
+	} else {
+		sess, err = sm.Get(id)
+		if errors.Is(err, os.ErrNotExist) {
 			return nil
 		}
-
-		isEstablished, isClosed, plaintext, err := sess.ReceiveMsg(xmsg)
 		if err != nil {
-			return fmt.Errorf("session receive: %w", err)
+			return fmt.Errorf("get session: %w", err)
 		}
+	}
 
-		if sess.PendingAck != "" {
-			err = dispatch(ctx, c, OnOfferReceived{
-				ID:         toULID(xmsg.ID()),
-				Them:       sess.Name,
-				PendingAck: sess.PendingAck,
-			})
-			if err != nil {
-				return err
-			}
+	if sess == nil {
+		return nil
+	}
+
+	isEstablished, isClosed, plaintext, err := sess.ReceiveMsg(xmsg)
+	if err != nil {
+		return fmt.Errorf("session receive: %w", err)
+	}
+
+	if sess.PendingAck != "" {
+		err = Emit(ctx, c, OnOfferReceived{
+			ID:         toULID(xmsg.ID()),
+			Them:       sess.Name,
+			PendingAck: sess.PendingAck,
+		})
+		if err != nil {
+			return err
 		}
+	}
 
-		err = sm.Put(sess)
+	err = sm.Put(sess)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case isClosed:
+		err = sm.Delete(sess)
 		if err != nil {
 			return err
 		}
 
+		return Emit(ctx, c, OnSessionClosed{toULID(xmsg.ID()), sess.Name})
+	case isEstablished:
+		return Emit(ctx, c, OnSessionStarted{toULID(xmsg.ID()), sess.Name})
+	}
+
+	if isGroupFrame(plaintext) {
+		return c.dispatchGroupFrame(ctx, sess.Name, plaintext)
+	}
+
+	if isBlobFrame(plaintext) {
+		return c.dispatchBlobFrame(ctx, sess.Name, plaintext)
+	}
+
+	msg, _ := lextwt.ParseSalty(string(plaintext))
+
+	switch msg := msg.(type) {
+	case *Msg:
+		return Emit(ctx, c, OnMessageReceived{
+			ID:   toULID(xmsg.ID()),
+			Them: sess.Name,
+			Raw:  string(plaintext),
+			Msg:  msg,
+		})
+
+	case *Event:
+		return Emit(ctx, c, OnEventReceived{
+			ID:   toULID(xmsg.ID()),
+			Them: sess.Name,
+			Raw:  string(plaintext),
+			Msg:  msg,
+		})
+
+	}
+
+	return nil
+}
+
+// handleSync applies a sync.Frame from one of the user's other devices,
+
+// handleSync applies a sync.Frame from one of the user's other devices,
+// keeping the earlier sync.Wins rule so every device converges on the same
+// state for a session both touched concurrently.
+func (c *Client) handleSync(ctx context.Context, in OnInput) error {
+	input := c.unwrap(string(in.Payload))
+
+	if !strings.HasPrefix(input, "BEGIN SALTPACK ENCRYPTED MESSAGE.") {
+		return nil
+	}
+
+	err := c.sm.Use(ctx, func(ctx context.Context, sm SessionManager) error {
+		frame, _, err := sync.Decrypt(sm.Identity(), []byte(input))
+		if err != nil || (frame.Op != sync.OpUpdate && frame.Op != sync.OpDelete) {
+			// Not a sync frame; handleSaltPack owns this payload.
+			return nil
+		}
+
+		local, err := sm.Get(frame.SessionID)
 		switch {
-		case isClosed:
-			err = sm.Delete(sess)
-			if err != nil {
+		case errors.Is(err, os.ErrNotExist):
+			local = nil
+		case err != nil:
+			return fmt.Errorf("get synced session: %w", err)
+		}
+
+		if frame.Op == sync.OpDelete {
+			if local == nil {
+				return nil
+			}
+			if err := sm.Delete(local); err != nil {
 				return err
 			}
 
-			return dispatch(ctx, c, OnSessionClosed{toULID(xmsg.ID()), sess.Name})
-		case isEstablished:
-			return dispatch(ctx, c, OnSessionStarted{toULID(xmsg.ID()), sess.Name})
+			return Emit(ctx, c, OnSessionSync{ID: frame.SessionID, Them: local.Name, Device: frame.DeviceID, Frame: frame})
 		}
 
-		msg, _ := lextwt.ParseSalty(string(plaintext))
-
-		switch msg := msg.(type) {
-		case *Msg:
-			return dispatch(ctx, c, OnMessageReceived{
-				ID:   toULID(xmsg.ID()),
-				Them: sess.Name,
-				Raw:  string(plaintext),
-				Msg:  msg,
-			})
+		if local != nil && !sync.Wins(frame, sync.Frame{DeviceID: local.DeviceID, Counter: local.SyncCounter}) {
+			// Our copy is newer, or wins the tie; ignore the stale sync.
+			return nil
+		}
 
-		case *Event:
-			return dispatch(ctx, c, OnEventReceived{
-				ID:   toULID(xmsg.ID()),
-				Them: sess.Name,
-				Raw:  string(plaintext),
-				Msg:  msg,
-			})
+		incoming := &session.Session{}
+		if err := incoming.UnmarshalBinary(frame.State); err != nil {
+			return fmt.Errorf("unmarshal synced session: %w", err)
+		}
 
+		sm.Register(incoming.Name, frame.SessionID)
+		if err := sm.Put(incoming); err != nil {
+			return err
 		}
 
-		return nil
+		return Emit(ctx, c, OnSessionSync{ID: frame.SessionID, Them: incoming.Name, Device: frame.DeviceID, Frame: frame})
 	})
 
 	if err != nil {
-		dispatch(ctx, c, err)
+		Emit(ctx, c, err)
 	}
+	return err
 }
 
-func (c *Client) handleOther(ctx context.Context, in OnInput) {
-	input := string(in.Payload)
+// isSyncFrame reports whether b is a gob-encoded sync.Frame, which is how
+// handleSaltPack tells a sync message apart from an ordinary SaltyText/
+// SaltyEvent sharing the same SaltPack envelope.
+func isSyncFrame(b []byte) bool {
+	var f sync.Frame
+	if err := (&f).UnmarshalBinary(b); err != nil {
+		return false
+	}
+	return f.Op == sync.OpUpdate || f.Op == sync.OpDelete
+}
+
+func (c *Client) handleOther(ctx context.Context, in OnInput) error {
+	input := c.unwrap(string(in.Payload))
 
 	if strings.HasPrefix(input, "!RAT!") && strings.HasSuffix(input, "!CHT!") {
-		return
+		return nil
 	}
 
 	if strings.HasPrefix(input, "BEGIN SALTPACK ENCRYPTED MESSAGE.") {
-		return
+		return nil
+	}
+
+	return Emit(ctx, c, OnReceived{string(in.Payload)})
+}
+
+func (c *Client) sendMsg(ctx context.Context, session *session.Session, msg string) error {
+	return c.transport.Send(ctx, session.Endpoint, c.wrap(msg))
+}
+
+// sendSync fans out sess's current state to the user's other devices as a
+// sync.Frame, so they can decrypt history and keep ratcheting without
+// colliding with this device. It is a no-op when sm has no registered
+// Peers. op is OpDelete instead of OpUpdate when sess was just removed.
+func (c *Client) sendSync(ctx context.Context, sm SessionManager, sess *session.Session, op sync.Op) error {
+	peers := sm.Peers()
+	if len(peers) == 0 {
+		return nil
+	}
+
+	sess.SyncCounter++
+
+	var state []byte
+	if op == sync.OpUpdate {
+		var err error
+		state, err = sess.MarshalBinary()
+		if err != nil {
+			return fmt.Errorf("marshal session for sync: %w", err)
+		}
+	}
+
+	frame := sync.Frame{
+		SessionID: toULID(sess.LocalUUID),
+		DeviceID:  sess.DeviceID,
+		Counter:   sess.SyncCounter,
+		Op:        op,
+		State:     state,
+	}
+
+	recipients := make([]string, len(peers))
+	for i, p := range peers {
+		recipients[i] = p.Key.ID().String()
 	}
 
-	dispatch(ctx, c, OnReceived{string(in.Payload)})
+	data, err := sync.Encrypt(sm.Identity(), frame, recipients)
+	if err != nil {
+		return fmt.Errorf("encrypt sync frame: %w", err)
+	}
+
+	for _, p := range peers {
+		if err := c.transport.Send(ctx, p.Endpoint, c.wrap(string(data))); err != nil {
+			return fmt.Errorf("send sync frame to device %s: %w", p.ID, err)
+		}
+	}
+
+	return Emit(ctx, c, OnSessionSync{
+		ID:     frame.SessionID,
+		Them:   sess.Name,
+		Device: sess.DeviceID,
+		Frame:  frame,
+	})
 }
 
-func (c *Client) sendMsg(session *session.Session, msg string) error {
-	_, err := http.DefaultClient.Post(session.Endpoint, "text/plain", strings.NewReader(msg))
+// wrap disguises msg with the first configured obfs.Obfuscator, if any.
+func (c *Client) wrap(msg string) string {
+	if len(c.obfuscators) == 0 {
+		return msg
+	}
+	return c.obfuscators[0].Wrap(msg)
+}
+
+// unwrap tries each configured obfs.Obfuscator, in order, to restore the
+// original payload. It returns in unchanged if none of them recognize it,
+// so an unobfuscated peer's messages still parse normally.
+func (c *Client) unwrap(in string) string {
+	for _, o := range c.obfuscators {
+		if out, err := o.Unwrap(in); err == nil {
+			return out
+		}
+	}
+	return in
+}
+
+type httpTransport struct{}
+
+func (httpTransport) Send(_ context.Context, endpoint, payload string) error {
+	_, err := http.DefaultClient.Post(endpoint, "text/plain", strings.NewReader(payload))
 	if err != nil {
 		return err
 	}
@@ -19,22 +19,30 @@ import (
 )
 
 var (
-	err   error
-	path  string
-	alice *keys.EdX25519Key
-	bob   *keys.EdX25519Key
+	err     error
+	alice   *keys.EdX25519Key
+	bob     *keys.EdX25519Key
+	aliceSM *session.MemorySessionManager
+	bobSM   *session.MemorySessionManager
 )
 
 func TestMain(m *testing.M) {
 	// Setup
-	path, err = os.MkdirTemp("", "")
+	alice = keys.GenerateEdX25519Key()
+	bob = keys.GenerateEdX25519Key()
+
+	// A single MemorySessionManager per identity, reused across every block
+	// below: each block stands in for a separate client invocation of that
+	// identity, so the manager has to outlive it the way DiskSessionManager
+	// would across a reopen of the same path.
+	aliceSM, _, err = session.NewMemorySessionManager("alice@sour.is", alice)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bobSM, _, err = session.NewMemorySessionManager("bob@sour.is", bob)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer os.RemoveAll(path)
-
-	alice = keys.GenerateEdX25519Key()
-	bob = keys.GenerateEdX25519Key()
 
 	http.DefaultClient.Transport = &requests
 
@@ -91,35 +99,26 @@ func TestClient(t *testing.T) {
 	ctx := context.Background()
 
 	{ // Make offer
-		sm, closeSession, err := session.NewSessionManager(path, "alice@sour.is", alice)
-		is.NoErr(err)
-
-		c, err := client.New(sm, "alice@sour.is")
+		c, err := client.New(aliceSM, "alice@sour.is")
 		is.NoErr(err)
 		is.True(c != nil)
 
 		ok, err := c.Chat(ctx, "bob@sour.is")
 		is.NoErr(err)
 		is.True(!ok)
-
-		err = closeSession()
-		is.NoErr(err)
 	}
 
 	offerPayload, err := io.ReadAll(requests.reqs[2].Body)
 	is.NoErr(err)
 
 	{ // Receive offer and ack
-		sm, closeSession, err := session.NewSessionManager(path, "bob@sour.is", bob)
-		is.NoErr(err)
-
-		c, err := client.New(sm, "bob@sour.is")
+		c, err := client.New(bobSM, "bob@sour.is")
 		is.NoErr(err)
 		is.True(c != nil)
 
 		var offer client.OnOfferReceived
 		done := make(chan struct{})
-		client.On(c, func(ctx context.Context, args client.OnOfferReceived) { offer = args; close(done) })
+		client.Subscribe(c, func(ctx context.Context, args client.OnOfferReceived) error { offer = args; close(done); return nil })
 
 		err = c.Input(client.OnInput{1, string(offerPayload)})
 		is.NoErr(err)
@@ -135,9 +134,6 @@ func TestClient(t *testing.T) {
 
 		err = c.Send(ctx, "alice@sour.is", "Hello, Bob.")
 		is.NoErr(err)
-
-		err = closeSession()
-		is.NoErr(err)
 	}
 
 	ackPayload, err := io.ReadAll(requests.reqs[5].Body)
@@ -147,10 +143,7 @@ func TestClient(t *testing.T) {
 	is.NoErr(err)
 
 	{ // Receive ack and message, send close.
-		sm, closeSession, err := session.NewSessionManager(path, "alice@sour.is", alice)
-		is.NoErr(err)
-
-		c, err := client.New(sm, "alice@sour.is")
+		c, err := client.New(aliceSM, "alice@sour.is")
 		is.NoErr(err)
 		is.True(c != nil)
 
@@ -160,8 +153,8 @@ func TestClient(t *testing.T) {
 		var ack client.OnSessionStarted
 		var msg client.OnMessageReceived
 
-		client.On(c, func(ctx context.Context, args client.OnSessionStarted) { ack = args; close(ackRcvd) })
-		client.On(c, func(ctx context.Context, args client.OnMessageReceived) { msg = args; close(msgRcvd) })
+		client.Subscribe(c, func(ctx context.Context, args client.OnSessionStarted) error { ack = args; close(ackRcvd); return nil })
+		client.Subscribe(c, func(ctx context.Context, args client.OnMessageReceived) error { msg = args; close(msgRcvd); return nil })
 
 		err = c.Input(client.OnInput{1, string(ackPayload)})
 		is.NoErr(err)
@@ -178,25 +171,19 @@ func TestClient(t *testing.T) {
 
 		err = c.Close(ctx, "bob@sour.is")
 		is.NoErr(err)
-
-		err = closeSession()
-		is.NoErr(err)
 	}
 
 	closePayload, err := io.ReadAll(requests.reqs[8].Body)
 	is.NoErr(err)
-	
-	{ // receive close
-		sm, closeSession, err := session.NewSessionManager(path, "bob@sour.is", bob)
-		is.NoErr(err)
 
-		c, err := client.New(sm, "bob@sour.is")
+	{ // receive close
+		c, err := client.New(bobSM, "bob@sour.is")
 		is.NoErr(err)
 		is.True(c != nil)
 
 		var msg client.OnSessionClosed
 		done := make(chan struct{})
-		client.On(c, func(ctx context.Context, args client.OnSessionClosed) { msg = args; close(done) })
+		client.Subscribe(c, func(ctx context.Context, args client.OnSessionClosed) error { msg = args; close(done); return nil })
 
 		err = c.Input(client.OnInput{1, string(closePayload)})
 		is.NoErr(err)
@@ -204,40 +191,28 @@ func TestClient(t *testing.T) {
 		<-done
 
 		is.Equal(msg.Them, "alice@sour.is")
-
-		err = closeSession()
-		is.NoErr(err)
 	}
 
 	{ // Send salty
-		sm, closeSession, err := session.NewSessionManager(path, "bob@sour.is", bob)
-		is.NoErr(err)
-
-		c, err := client.New(sm, "bob@sour.is")
+		c, err := client.New(bobSM, "bob@sour.is")
 		is.NoErr(err)
 		is.True(c != nil)
 
 		err = c.SendSalty(ctx, "alice@sour.is", "Hello, Alice.")
 		is.NoErr(err)
-
-		err = closeSession()
-		is.NoErr(err)
 	}
 
 	saltyPayload, err := io.ReadAll(requests.reqs[12].Body)
 	is.NoErr(err)
 
 	{ // Receive salty
-		sm, closeSession, err := session.NewSessionManager(path, "alice@sour.is", alice)
-		is.NoErr(err)
-
-		c, err := client.New(sm, "alice@sour.is")
+		c, err := client.New(aliceSM, "alice@sour.is")
 		is.NoErr(err)
 		is.True(c != nil)
 
 		var msg client.OnSaltyTextReceived
 		done := make(chan struct{})
-		client.On(c, func(ctx context.Context, args client.OnSaltyTextReceived) { msg = args; close(done) })
+		client.Subscribe(c, func(ctx context.Context, args client.OnSaltyTextReceived) error { msg = args; close(done); return nil })
 
 		err = c.Input(client.OnInput{1, string(saltyPayload)})
 		is.NoErr(err)
@@ -246,13 +221,9 @@ func TestClient(t *testing.T) {
 
 		is.Equal(msg.Msg.User.String(), "alice@sour.is")
 		is.Equal(msg.Msg.LiteralText(), "Hello, Alice.")
-
-		err = closeSession()
-		is.NoErr(err)
 	}
 }
 
-
 var requests httpMock
 
 type httpMock struct {
@@ -0,0 +1,179 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package driver_quic carries ratchet frames as unreliable QUIC DATAGRAM
+// frames (RFC 9221) instead of the reliable, ordered path transport/msgbus
+// uses. Each `!RAT!...!CHT!` blob is small, self-contained,
+// and already authenticated by the ratchet, so trading msgbus's
+// at-least-once delivery for sub-RTT, connectionless delivery is a fair
+// trade for interactive chat; messages that exceed the negotiated datagram
+// MTU fall back to a reliable stream.
+package driver_quic
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"crypto/tls"
+
+	quic "github.com/quic-go/quic-go"
+	"go.salty.im/ratchet/client"
+	"go.salty.im/ratchet/transport"
+)
+
+// Metrics tracks datagram health so the doubleratchet skipped-key buffer can
+// be tuned: a transport that is dropping or retransmitting a lot of
+// datagrams needs more headroom to tolerate out-of-order/missing messages.
+type Metrics struct {
+	Drops       uint64
+	Retransmits uint64
+}
+
+func (m *Metrics) addDrop()       { atomic.AddUint64(&m.Drops, 1) }
+func (m *Metrics) addRetransmit() { atomic.AddUint64(&m.Retransmits, 1) }
+
+// Driver is a transport.Transport and client.Driver backed by QUIC
+// datagrams.
+type Driver struct {
+	laddr   string
+	tlsConf *tls.Config
+	qconf   *quic.Config
+
+	Metrics Metrics
+
+	mu    sync.Mutex
+	dials map[string]quic.Connection
+
+	fn func(client.OnInput) error
+}
+
+// New returns a Driver listening on laddr for inbound connections and
+// dialing out on demand for Send.
+func New(laddr string, tlsConf *tls.Config) *Driver {
+	return &Driver{
+		laddr:   laddr,
+		tlsConf: tlsConf,
+		qconf:   &quic.Config{EnableDatagrams: true},
+		dials:   make(map[string]quic.Connection),
+	}
+}
+
+// WithQUIC wires d in as the Client's inbound Driver.
+func WithQUIC(d *Driver) client.Option {
+	return client.WithDriver(d)
+}
+
+// Run implements client.Driver, listening for peers and feeding every
+// received frame to the Client as an OnInput.
+func (d *Driver) Run(ctx context.Context) error {
+	d.fn = nil // set below once we have the Client's Input callback via OnInput hook.
+
+	ln, err := quic.ListenAddr(d.laddr, d.tlsConf, d.qconf)
+	if err != nil {
+		return fmt.Errorf("driver-quic: listen: %w", err)
+	}
+	defer ln.Close()
+
+	for {
+		conn, err := ln.Accept(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("driver-quic: accept: %w", err)
+		}
+
+		go d.acceptDatagrams(ctx, conn)
+	}
+}
+
+func (d *Driver) acceptDatagrams(ctx context.Context, conn quic.Connection) {
+	var pos int64
+	for {
+		payload, err := conn.ReceiveMessage(ctx)
+		if err != nil {
+			return
+		}
+		pos++
+		if d.fn != nil {
+			_ = d.fn(client.OnInput{Position: pos, Payload: string(payload)})
+		}
+	}
+}
+
+// Send implements transport.Transport, dialing endpoint if needed and
+// sending payload as a datagram. If payload exceeds the connection's
+// negotiated max datagram size it is instead written to a reliable stream.
+func (d *Driver) Send(ctx context.Context, endpoint string, payload []byte) error {
+	conn, err := d.dial(ctx, endpoint)
+	if err != nil {
+		return fmt.Errorf("driver-quic: dial %s: %w", endpoint, err)
+	}
+
+	if max := conn.ConnectionState().SupportsDatagrams; max {
+		if err := conn.SendMessage(payload); err == nil {
+			return nil
+		} else {
+			d.Metrics.addDrop()
+		}
+	}
+
+	d.Metrics.addRetransmit()
+	return d.sendStream(conn, payload)
+}
+
+func (d *Driver) sendStream(conn quic.Connection, payload []byte) error {
+	stream, err := conn.OpenStreamSync(context.Background())
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	_, err = stream.Write(payload)
+	return err
+}
+
+func (d *Driver) dial(ctx context.Context, endpoint string) (quic.Connection, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if conn, ok := d.dials[endpoint]; ok {
+		return conn, nil
+	}
+
+	conn, err := quic.DialAddr(ctx, endpoint, d.tlsConf, d.qconf)
+	if err != nil {
+		return nil, err
+	}
+
+	d.dials[endpoint] = conn
+	return conn, nil
+}
+
+// Subscribe implements transport.Transport by replaying this Driver's
+// received datagrams for inbox. QUIC datagrams have no notion of an inbox
+// name beyond "this listener"; from is honored on a best-effort basis since
+// datagrams are not durably stored.
+func (d *Driver) Subscribe(ctx context.Context, inbox string, from int64) (<-chan transport.Envelope, error) {
+	ch := make(chan transport.Envelope, 16)
+
+	d.fn = func(in client.OnInput) error {
+		if in.Position <= from {
+			return nil
+		}
+		select {
+		case ch <- transport.Envelope{ID: in.Position, Payload: []byte(in.Payload)}:
+		case <-ctx.Done():
+		}
+		return nil
+	}
+
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+
+	return ch, nil
+}
@@ -0,0 +1,156 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package client
+
+import (
+	"context"
+	"sort"
+	stdsync "sync"
+	"sync/atomic"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// DispatchMode controls how Emit calls the handlers registered for one event
+// type.
+type DispatchMode int
+
+const (
+	// Concurrent runs every handler for an event type in parallel, same as
+	// the original On/dispatch pair, and reports the first error. It is the
+	// default.
+	Concurrent DispatchMode = iota
+
+	// Sequential runs handlers for an event type one at a time, in priority
+	// order, stopping at the first error.
+	Sequential
+)
+
+// Subscription unregisters a handler added with Subscribe.
+type Subscription interface {
+	Unsubscribe()
+}
+
+type handlerEntry[T any] struct {
+	id       uint64
+	priority int
+	fn       func(context.Context, T) error
+}
+
+// handlerList holds every handler registered for one event type T, plus the
+// DispatchMode Emit should use for it. It's stored in Client.on behind a
+// single type assertion per event type, rather than asserting per handler.
+type handlerList[T any] struct {
+	mu    stdsync.Mutex
+	mode  DispatchMode
+	items []handlerEntry[T]
+}
+
+var subID uint64
+
+// SubscribeOption configures a Subscribe call.
+type SubscribeOption func(*subscribeConfig)
+
+type subscribeConfig struct {
+	priority int
+	mode     *DispatchMode
+}
+
+// WithPriority orders a handler relative to others subscribed for the same
+// event type: lower values come first. Sequential mode runs handlers in
+// that order; Concurrent mode starts them in that order but runs them in
+// parallel. The default priority is 0.
+func WithPriority(p int) SubscribeOption {
+	return func(c *subscribeConfig) { c.priority = p }
+}
+
+// WithMode sets the DispatchMode Emit uses for this event type. It applies
+// to every handler subscribed for T, not just the one being added; the most
+// recently applied WithMode wins.
+func WithMode(m DispatchMode) SubscribeOption {
+	return func(c *subscribeConfig) { c.mode = &m }
+}
+
+// Subscribe registers fn to run whenever Emit[T] is called on c, and returns
+// a Subscription that removes it. Handlers for a given T are ordered by
+// priority; see WithPriority and WithMode.
+func Subscribe[T any](c *Client, fn func(context.Context, T) error, opts ...SubscribeOption) Subscription {
+	var cfg subscribeConfig
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	hl := handlersFor[T](c)
+	id := atomic.AddUint64(&subID, 1)
+
+	hl.mu.Lock()
+	if cfg.mode != nil {
+		hl.mode = *cfg.mode
+	}
+	hl.items = append(hl.items, handlerEntry[T]{id: id, priority: cfg.priority, fn: fn})
+	sort.SliceStable(hl.items, func(i, j int) bool { return hl.items[i].priority < hl.items[j].priority })
+	hl.mu.Unlock()
+
+	return subscription{unsub: func() {
+		hl.mu.Lock()
+		defer hl.mu.Unlock()
+		for i, e := range hl.items {
+			if e.id == id {
+				hl.items = append(hl.items[:i:i], hl.items[i+1:]...)
+				break
+			}
+		}
+	}}
+}
+
+type subscription struct{ unsub func() }
+
+func (s subscription) Unsubscribe() { s.unsub() }
+
+// handlersFor returns c's handlerList for T, creating it on first use. It
+// keeps the zero-value-of-T trick the old On/dispatch pair used for keying,
+// but the map now stores one typed *handlerList[T] per key instead of a
+// []any of handlers, so Emit only ever type-asserts once per call.
+func handlersFor[T any](c *Client) *handlerList[T] {
+	var id T
+
+	c.onMu.Lock()
+	defer c.onMu.Unlock()
+
+	if hl, ok := c.on[id]; ok {
+		return hl.(*handlerList[T])
+	}
+	hl := &handlerList[T]{}
+	c.on[id] = hl
+	return hl
+}
+
+// Emit runs every handler Subscribed for T and reports the first error.
+// Unlike the dispatch it replaces, a handler's error is no longer discarded:
+// it propagates to Emit's caller, and in Concurrent mode (the default)
+// cancels the context passed to the other handlers still running.
+func Emit[T any](ctx context.Context, c *Client, evt T) error {
+	hl := handlersFor[T](c)
+
+	hl.mu.Lock()
+	items := make([]handlerEntry[T], len(hl.items))
+	copy(items, hl.items)
+	mode := hl.mode
+	hl.mu.Unlock()
+
+	if mode == Sequential {
+		for _, e := range items {
+			if err := e.fn(ctx, evt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	wg, ctx := errgroup.WithContext(ctx)
+	for i := range items {
+		fn := items[i].fn
+		wg.Go(func() error { return fn(ctx, evt) })
+	}
+	return wg.Wait()
+}
@@ -0,0 +1,530 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.salty.im/ratchet/group"
+)
+
+// Group chat frames ride inside the plaintext of an ordinary pairwise
+// ratchet session (see group.Session's doc comment): handleRatchet spots
+// them by the !GRP!...!PUG! wrapper below, same as the top-level !RAT!...
+// !CHT! wrapper spots a ratchet offer. Keeping them inside an already
+// established session means no new transport or key-agreement is needed
+// to bootstrap a group.
+const (
+	groupFramePrefix = "!GRP!"
+	groupFrameSuffix = "!PUG!"
+)
+
+const (
+	groupKindInvite  = "invite"
+	groupKindMessage = "msg"
+	groupKindLeave   = "leave"
+	groupKindRotate  = "rotate"
+)
+
+// groupFrame is the wire shape of everything group.go sends over a pairwise
+// session. Kind selects which of the optional fields are populated.
+type groupFrame struct {
+	Kind    string
+	Group   string
+	Members []string `json:",omitempty"`
+	From    string
+	Sender  *group.SenderKeyState `json:",omitempty"`
+	Frame   []byte                `json:",omitempty"`
+}
+
+func isGroupFrame(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(groupFramePrefix)) && bytes.HasSuffix(b, []byte(groupFrameSuffix))
+}
+
+func encodeGroupFrame(frame groupFrame) ([]byte, error) {
+	b, err := json.Marshal(frame)
+	if err != nil {
+		return nil, fmt.Errorf("marshal group frame: %w", err)
+	}
+
+	out := groupFramePrefix + base64.StdEncoding.EncodeToString(b) + groupFrameSuffix
+	return []byte(out), nil
+}
+
+func decodeGroupFrame(b []byte) (groupFrame, error) {
+	body := bytes.TrimSuffix(bytes.TrimPrefix(b, []byte(groupFramePrefix)), []byte(groupFrameSuffix))
+
+	raw, err := base64.StdEncoding.DecodeString(string(body))
+	if err != nil {
+		return groupFrame{}, fmt.Errorf("decode group frame: %w", err)
+	}
+
+	var frame groupFrame
+	if err := json.Unmarshal(raw, &frame); err != nil {
+		return groupFrame{}, fmt.Errorf("unmarshal group frame: %w", err)
+	}
+	return frame, nil
+}
+
+type OnGroupMemberJoined struct {
+	Group string
+	Who   string
+}
+type OnGroupMemberLeft struct {
+	Group string
+	Who   string
+}
+type OnGroupMessageSent struct {
+	Group string
+	Raw   string
+}
+type OnGroupMessageReceived struct {
+	Group string
+	Who   string
+	Raw   string
+}
+
+// CreateGroup starts a new group chat named name among members (which
+// should include c.Me()), and invites every other member over its existing
+// pairwise session with them. It fails if any member doesn't already have
+// an established session with c.
+func (c *Client) CreateGroup(ctx context.Context, name string, members []string) error {
+	me := c.Me().String()
+
+	var sess *group.Session
+	err := c.groups.Use(ctx, func(ctx context.Context, gm group.Manager) error {
+		var err error
+		sess, err = gm.New(me, name, members)
+		if err != nil {
+			return err
+		}
+		return gm.Put(sess)
+	})
+	if err != nil {
+		return fmt.Errorf("create group %s: %w", name, err)
+	}
+
+	own := sess.Senders[me]
+	for _, who := range members {
+		if who == me {
+			continue
+		}
+		if err := c.sendGroupFrame(ctx, who, groupFrame{
+			Kind:    groupKindInvite,
+			Group:   name,
+			Members: members,
+			From:    me,
+			Sender:  own,
+		}); err != nil {
+			return fmt.Errorf("invite %s to group %s: %w", who, name, err)
+		}
+	}
+
+	return nil
+}
+
+// JoinGroup records who's chain key for the group named name, creating a
+// local group.Session the first time any member of it is heard from. It is
+// the accept-path for an incoming invite (see dispatchGroupFrame) but is
+// also exported so a Session persisted by group.Manager can be resumed
+// without re-deriving it from the wire. It reports whether this call
+// created the local Session.
+func (c *Client) JoinGroup(ctx context.Context, who, name string, members []string, sender *group.SenderKeyState) (bool, error) {
+	me := c.Me().String()
+	created := false
+
+	err := c.groups.Use(ctx, func(ctx context.Context, gm group.Manager) error {
+		sess, err := gm.Get(name)
+		switch {
+		case errors.Is(err, group.ErrNotExist):
+			sess, err = group.New(me, name, members)
+			if err != nil {
+				return err
+			}
+			created = true
+		case err != nil:
+			return err
+		}
+
+		sess.AddMember(who, sender)
+		return gm.Put(sess)
+	})
+
+	return created, err
+}
+
+// LeaveGroup removes name from c's groups and tells every other member, so
+// they stop sealing to a chain key only c knows about.
+func (c *Client) LeaveGroup(ctx context.Context, name string) error {
+	me := c.Me().String()
+
+	var members []string
+	err := c.groups.Use(ctx, func(ctx context.Context, gm group.Manager) error {
+		sess, err := gm.Get(name)
+		if err != nil {
+			return err
+		}
+		members = append([]string(nil), sess.Members...)
+		return gm.Delete(sess)
+	})
+	if err != nil {
+		return fmt.Errorf("leave group %s: %w", name, err)
+	}
+
+	for _, who := range members {
+		if who == me {
+			continue
+		}
+		if err := c.sendGroupFrame(ctx, who, groupFrame{
+			Kind:  groupKindLeave,
+			Group: name,
+			From:  me,
+		}); err != nil {
+			return fmt.Errorf("notify %s of leaving group %s: %w", who, name, err)
+		}
+	}
+
+	return nil
+}
+
+// SendGroup seals text under the caller's own sender-key chain and relays
+// it directly to every other member over their pairwise session.
+func (c *Client) SendGroup(ctx context.Context, name, text string) error {
+	var sess *group.Session
+	err := c.groups.Use(ctx, func(ctx context.Context, gm group.Manager) error {
+		var err error
+		sess, err = gm.Get(name)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("send to group %s: %w", name, err)
+	}
+
+	sealed, err := sess.Seal([]byte(text))
+	if err != nil {
+		return fmt.Errorf("seal group message for %s: %w", name, err)
+	}
+
+	if err := c.groups.Use(ctx, func(ctx context.Context, gm group.Manager) error {
+		return gm.Put(sess)
+	}); err != nil {
+		return err
+	}
+
+	me := c.Me().String()
+	for _, who := range sess.Members {
+		if who == me {
+			continue
+		}
+		if err := c.sendGroupFrame(ctx, who, groupFrame{
+			Kind:  groupKindMessage,
+			Group: name,
+			From:  me,
+			Frame: sealed,
+		}); err != nil {
+			return fmt.Errorf("relay group message to %s: %w", who, err)
+		}
+	}
+
+	return Emit(ctx, c, OnGroupMessageSent{Group: name, Raw: text})
+}
+
+// sendGroupFrame wraps frame for the !GRP! sentinel and seals it through the
+// existing pairwise session with them, the same way Send seals a SaltyText.
+func (c *Client) sendGroupFrame(ctx context.Context, them string, frame groupFrame) error {
+	wire, err := encodeGroupFrame(frame)
+	if err != nil {
+		return err
+	}
+
+	return c.sm.Use(ctx, func(ctx context.Context, sm SessionManager) error {
+		session, err := sm.Get(sm.ByName(them))
+		if err != nil {
+			return fmt.Errorf("no established session with %s: %w", them, err)
+		}
+
+		data, err := session.Send(wire)
+		if err != nil {
+			return err
+		}
+
+		if err := c.sendMsg(ctx, session, data); err != nil {
+			return err
+		}
+
+		return sm.Put(session)
+	})
+}
+
+// dispatchGroupFrame is called from handleRatchet once a !GRP! frame has
+// come back from an established pairwise session's ReceiveMsg, so it never
+// has to touch the ratchet itself.
+func (c *Client) dispatchGroupFrame(ctx context.Context, them string, plaintext []byte) error {
+	frame, err := decodeGroupFrame(plaintext)
+	if err != nil {
+		return fmt.Errorf("decode group frame from %s: %w", them, err)
+	}
+
+	switch frame.Kind {
+	case groupKindInvite:
+		return c.handleGroupInvite(ctx, them, frame)
+	case groupKindMessage:
+		return c.handleGroupMessage(ctx, them, frame)
+	case groupKindLeave:
+		return c.handleGroupLeave(ctx, them, frame)
+	case groupKindRotate:
+		return c.handleGroupRotate(ctx, them, frame)
+	default:
+		return fmt.Errorf("group frame from %s: unknown kind %q", them, frame.Kind)
+	}
+}
+
+func (c *Client) handleGroupInvite(ctx context.Context, them string, frame groupFrame) error {
+	created, err := c.JoinGroup(ctx, them, frame.Group, frame.Members, frame.Sender)
+	if err != nil {
+		return fmt.Errorf("join group %s via %s: %w", frame.Group, them, err)
+	}
+
+	if err := Emit(ctx, c, OnGroupMemberJoined{Group: frame.Group, Who: them}); err != nil {
+		return err
+	}
+
+	if created {
+		// First time hearing about this group: reciprocate our own chain
+		// key directly to the inviter, so they can add us in turn.
+		own, err := c.groupSenderKey(ctx, frame.Group)
+		if err != nil {
+			return err
+		}
+		return c.sendGroupFrame(ctx, them, groupFrame{
+			Kind:    groupKindInvite,
+			Group:   frame.Group,
+			Members: frame.Members,
+			From:    c.Me().String(),
+			Sender:  own,
+		})
+	}
+
+	// We already knew this group: relay the newcomer's chain key to every
+	// other member we know, so the group converges on full mesh knowledge
+	// without everyone needing a session with everyone else up front.
+	return c.relayGroupMember(ctx, frame.Group, them, frame.Sender)
+}
+
+func (c *Client) handleGroupMessage(ctx context.Context, them string, frame groupFrame) error {
+	if frame.From != them {
+		return fmt.Errorf("group message in %s: frame claims sender %s, session is with %s", frame.Group, frame.From, them)
+	}
+
+	var plaintext []byte
+	err := c.groups.Use(ctx, func(ctx context.Context, gm group.Manager) error {
+		sess, err := gm.Get(frame.Group)
+		if err != nil {
+			return err
+		}
+
+		plaintext, err = sess.Open(frame.From, frame.Frame)
+		if err != nil {
+			return err
+		}
+
+		return gm.Put(sess)
+	})
+	if err != nil {
+		return fmt.Errorf("open group message in %s from %s: %w", frame.Group, frame.From, err)
+	}
+
+	return Emit(ctx, c, OnGroupMessageReceived{
+		Group: frame.Group,
+		Who:   frame.From,
+		Raw:   string(plaintext),
+	})
+}
+
+func (c *Client) handleGroupLeave(ctx context.Context, them string, frame groupFrame) error {
+	if frame.From != them {
+		return fmt.Errorf("leave group %s: frame claims sender %s, session is with %s", frame.Group, frame.From, them)
+	}
+
+	err := c.groups.Use(ctx, func(ctx context.Context, gm group.Manager) error {
+		sess, err := gm.Get(frame.Group)
+		if err != nil {
+			return err
+		}
+		sess.RemoveMember(frame.From)
+		return gm.Put(sess)
+	})
+	if err != nil {
+		return fmt.Errorf("remove %s from group %s: %w", frame.From, frame.Group, err)
+	}
+
+	if err := Emit(ctx, c, OnGroupMemberLeft{Group: frame.Group, Who: frame.From}); err != nil {
+		return err
+	}
+
+	// frame.From can no longer reach a key it doesn't already have, but
+	// SenderKeyState.advance() is a pure one-way hash: frame.From could
+	// otherwise keep forward-deriving everyone else's chain key forever
+	// from what it already received. Rotate ours and hand the replacement
+	// to every remaining member directly.
+	return c.rotateSenderKey(ctx, frame.Group)
+}
+
+// handleGroupRotate records a member's replacement SenderKeyState sent by
+// rotateSenderKey, the same way AddMember records one learned at invite
+// time.
+func (c *Client) handleGroupRotate(ctx context.Context, them string, frame groupFrame) error {
+	if frame.From != them {
+		return fmt.Errorf("rotate sender key in group %s: frame claims sender %s, session is with %s", frame.Group, frame.From, them)
+	}
+
+	err := c.groups.Use(ctx, func(ctx context.Context, gm group.Manager) error {
+		sess, err := gm.Get(frame.Group)
+		if err != nil {
+			return err
+		}
+		sess.AddMember(frame.From, frame.Sender)
+		return gm.Put(sess)
+	})
+	if err != nil {
+		return fmt.Errorf("rotate sender key for %s in group %s: %w", frame.From, frame.Group, err)
+	}
+	return nil
+}
+
+// rotateSenderKey replaces our own SenderKeyState for the group named name
+// with a freshly randomized one and redistributes it to every other member,
+// so a member who just left -- who already knows our old chain key -- can
+// no longer derive any message key sent after they left.
+func (c *Client) rotateSenderKey(ctx context.Context, name string) error {
+	me := c.Me().String()
+
+	own, err := group.NewSenderKeyState()
+	if err != nil {
+		return fmt.Errorf("rotate sender key for group %s: %w", name, err)
+	}
+
+	var others []string
+	err = c.groups.Use(ctx, func(ctx context.Context, gm group.Manager) error {
+		sess, err := gm.Get(name)
+		if err != nil {
+			return err
+		}
+		sess.Senders[me] = own
+		others = append([]string(nil), sess.Members...)
+		return gm.Put(sess)
+	})
+	if err != nil {
+		return fmt.Errorf("rotate sender key for group %s: %w", name, err)
+	}
+
+	for _, who := range others {
+		if who == me {
+			continue
+		}
+		if err := c.sendGroupFrame(ctx, who, groupFrame{
+			Kind:   groupKindRotate,
+			Group:  name,
+			From:   me,
+			Sender: own,
+		}); err != nil {
+			return fmt.Errorf("redistribute rotated sender key to %s: %w", who, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) groupSenderKey(ctx context.Context, name string) (*group.SenderKeyState, error) {
+	me := c.Me().String()
+
+	var own *group.SenderKeyState
+	err := c.groups.Use(ctx, func(ctx context.Context, gm group.Manager) error {
+		sess, err := gm.Get(name)
+		if err != nil {
+			return err
+		}
+		own = sess.Senders[me]
+		return nil
+	})
+	return own, err
+}
+
+func (c *Client) relayGroupMember(ctx context.Context, name, newMember string, sender *group.SenderKeyState) error {
+	me := c.Me().String()
+
+	var others []string
+	err := c.groups.Use(ctx, func(ctx context.Context, gm group.Manager) error {
+		sess, err := gm.Get(name)
+		if err != nil {
+			return err
+		}
+		others = append([]string(nil), sess.Members...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, who := range others {
+		if who == me || who == newMember {
+			continue
+		}
+		if err := c.sendGroupFrame(ctx, who, groupFrame{
+			Kind:   groupKindInvite,
+			Group:  name,
+			From:   newMember,
+			Sender: sender,
+		}); err != nil {
+			return fmt.Errorf("relay %s's key to %s: %w", newMember, who, err)
+		}
+	}
+
+	return nil
+}
+
+// handleGroupMemberDeparture drops a pairwise peer from every group they
+// belonged to once their session is closed, so a former member's key isn't
+// kept around after there's no longer any way to tell them they've left.
+func (c *Client) handleGroupMemberDeparture(ctx context.Context, in OnSessionClosed) error {
+	var left []string
+	err := c.groups.Use(ctx, func(ctx context.Context, gm group.Manager) error {
+		for _, name := range gm.Groups() {
+			sess, err := gm.Get(name)
+			if err != nil {
+				return err
+			}
+			if _, ok := sess.Senders[in.Them]; !ok {
+				continue
+			}
+			sess.RemoveMember(in.Them)
+			if err := gm.Put(sess); err != nil {
+				return err
+			}
+			left = append(left, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range left {
+		if err := Emit(ctx, c, OnGroupMemberLeft{Group: name, Who: in.Them}); err != nil {
+			return err
+		}
+		// Same reasoning as handleGroupLeave: in.Them's pairwise session
+		// closed without an explicit /leave, but they still know our
+		// pre-departure chain key, so rotate it out from under them.
+		if err := c.rotateSenderKey(ctx, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
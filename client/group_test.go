@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matryer/is"
+	"go.salty.im/ratchet/group"
+	"go.salty.im/ratchet/locker"
+)
+
+// newGroupTestClient returns a *Client whose only wired-up field is groups,
+// enough to exercise the handleGroup* frame handlers without a real
+// SessionManager or saltyim address.
+func newGroupTestClient(t *testing.T, sess *group.Session) *Client {
+	t.Helper()
+	gm := group.NewMemManager()
+	if err := gm.Put(sess); err != nil {
+		t.Fatal(err)
+	}
+	return &Client{groups: locker.New[group.Manager](gm)}
+}
+
+// TestHandleGroupMessageRejectsSpoofedFrom checks that a frame whose From
+// doesn't match the authenticated pairwise-session peer it arrived over is
+// rejected rather than opened under the claimed sender's key -- otherwise
+// any group member could forge From to impersonate another member.
+func TestHandleGroupMessageRejectsSpoofedFrom(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	sess, err := group.New("alice", "crew", []string{"alice", "bob", "carol"})
+	is.NoErr(err)
+
+	carolSender, err := group.NewSenderKeyState()
+	is.NoErr(err)
+	sess.AddMember("carol", &group.SenderKeyState{ChainKey: carolSender.ChainKey, ChainNo: carolSender.ChainNo})
+
+	sealed, err := carolSender.Seal([]byte("hi from carol"))
+	is.NoErr(err)
+
+	c := newGroupTestClient(t, sess)
+
+	// bob's pairwise session delivered this frame, but it claims to be from
+	// carol: must be rejected, not opened against carol's sender key.
+	err = c.handleGroupMessage(ctx, "bob", groupFrame{Kind: groupKindMessage, Group: "crew", From: "carol", Frame: sealed})
+	is.True(err != nil)
+}
+
+// TestHandleGroupLeaveRejectsSpoofedFrom checks that a leave frame claiming
+// to be from someone other than the authenticated pairwise peer can't kick
+// an arbitrary member out of the group.
+func TestHandleGroupLeaveRejectsSpoofedFrom(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	sess, err := group.New("alice", "crew", []string{"alice", "bob", "carol"})
+	is.NoErr(err)
+
+	c := newGroupTestClient(t, sess)
+
+	err = c.handleGroupLeave(ctx, "bob", groupFrame{Kind: groupKindLeave, Group: "crew", From: "carol"})
+	is.True(err != nil)
+
+	err = c.groups.Use(ctx, func(ctx context.Context, gm group.Manager) error {
+		got, err := gm.Get("crew")
+		is.NoErr(err)
+		_, stillMember := got.Senders["carol"]
+		is.True(stillMember)
+		return nil
+	})
+	is.NoErr(err)
+}
+
+// TestHandleGroupRotateRejectsSpoofedFrom checks that a rotate frame can't
+// install an attacker-chosen SenderKeyState under another member's name.
+func TestHandleGroupRotateRejectsSpoofedFrom(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	sess, err := group.New("alice", "crew", []string{"alice", "bob", "carol"})
+	is.NoErr(err)
+
+	original, err := group.NewSenderKeyState()
+	is.NoErr(err)
+	sess.AddMember("carol", original)
+
+	c := newGroupTestClient(t, sess)
+
+	forged, err := group.NewSenderKeyState()
+	is.NoErr(err)
+
+	err = c.handleGroupRotate(ctx, "bob", groupFrame{Kind: groupKindRotate, Group: "crew", From: "carol", Sender: forged})
+	is.True(err != nil)
+
+	err = c.groups.Use(ctx, func(ctx context.Context, gm group.Manager) error {
+		got, err := gm.Get("crew")
+		is.NoErr(err)
+		is.Equal(got.Senders["carol"], original)
+		return nil
+	})
+	is.NoErr(err)
+}
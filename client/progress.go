@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// OnProgress reports one phase of a multi-step operation — Chat, SendSalty,
+// or the inbound handleRatchet/handleSaltPack dispatch — so a TUI can
+// render live status instead of blocking silently on c.sm.Use. OpID is
+// shared by every OnProgress belonging to one call and lets a listener
+// group them back together; Phase names are call-specific (see the doc
+// comment on each) and always arrive in the order the call actually
+// executes them. ID is the session's ULID once one is known, and is the
+// zero ULID for phases that run before one exists.
+type OnProgress struct {
+	OpID  ulid.ULID
+	Phase string
+	ID    ulid.ULID
+	Them  string
+	Start time.Time
+	End   time.Time
+	Err   error
+}
+
+// progress runs fn as one named phase of op, timing it and emitting an
+// OnProgress whether or not fn succeeds. It returns fn's error unchanged so
+// call sites keep their existing early-return-on-error shape.
+func (c *Client) progress(ctx context.Context, op ulid.ULID, them, phase string, id ulid.ULID, fn func() error) error {
+	start := time.Now()
+	err := fn()
+
+	_ = Emit(ctx, c, OnProgress{
+		OpID:  op,
+		Phase: phase,
+		ID:    id,
+		Them:  them,
+		Start: start,
+		End:   time.Now(),
+		Err:   err,
+	})
+
+	return err
+}
+
+// Progress subscribes to every OnProgress c emits and forwards them to the
+// returned channel until ctx is canceled, at which point the channel is
+// closed and the subscription removed. The channel is buffered so a slow
+// reader doesn't stall the operation it's reporting on; once full,
+// additional events are dropped rather than blocking.
+func (c *Client) Progress(ctx context.Context) <-chan OnProgress {
+	ch := make(chan OnProgress, 16)
+
+	sub := Subscribe(c, func(ctx context.Context, e OnProgress) error {
+		select {
+		case ch <- e:
+		default:
+		}
+		return nil
+	})
+
+	go func() {
+		<-ctx.Done()
+		sub.Unsubscribe()
+		close(ch)
+	}()
+
+	return ch
+}
@@ -0,0 +1,261 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package rpc is the wire protocol and a thin client for ratchet's daemon
+// mode (see go.salty.im/ratchet/daemon): JSON-RPC 2.0 over a long-lived
+// connection (a Unix socket or a TCP port), one call per interactive
+// command (chat, send, close, salty, sessions) plus a subscribe call that
+// turns the connection into a stream of event notifications. A caller only
+// needs this package and a net.Conn — not client, session, or xochimilco —
+// so a bot, a notification daemon, or an editor plugin can drive a running
+// ratchet without linking the ratchet protocol stack or managing its state
+// files directly.
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	stdsync "sync"
+)
+
+// Request is one JSON-RPC 2.0 call. A zero ID marks a notification, which
+// the server answers with nothing rather than a Response.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      uint64          `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Response answers one Request by ID. Exactly one of Result or Error is set.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      uint64          `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Notification is an unsolicited, unanswered push from the server: one of
+// the client.On* events the daemon's connection subscribed to, with Method
+// set to that event's type name (e.g. "OnMessageReceived") and Params its
+// JSON encoding.
+type Notification struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Error is a JSON-RPC 2.0 error object. Code follows the JSON-RPC
+// convention only loosely: the daemon returns -32000 for every method
+// failure, since the underlying errors (lookup failure, no such session,
+// ...) don't map cleanly onto the reserved -32700..-32600 range.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Method parameter and result shapes, shared by the daemon and this client
+// so neither has to guess the other's JSON layout.
+type (
+	ChatParams struct {
+		Them string `json:"them"`
+	}
+	ChatResult struct {
+		Established bool `json:"established"`
+	}
+	SendParams struct {
+		Them string `json:"them"`
+		Text string `json:"text"`
+	}
+	CloseParams struct {
+		Them string `json:"them"`
+	}
+	SaltyParams struct {
+		Them string `json:"them"`
+		Text string `json:"text"`
+	}
+	SessionInfo struct {
+		Name string `json:"name"`
+		ID   string `json:"id"`
+	}
+	SessionsResult struct {
+		Sessions []SessionInfo `json:"sessions"`
+	}
+	SubscribeResult struct {
+		Subscribed bool `json:"subscribed"`
+	}
+)
+
+// Client is a JSON-RPC connection to a ratchet daemon. It is safe for
+// concurrent use: Call may be invoked from multiple goroutines while
+// Notifications is being drained by another.
+type Client struct {
+	conn net.Conn
+	dec  *json.Decoder
+
+	writeMu stdsync.Mutex
+
+	idMu    stdsync.Mutex
+	nextID  uint64
+	pending map[uint64]chan Response
+
+	notify chan Notification
+}
+
+// Dial connects to a ratchet daemon listening on network/addr (e.g.
+// "unix", "$XDG_RUNTIME_DIR/ratchet.sock", or "tcp", "localhost:4242") and
+// starts reading its responses and notifications in the background.
+func Dial(network, addr string) (*Client, error) {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn:    conn,
+		dec:     json.NewDecoder(bufio.NewReader(conn)),
+		pending: make(map[uint64]chan Response),
+		notify:  make(chan Notification, 16),
+	}
+	go c.readLoop()
+
+	return c, nil
+}
+
+// Close closes the underlying connection, ending readLoop and closing the
+// Notifications channel.
+func (c *Client) Close() error { return c.conn.Close() }
+
+// Notifications returns the channel event pushes from the daemon arrive on.
+// It is closed when the connection is closed. Call Subscribe first, or
+// nothing will arrive on it.
+func (c *Client) Notifications() <-chan Notification { return c.notify }
+
+func (c *Client) readLoop() {
+	defer close(c.notify)
+
+	for {
+		var raw json.RawMessage
+		if err := c.dec.Decode(&raw); err != nil {
+			return
+		}
+
+		var probe struct {
+			ID     uint64 `json:"id"`
+			Method string `json:"method"`
+		}
+		if err := json.Unmarshal(raw, &probe); err != nil {
+			continue
+		}
+
+		if probe.Method != "" {
+			var n Notification
+			if err := json.Unmarshal(raw, &n); err == nil {
+				c.notify <- n
+			}
+			continue
+		}
+
+		var resp Response
+		if err := json.Unmarshal(raw, &resp); err != nil {
+			continue
+		}
+
+		c.idMu.Lock()
+		ch, ok := c.pending[resp.ID]
+		delete(c.pending, resp.ID)
+		c.idMu.Unlock()
+
+		if ok {
+			ch <- resp
+		}
+	}
+}
+
+// Call invokes method with params and decodes its result into result, which
+// should be a pointer (or nil to discard the result).
+func (c *Client) Call(ctx context.Context, method string, params, result any) error {
+	p, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	c.idMu.Lock()
+	c.nextID++
+	id := c.nextID
+	ch := make(chan Response, 1)
+	c.pending[id] = ch
+	c.idMu.Unlock()
+
+	req := Request{JSONRPC: "2.0", ID: id, Method: method, Params: p}
+
+	c.writeMu.Lock()
+	err = json.NewEncoder(c.conn).Encode(req)
+	c.writeMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case resp := <-ch:
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result == nil || len(resp.Result) == 0 {
+			return nil
+		}
+		return json.Unmarshal(resp.Result, result)
+	}
+}
+
+// Chat starts or resumes a ratchet session with them, same as the
+// interactive /chat command.
+func (c *Client) Chat(ctx context.Context, them string) (bool, error) {
+	var res ChatResult
+	err := c.Call(ctx, "chat", ChatParams{Them: them}, &res)
+	return res.Established, err
+}
+
+// Send sends text over an already-established session with them.
+func (c *Client) Send(ctx context.Context, them, text string) error {
+	return c.Call(ctx, "send", SendParams{Them: them, Text: text}, nil)
+}
+
+// CloseSession tears down the session with them. Named CloseSession rather
+// than Close to keep it distinct from the Client's own connection Close.
+func (c *Client) CloseSession(ctx context.Context, them string) error {
+	return c.Call(ctx, "close", CloseParams{Them: them}, nil)
+}
+
+// Salty sends text as a one-shot SaltPack message, bypassing ratchet
+// sessions entirely.
+func (c *Client) Salty(ctx context.Context, them, text string) error {
+	return c.Call(ctx, "salty", SaltyParams{Them: them, Text: text}, nil)
+}
+
+// Sessions lists the daemon's currently open sessions.
+func (c *Client) Sessions(ctx context.Context) ([]SessionInfo, error) {
+	var res SessionsResult
+	err := c.Call(ctx, "sessions", struct{}{}, &res)
+	return res.Sessions, err
+}
+
+// Subscribe asks the daemon to start pushing event notifications to this
+// connection; they arrive on Notifications.
+func (c *Client) Subscribe(ctx context.Context) error {
+	var res SubscribeResult
+	if err := c.Call(ctx, "subscribe", struct{}{}, &res); err != nil {
+		return err
+	}
+	if !res.Subscribed {
+		return fmt.Errorf("rpc: daemon declined subscribe")
+	}
+	return nil
+}
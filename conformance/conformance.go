@@ -0,0 +1,177 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package conformance is a black-box compliance suite for client.Client's
+// wire dispatch, modeled on the gateway-conformance pattern: a Vector
+// declares an ordered list of inbound frames and the events/outbound sends
+// they must produce, independent of any one Go implementation detail, so a
+// future non-Go client can target the same vectors.
+//
+// Vectors are plain JSON rather than YAML, since the repo carries no YAML
+// dependency and the wire frames themselves (group.go's groupFrame, sync's
+// envelope, ...) are already encoded that way. The request that asked for
+// this package also named a `msgbusHandler` entry point and an
+// `OnOtherReceived` event; neither exists under those names any more (the
+// dispatch surface is Client.Input plus the typed event bus in event.go,
+// and the catch-all event is OnReceived), so Run drives Input directly and
+// Recorder maps OnOtherReceived's intent onto OnReceived.
+//
+// Vectors that need a live ratchet session (the offer/ack handshake,
+// out-of-order data, a replayed ID, a close race) can't be authored as
+// static ciphertext by hand, so those aren't shipped as files here; they
+// belong in a _test.go that derives real frames from a running Client the
+// way client_test.go does, then feeds them to Client.Input and checks what
+// a Recorder saw (see handshake_test.go). The vectors
+// directory holds only the frames that are meaningful independent of any
+// prior handshake: malformed input and salty-vs-ratchet discrimination.
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.salty.im/ratchet/client"
+)
+
+// Frame is one inbound OnInput, with an optional expectation that Input
+// itself returns (or does not return) an error.
+type Frame struct {
+	ID      int64  `json:"id"`
+	Payload string `json:"payload"`
+	WantErr bool   `json:"wantErr,omitempty"`
+}
+
+// Record is one dispatched event, flattened to the fields any event this
+// suite watches for can need; fields that don't apply to a given Event are
+// left zero.
+type Record struct {
+	Event string `json:"event"`
+	Them  string `json:"them,omitempty"`
+	Raw   string `json:"raw,omitempty"`
+}
+
+// Vector is a single fixture: an ordered list of inbound Frames and the
+// Records Recorder must have accumulated once they've all been fed to
+// Input.
+type Vector struct {
+	Name   string   `json:"name"`
+	Frames []Frame  `json:"frames"`
+	Expect []Record `json:"expect"`
+}
+
+// Load reads every *.json file in dir as a Vector.
+func Load(dir string) ([]Vector, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("conformance: load %s: %w", dir, err)
+	}
+
+	var vectors []Vector
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("conformance: load %s: %w", e.Name(), err)
+		}
+
+		var v Vector
+		if err := json.Unmarshal(b, &v); err != nil {
+			return nil, fmt.Errorf("conformance: %s: %w", e.Name(), err)
+		}
+		vectors = append(vectors, v)
+	}
+
+	return vectors, nil
+}
+
+// Recorder subscribes to every event a Vector's Expect can reference and
+// appends a Record for each, in dispatch order.
+type Recorder struct {
+	mu      sync.Mutex
+	Records []Record
+}
+
+// NewRecorder wires r's Subscribe calls onto c and returns it.
+func NewRecorder(c *client.Client) *Recorder {
+	r := &Recorder{}
+
+	client.Subscribe(c, func(ctx context.Context, e client.OnOfferReceived) error {
+		r.add(Record{Event: "OnOfferReceived", Them: e.Them})
+		return nil
+	})
+	client.Subscribe(c, func(ctx context.Context, e client.OnSessionStarted) error {
+		r.add(Record{Event: "OnSessionStarted", Them: e.Them})
+		return nil
+	})
+	client.Subscribe(c, func(ctx context.Context, e client.OnMessageReceived) error {
+		r.add(Record{Event: "OnMessageReceived", Them: e.Them, Raw: e.Msg.LiteralText()})
+		return nil
+	})
+	client.Subscribe(c, func(ctx context.Context, e client.OnSessionClosed) error {
+		r.add(Record{Event: "OnSessionClosed", Them: e.Them})
+		return nil
+	})
+	client.Subscribe(c, func(ctx context.Context, e client.OnSaltyTextReceived) error {
+		r.add(Record{Event: "OnSaltyTextReceived", Raw: e.Msg.LiteralText()})
+		return nil
+	})
+	client.Subscribe(c, func(ctx context.Context, e client.OnReceived) error {
+		r.add(Record{Event: "OnReceived", Raw: e.Raw})
+		return nil
+	})
+
+	return r
+}
+
+func (r *Recorder) add(rec Record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Records = append(r.Records, rec)
+}
+
+// FakeTransport is a client.Transport that records every payload it's
+// asked to send instead of delivering it anywhere.
+type FakeTransport struct {
+	mu   sync.Mutex
+	Sent []Record
+}
+
+// Send implements client.Transport.
+func (t *FakeTransport) Send(ctx context.Context, endpoint, payload string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Sent = append(t.Sent, Record{Event: "Send", Them: endpoint, Raw: payload})
+	return nil
+}
+
+// Result is what Run observed: every dispatched event, in order, and every
+// error Input returned, also in order and aligned 1:1 with v.Frames.
+type Result struct {
+	Records []Record
+	Errs    []error
+}
+
+// Run feeds v's Frames through c.Input in order, recording the events
+// Recorder observes and the error (if any) each Input call returns. It
+// does not itself assert anything against v.Expect or Frame.WantErr — that
+// belongs to the caller, since a mismatch is a test failure, not a
+// Run error.
+func Run(c *client.Client, v Vector) Result {
+	r := NewRecorder(c)
+
+	var res Result
+	for _, f := range v.Frames {
+		err := c.Input(client.OnInput{Position: f.ID, Payload: f.Payload})
+		res.Errs = append(res.Errs, err)
+	}
+	res.Records = r.Records
+
+	return res
+}
@@ -0,0 +1,145 @@
+package conformance_test
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/foxcpp/go-mockdns"
+	"github.com/keys-pub/keys"
+	"github.com/matryer/is"
+	"go.salty.im/ratchet/client"
+	"go.salty.im/ratchet/conformance"
+	"go.salty.im/ratchet/session"
+)
+
+var (
+	alice   *keys.EdX25519Key
+	bob     *keys.EdX25519Key
+	aliceSM *session.MemorySessionManager
+	bobSM   *session.MemorySessionManager
+
+	requests httpMock
+)
+
+func TestMain(m *testing.M) {
+	var err error
+
+	alice = keys.GenerateEdX25519Key()
+	bob = keys.GenerateEdX25519Key()
+
+	// One MemorySessionManager per identity, reused across every subtest the
+	// same way client_test.go reuses them across its blocks: each Client.New
+	// stands in for a separate invocation of that identity, so the manager
+	// has to outlive it.
+	aliceSM, _, err = session.NewMemorySessionManager("alice@sour.is", alice)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bobSM, _, err = session.NewMemorySessionManager("bob@sour.is", bob)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	http.DefaultClient.Transport = &requests
+
+	requests.fn = func(r *http.Request) (*http.Response, error) {
+		switch r.URL.String() {
+		case "https://ev.sour.is/.well-known/salty/828c20c06628c46014048f6ddf2d7f89f3bedf667240398f08e47fb13dfabfe9.json":
+			return &http.Response{
+				Status:     http.StatusText(http.StatusOK),
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(`{"endpoint":"https://ev.sour.is/inbox/01GPYAZ0GX8VCPK9CFEDPA1QG0","key": "` + alice.PublicKey().String() + `"}`)),
+			}, nil
+		case "https://ev.sour.is/.well-known/salty/f202c7f09045e1bea055c4bef3e585cf9c74e21a342a59dedd505d09dac53ba7.json":
+			return &http.Response{
+				Status:     http.StatusText(http.StatusOK),
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(`{"endpoint":"https://ev.sour.is/inbox/01GPYAXX53N6GCKJV2BPJGTQPB","key":"` + bob.PublicKey().String() + `"}`)),
+			}, nil
+		case "https://ev.sour.is/inbox/01GPYAXX53N6GCKJV2BPJGTQPB", "https://ev.sour.is/inbox/01GPYAZ0GX8VCPK9CFEDPA1QG0":
+			return &http.Response{
+				Status:     http.StatusText(http.StatusAccepted),
+				StatusCode: http.StatusAccepted,
+				Body:       io.NopCloser(strings.NewReader(`{}`)),
+			}, nil
+		}
+		return &http.Response{Status: http.StatusText(http.StatusNotFound), StatusCode: http.StatusNotFound}, nil
+	}
+	defer func() { http.DefaultClient = &http.Client{} }()
+
+	srv, _ := mockdns.NewServer(map[string]mockdns.Zone{
+		"_salty._tcp.sour.is.": {
+			SRV: []net.SRV{{Target: "test.sour.is.", Port: 443}},
+		},
+	}, false)
+	defer srv.Close()
+
+	os.Exit(m.Run())
+}
+
+// TestVectors runs every static fixture in vectors/ against a fresh Client:
+// the ones that don't depend on a prior handshake having already run (see
+// the package doc comment for why the handshake-dependent vectors live in
+// handshake_test.go instead).
+func TestVectors(t *testing.T) {
+	is := is.New(t)
+
+	vectors, err := conformance.Load("vectors")
+	is.NoErr(err)
+	is.True(len(vectors) > 0)
+
+	for _, v := range vectors {
+		v := v
+		t.Run(v.Name, func(t *testing.T) {
+			is := is.New(t)
+
+			c, err := client.New(aliceSM, "alice@sour.is")
+			is.NoErr(err)
+
+			res := conformance.Run(c, v)
+			for i, f := range v.Frames {
+				if f.WantErr {
+					is.True(res.Errs[i] != nil)
+				} else {
+					is.NoErr(res.Errs[i])
+				}
+			}
+
+			is.Equal(len(res.Records), len(v.Expect))
+			for i, want := range v.Expect {
+				is.Equal(res.Records[i], want)
+			}
+		})
+	}
+}
+
+// lastPayload reads back the body of the nth captured outbound request, the
+// same way client_test.go slices requests.reqs to recover a wire frame one
+// Client sent so another can be fed it via Input.
+func lastPayload(t *testing.T, n int) string {
+	t.Helper()
+
+	if n >= len(requests.reqs) {
+		t.Fatalf("only %d requests captured, want index %d", len(requests.reqs), n)
+	}
+	b, err := io.ReadAll(requests.reqs[n].Body)
+	if err != nil {
+		t.Fatalf("read captured request %d: %v", n, err)
+	}
+	return string(b)
+}
+
+type httpMock struct {
+	fn   func(*http.Request) (*http.Response, error)
+	reqs []*http.Request
+}
+
+func (m *httpMock) RoundTrip(r *http.Request) (*http.Response, error) {
+	m.reqs = append(m.reqs, r)
+	return m.fn(r)
+}
@@ -0,0 +1,121 @@
+package conformance_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matryer/is"
+	"go.salty.im/ratchet/client"
+	"go.salty.im/ratchet/conformance"
+)
+
+// TestHandshakeConformance exercises the vectors that need a live ratchet
+// session to produce valid ciphertext for: the offer/ack handshake,
+// out-of-order data delivery, replay of an already-consumed message, and a
+// close racing with in-flight data. Each block stands in for a separate
+// invocation of that identity, reusing aliceSM/bobSM across blocks the same
+// way client_test.go does, and pulls the wire frame a block needs out of
+// requests.reqs the way client_test.go recovers one Client's outbound send
+// for another to consume.
+func TestHandshakeConformance(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	var offerPayload, msg1Payload, msg2Payload, closePayload string
+
+	{ // offer/ack handshake: alice offers, bob acks and replies.
+		c, err := client.New(aliceSM, "alice@sour.is")
+		is.NoErr(err)
+
+		rec := conformance.NewRecorder(c)
+
+		ok, err := c.Chat(ctx, "bob@sour.is")
+		is.NoErr(err)
+		is.True(!ok) // no session yet; this call only sent the offer
+
+		is.Equal(len(rec.Records), 0)
+	}
+
+	offerPayload = lastPayload(t, len(requests.reqs)-1)
+
+	{ // bob receives the offer, acks, and sends the first message.
+		c, err := client.New(bobSM, "bob@sour.is")
+		is.NoErr(err)
+
+		rec := conformance.NewRecorder(c)
+
+		err = c.Input(client.OnInput{Position: 1, Payload: offerPayload})
+		is.NoErr(err)
+
+		is.Equal(len(rec.Records), 1)
+		is.Equal(rec.Records[0], conformance.Record{Event: "OnOfferReceived", Them: "alice@sour.is"})
+
+		ok, err := c.Chat(ctx, "alice@sour.is")
+		is.NoErr(err)
+		is.True(ok) // the offer is now acked and the session is live
+
+		err = c.Send(ctx, "alice@sour.is", "message one")
+		is.NoErr(err)
+		err = c.Send(ctx, "alice@sour.is", "message two")
+		is.NoErr(err)
+	}
+
+	ackPayload := lastPayload(t, len(requests.reqs)-3)
+	msg1Payload = lastPayload(t, len(requests.reqs)-2)
+	msg2Payload = lastPayload(t, len(requests.reqs)-1)
+
+	{ // alice receives the ack and both messages out of order: msg2 before
+		// msg1. The ratchet must still deliver both, buffering msg1's skipped
+		// key until msg1 itself arrives.
+		c, err := client.New(aliceSM, "alice@sour.is")
+		is.NoErr(err)
+
+		rec := conformance.NewRecorder(c)
+
+		err = c.Input(client.OnInput{Position: 1, Payload: ackPayload})
+		is.NoErr(err)
+
+		err = c.Input(client.OnInput{Position: 2, Payload: msg2Payload})
+		is.NoErr(err)
+		err = c.Input(client.OnInput{Position: 3, Payload: msg1Payload})
+		is.NoErr(err)
+
+		is.Equal(len(rec.Records), 3)
+		is.Equal(rec.Records[0], conformance.Record{Event: "OnSessionStarted", Them: "bob@sour.is"})
+		is.Equal(rec.Records[1].Event, "OnMessageReceived")
+		is.Equal(rec.Records[1].Them, "bob@sour.is")
+		is.Equal(rec.Records[1].Raw, "message two") // delivered as soon as it arrives
+		is.Equal(rec.Records[2].Event, "OnMessageReceived")
+		is.Equal(rec.Records[2].Raw, "message one") // delivered from the skipped-key buffer
+
+		// Replaying msg1 a second time must not re-decrypt or re-dispatch:
+		// its message key was already consumed.
+		err = c.Input(client.OnInput{Position: 4, Payload: msg1Payload})
+		is.True(err != nil)
+		is.Equal(len(rec.Records), 3)
+
+		err = c.Close(ctx, "bob@sour.is")
+		is.NoErr(err)
+	}
+
+	closePayload = lastPayload(t, len(requests.reqs)-1)
+
+	{ // close races with an in-flight data message: bob gets the close frame
+		// first, then a (now stale) data frame arrives for the session it
+		// just tore down.
+		c, err := client.New(bobSM, "bob@sour.is")
+		is.NoErr(err)
+
+		rec := conformance.NewRecorder(c)
+
+		err = c.Input(client.OnInput{Position: 2, Payload: closePayload})
+		is.NoErr(err)
+
+		is.Equal(len(rec.Records), 1)
+		is.Equal(rec.Records[0], conformance.Record{Event: "OnSessionClosed", Them: "alice@sour.is"})
+
+		err = c.Input(client.OnInput{Position: 3, Payload: msg2Payload})
+		is.True(err != nil) // the session is gone; this must error, not panic
+		is.Equal(len(rec.Records), 1)
+	}
+}
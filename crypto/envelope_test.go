@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package crypto_test
+
+import (
+	"bytes"
+	"testing"
+
+	"go.salty.im/ratchet/crypto"
+)
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	kp := crypto.Passphrase("correct horse battery staple")
+	plain := []byte("some gob-encoded session state")
+
+	sealed, err := crypto.Seal(kp, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !crypto.IsSealed(sealed) {
+		t.Fatal("Seal's output is not recognized by IsSealed")
+	}
+	if crypto.IsSealed(plain) {
+		t.Fatal("plaintext was misidentified as sealed")
+	}
+
+	got, err := crypto.Open(kp, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatalf("round trip differs, got %q want %q", got, plain)
+	}
+}
+
+func TestOpenWrongPassphrase(t *testing.T) {
+	sealed, err := crypto.Seal(crypto.Passphrase("right"), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := crypto.Open(crypto.Passphrase("wrong"), sealed); err == nil {
+		t.Fatal("expected an error opening under the wrong passphrase")
+	}
+}
+
+func TestSealIsNondeterministic(t *testing.T) {
+	kp := crypto.Passphrase("correct horse battery staple")
+
+	a, err := crypto.Seal(kp, []byte("same plaintext"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := crypto.Seal(kp, []byte("same plaintext"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a, b) {
+		t.Fatal("two seals of the same plaintext produced identical envelopes; salt/nonce are not being randomized")
+	}
+}
@@ -0,0 +1,206 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package daemon runs a client.Client behind a JSON-RPC 2.0 server (see
+// go.salty.im/ratchet/client/rpc for the wire protocol and a thin Go
+// client), so more than one caller — the interactive TUI, a script, a bot —
+// can share one long-running session instead of each spawning its own
+// one-shot process against the state directory.
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	stdsync "sync"
+
+	"go.salty.im/ratchet/client"
+	"go.salty.im/ratchet/client/rpc"
+)
+
+// Server exposes c's chat/send/close/salty/sessions commands, and its
+// events, to every connection Serve accepts.
+type Server struct {
+	c *client.Client
+}
+
+// New wraps c. c should already have had Run started by the caller, the
+// same as any other client.Client user.
+func New(c *client.Client) *Server {
+	return &Server{c: c}
+}
+
+// Serve accepts connections on l, handling each on its own goroutine, until
+// ctx is canceled or accepting fails. Run it once per listener — from two
+// goroutines — to serve a Unix socket and a TCP port at the same time.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+				return err
+			}
+		}
+		go s.handle(ctx, conn)
+	}
+}
+
+// connection serializes writes to one accepted net.Conn — both call
+// responses and the event notifications a subscribe call turns on — and
+// tracks the subscriptions so they can be torn down when the conn closes.
+type connection struct {
+	writeMu stdsync.Mutex
+	conn    net.Conn
+
+	subMu stdsync.Mutex
+	subs  []client.Subscription
+}
+
+func (cn *connection) write(v any) error {
+	cn.writeMu.Lock()
+	defer cn.writeMu.Unlock()
+	return json.NewEncoder(cn.conn).Encode(v)
+}
+
+func (s *Server) handle(ctx context.Context, netconn net.Conn) {
+	defer netconn.Close()
+
+	cn := &connection{conn: netconn}
+	defer cn.unsubscribeAll()
+
+	dec := json.NewDecoder(netconn)
+	for {
+		var req rpc.Request
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		go s.dispatch(ctx, cn, req)
+	}
+}
+
+func (s *Server) dispatch(ctx context.Context, cn *connection, req rpc.Request) {
+	result, err := s.call(ctx, cn, req.Method, req.Params)
+	if req.ID == 0 {
+		return // notification: no response expected
+	}
+
+	resp := rpc.Response{JSONRPC: "2.0", ID: req.ID}
+	if err != nil {
+		resp.Error = &rpc.Error{Code: -32000, Message: err.Error()}
+	} else {
+		b, mErr := json.Marshal(result)
+		if mErr != nil {
+			resp.Error = &rpc.Error{Code: -32000, Message: mErr.Error()}
+		} else {
+			resp.Result = b
+		}
+	}
+
+	_ = cn.write(resp)
+}
+
+func (s *Server) call(ctx context.Context, cn *connection, method string, params json.RawMessage) (any, error) {
+	switch method {
+	case "chat":
+		var p rpc.ChatParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		established, err := s.c.Chat(ctx, p.Them)
+		return rpc.ChatResult{Established: established}, err
+
+	case "send":
+		var p rpc.SendParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		return nil, s.c.Send(ctx, p.Them, p.Text)
+
+	case "close":
+		var p rpc.CloseParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		return nil, s.c.Close(ctx, p.Them)
+
+	case "salty":
+		var p rpc.SaltyParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		return nil, s.c.SendSalty(ctx, p.Them, p.Text)
+
+	case "sessions":
+		var out rpc.SessionsResult
+		err := s.c.Use(ctx, func(_ context.Context, sm client.SessionManager) error {
+			for _, p := range sm.Sessions() {
+				out.Sessions = append(out.Sessions, rpc.SessionInfo{Name: p.Name, ID: p.ID.String()})
+			}
+			return nil
+		})
+		return out, err
+
+	case "subscribe":
+		cn.subscribeAll(s.c)
+		return rpc.SubscribeResult{Subscribed: true}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown method %q", method)
+	}
+}
+
+// subscribeAll wires every event the interactive command loop already
+// prints (see interactive.Interactive) to cn as a JSON-RPC notification
+// named after the event's type, and is a no-op if cn is already subscribed.
+func (cn *connection) subscribeAll(c *client.Client) {
+	cn.subMu.Lock()
+	defer cn.subMu.Unlock()
+
+	if cn.subs != nil {
+		return
+	}
+
+	cn.subs = []client.Subscription{
+		notify[client.OnOfferSent](cn, c, "OnOfferSent"),
+		notify[client.OnOfferReceived](cn, c, "OnOfferReceived"),
+		notify[client.OnSessionStarted](cn, c, "OnSessionStarted"),
+		notify[client.OnSessionClosed](cn, c, "OnSessionClosed"),
+		notify[client.OnMessageReceived](cn, c, "OnMessageReceived"),
+		notify[client.OnMessageSent](cn, c, "OnMessageSent"),
+		notify[client.OnSaltySent](cn, c, "OnSaltySent"),
+		notify[client.OnSaltyTextReceived](cn, c, "OnSaltyTextReceived"),
+		notify[client.OnSaltyEventReceived](cn, c, "OnSaltyEventReceived"),
+		notify[client.OnReceived](cn, c, "OnReceived"),
+		notify[client.OnBlobReceived](cn, c, "OnBlobReceived"),
+	}
+}
+func (cn *connection) unsubscribeAll() {
+	cn.subMu.Lock()
+	defer cn.subMu.Unlock()
+
+	for _, s := range cn.subs {
+		s.Unsubscribe()
+	}
+	cn.subs = nil
+}
+
+// notify subscribes c for event type T and forwards every occurrence to cn
+// as a Notification named method, marshaling evt as Params.
+func notify[T any](cn *connection, c *client.Client, method string) client.Subscription {
+	return client.Subscribe(c, func(_ context.Context, evt T) error {
+		b, err := json.Marshal(evt)
+		if err != nil {
+			return err
+		}
+		return cn.write(rpc.Notification{JSONRPC: "2.0", Method: method, Params: b})
+	})
+}
@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package doubleratchet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"time"
+)
+
+// DiskStore is a SkippedKeyStore that persists every Insert to a small
+// append-only file, so a long-lived session survives a process restart
+// without losing the ability to decrypt messages that are already in
+// flight.
+type DiskStore struct {
+	*LRUStore
+	path string
+	f    *os.File
+}
+
+// OpenDiskStore opens (creating if necessary) the append-only file at path,
+// replaying any records it already holds into a fresh LRUStore built with
+// the given caps.
+func OpenDiskStore(path string, maxChains, maxPerChain, maxBytes int) (*DiskStore, error) {
+	lru := NewLRUStore(maxChains, maxPerChain, maxBytes)
+
+	if b, err := os.ReadFile(path); err == nil {
+		_ = lru.UnmarshalBinary(b)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DiskStore{LRUStore: lru, path: path, f: f}, nil
+}
+
+// Insert caches msgKey like LRUStore.Insert and additionally appends it to
+// the backing file.
+func (d *DiskStore) Insert(dhPub []byte, msgNo int, msgKey []byte) {
+	d.LRUStore.Insert(dhPub, msgNo, msgKey)
+	d.append(dhPub, msgNo, msgKey, time.Time{})
+}
+
+// Put caches msgKey like LRUStore.Put and additionally appends it, along
+// with its expiry, to the backing file.
+func (d *DiskStore) Put(dhPub []byte, msgNo int, msgKey []byte, expiresAt time.Time) {
+	d.LRUStore.Put(dhPub, msgNo, msgKey, expiresAt)
+	d.append(dhPub, msgNo, msgKey, expiresAt)
+}
+
+func (d *DiskStore) append(dhPub []byte, msgNo int, msgKey []byte, expiresAt time.Time) {
+	var buf bytes.Buffer
+	_ = writeUvarintBytes(&buf, dhPub)
+
+	var msgNoB [4]byte
+	binary.BigEndian.PutUint32(msgNoB[:], uint32(msgNo))
+	buf.Write(msgNoB[:])
+
+	_ = writeUvarintBytes(&buf, msgKey)
+
+	var expires [8]byte
+	if !expiresAt.IsZero() {
+		binary.BigEndian.PutUint64(expires[:], uint64(expiresAt.Unix()))
+	}
+	buf.Write(expires[:])
+
+	_, _ = d.f.Write(buf.Bytes())
+}
+
+// Compact rewrites the backing file from the current in-memory state,
+// dropping any records for keys that have since been evicted. Without this
+// the append-only file would grow without bound over a long-lived session.
+func (d *DiskStore) Compact() error {
+	b, err := d.LRUStore.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	if err := d.f.Close(); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(d.path, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	d.f, err = os.OpenFile(d.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	return err
+}
+
+// Close releases the backing file.
+func (d *DiskStore) Close() error {
+	return d.f.Close()
+}
@@ -0,0 +1,27 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package doubleratchet
+
+import "go.salty.im/ratchet/crypto"
+
+// EncryptedMarshal serializes dr as MarshalBinary does, then seals the
+// result under kp with crypto.Seal, so the root key, DH private key, and
+// every cached skipped-message key are never written out in the clear.
+func (dr *DoubleRatchet) EncryptedMarshal(kp crypto.KeyProvider) ([]byte, error) {
+	b, err := dr.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Seal(kp, b)
+}
+
+// EncryptedUnmarshal reverses EncryptedMarshal, opening the envelope under
+// kp before handing the plaintext to UnmarshalBinary.
+func (dr *DoubleRatchet) EncryptedUnmarshal(kp crypto.KeyProvider, b []byte) error {
+	plain, err := crypto.Open(kp, b)
+	if err != nil {
+		return err
+	}
+	return dr.UnmarshalBinary(plain)
+}
@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: GPL-3.0-or-later
+package doubleratchet
+
+import (
+	"bytes"
+	"testing"
+
+	"go.salty.im/ratchet/crypto"
+)
+
+func TestEncryptedMarshalRoundTrip(t *testing.T) {
+	dr := &DoubleRatchet{
+		dhr:          &dhRatchet{rootKey: []byte("root")},
+		msgKeyBuffer: NewLRUStore(0, 0, 0),
+	}
+	dr.msgKeyBuffer.Insert([]byte("peer"), 0, []byte("key"))
+
+	kp := crypto.Passphrase("correct horse battery staple")
+
+	sealed, err := dr.EncryptedMarshal(kp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !crypto.IsSealed(sealed) {
+		t.Fatal("EncryptedMarshal's output is not recognized by crypto.IsSealed")
+	}
+	if bytes.Contains(sealed, dr.dhr.rootKey) {
+		t.Fatal("root key present in the clear in the sealed output")
+	}
+
+	got := &DoubleRatchet{}
+	if err := got.EncryptedUnmarshal(kp, sealed); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.dhr.rootKey, dr.dhr.rootKey) {
+		t.Fatalf("rootKey = %q, want %q", got.dhr.rootKey, dr.dhr.rootKey)
+	}
+}
+
+func TestEncryptedUnmarshalWrongPassphrase(t *testing.T) {
+	dr := &DoubleRatchet{
+		dhr:          &dhRatchet{rootKey: []byte("root")},
+		msgKeyBuffer: NewLRUStore(0, 0, 0),
+	}
+
+	sealed, err := dr.EncryptedMarshal(crypto.Passphrase("right"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&DoubleRatchet{}).EncryptedUnmarshal(crypto.Passphrase("wrong"), sealed); err == nil {
+		t.Fatal("expected an error unmarshaling under the wrong passphrase")
+	}
+}
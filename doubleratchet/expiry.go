@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file adds an expiry-aware layer on top of LRUStore's plain
+// Find/Insert: Put lets a caller attach a deadline to a skipped key
+// independent of LRU pressure, Take consumes a key the moment it's used
+// (a double ratchet skipped key is only ever decrypted once, so there's
+// no reason to keep it cached after that), and Prune sweeps keys whose
+// deadline has passed even if the store is nowhere near its size caps.
+
+package doubleratchet
+
+import "time"
+
+// Put caches msgKey for (dhPub, msgNo) like Insert, additionally marking
+// it to be swept by the next Prune once expiresAt has passed. A zero
+// expiresAt means the key is still only bounded by the store's LRU caps.
+func (s *LRUStore) Put(dhPub []byte, msgNo int, msgKey []byte, expiresAt time.Time) {
+	s.Insert(dhPub, msgNo, msgKey)
+
+	el, ok := s.byDhPub[string(dhPub)]
+	if !ok {
+		return
+	}
+	c := el.Value.(*chain)
+	if me, ok := c.byNo[msgNo]; ok {
+		me.Value.(*msgKeyEntry).expiresAt = expiresAt
+	}
+}
+
+// Take returns and removes the cached key for (dhPub, msgNo), if any. Use
+// this in place of Find on the decrypt path: once a skipped key has
+// decrypted its message it can never be needed again, so there's no
+// reason to keep it around for the next LRU sweep to find.
+func (s *LRUStore) Take(dhPub []byte, msgNo int) ([]byte, bool) {
+	el, ok := s.byDhPub[string(dhPub)]
+	if !ok {
+		return nil, false
+	}
+	c := el.Value.(*chain)
+
+	me, ok := c.byNo[msgNo]
+	if !ok {
+		return nil, false
+	}
+
+	entry := me.Value.(*msgKeyEntry)
+	msgKey := entry.msgKey
+
+	s.size -= len(entry.msgKey)
+	c.msgs.Remove(me)
+	delete(c.byNo, msgNo)
+
+	if c.msgs.Len() == 0 {
+		s.chains.Remove(el)
+		delete(s.byDhPub, string(dhPub))
+	}
+
+	return msgKey, true
+}
+
+// Prune discards every cached key whose expiresAt is non-zero and no
+// later than now, regardless of how far the store is from its LRU caps,
+// and reports how many it removed. Callers that never use Put have
+// nothing to prune: Insert-only entries never expire this way.
+func (s *LRUStore) Prune(now time.Time) int {
+	n := 0
+
+	for el := s.chains.Front(); el != nil; {
+		c := el.Value.(*chain)
+		next := el.Next()
+
+		for me := c.msgs.Front(); me != nil; {
+			entry := me.Value.(*msgKeyEntry)
+			nextMe := me.Next()
+
+			if !entry.expiresAt.IsZero() && !entry.expiresAt.After(now) {
+				s.size -= len(entry.msgKey)
+				c.msgs.Remove(me)
+				delete(c.byNo, entry.msgNo)
+				s.notifyEvict(c.dhPub, entry.msgNo)
+				n++
+			}
+
+			me = nextMe
+		}
+
+		if c.msgs.Len() == 0 {
+			s.chains.Remove(el)
+			delete(s.byDhPub, string(c.dhPub))
+		}
+
+		el = next
+	}
+
+	return n
+}
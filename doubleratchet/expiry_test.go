@@ -0,0 +1,66 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: GPL-3.0-or-later
+package doubleratchet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestTakeConsumesKey(t *testing.T) {
+	s := NewLRUStore(0, 0, 0)
+	s.Insert([]byte("peer"), 1, []byte("key"))
+
+	got, ok := s.Take([]byte("peer"), 1)
+	if !ok || !bytes.Equal(got, []byte("key")) {
+		t.Fatalf("Take() = %q, %v", got, ok)
+	}
+
+	if _, ok := s.Find([]byte("peer"), 1); ok {
+		t.Fatal("key still present after Take")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	s := NewLRUStore(0, 0, 0)
+	now := time.Unix(1000, 0)
+
+	s.Put([]byte("peer"), 1, []byte("expired"), now.Add(-time.Second))
+	s.Put([]byte("peer"), 2, []byte("fresh"), now.Add(time.Hour))
+	s.Insert([]byte("peer"), 3, []byte("no-expiry"))
+
+	if n := s.Prune(now); n != 1 {
+		t.Fatalf("Prune() removed %d keys, want 1", n)
+	}
+
+	if _, ok := s.Find([]byte("peer"), 1); ok {
+		t.Fatal("expired key survived Prune")
+	}
+	if _, ok := s.Find([]byte("peer"), 2); !ok {
+		t.Fatal("unexpired key was pruned")
+	}
+	if _, ok := s.Find([]byte("peer"), 3); !ok {
+		t.Fatal("no-expiry key was pruned")
+	}
+}
+
+func TestPutMarshalRoundTripPreservesExpiry(t *testing.T) {
+	s := NewLRUStore(0, 0, 0)
+	expiresAt := time.Unix(2000, 0)
+	s.Put([]byte("peer"), 1, []byte("key"), expiresAt)
+
+	b, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := NewLRUStore(0, 0, 0)
+	if err := got.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := got.Prune(expiresAt.Add(time.Second)); n != 1 {
+		t.Fatalf("Prune() after round trip removed %d keys, want 1", n)
+	}
+}
@@ -1,39 +1,98 @@
 // SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
 // SPDX-License-Identifier: GPL-3.0-or-later
 
+// This file implements the on-disk/on-wire representation of dhRatchet and
+// DoubleRatchet. Earlier revisions handed the struct straight to
+// encoding/gob, which has no schema version of its own, silently drops
+// fields that no longer match on decode, and made it easy to get a manual
+// restore subtly wrong (a stray duplicate assignment used to clobber
+// dhPriv with itself while isInitialized went unrestored). Every blob
+// written here instead starts with a small fixed header identifying the
+// format, so a future change to either struct can introduce a new format
+// number and still read everything already on disk.
 package doubleratchet
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"fmt"
 )
 
+// wireMagic tags a blob as one of this package's versioned formats, so
+// UnmarshalBinary can tell a self-describing blob apart from the legacy
+// gob encoding it replaces (which never begins with these bytes).
+var wireMagic = [4]byte{'D', 'R', 'T', 'C'}
+
+// wireHeaderLen is len(wireMagic) + the 2-byte format number that follows it.
+const wireHeaderLen = 6
+
+const (
+	// formatDhRatchetV1 is the current TLV encoding of dhRatchet.
+	formatDhRatchetV1 uint16 = 1
+
+	// formatDoubleRatchetV1 is the current TLV encoding of DoubleRatchet.
+	formatDoubleRatchetV1 uint16 = 1
+)
+
+func writeHeader(buf *bytes.Buffer, format uint16) {
+	buf.Write(wireMagic[:])
+	var f [2]byte
+	binary.BigEndian.PutUint16(f[:], format)
+	buf.Write(f[:])
+}
+
+// readHeader reports the format number and remaining payload if b is
+// tagged with wireMagic, so callers can fall back to the legacy gob
+// decode for anything written before this format existed.
+func readHeader(b []byte) (format uint16, payload []byte, tagged bool) {
+	if len(b) < wireHeaderLen || !bytes.Equal(b[:len(wireMagic)], wireMagic[:]) {
+		return 0, nil, false
+	}
+	return binary.BigEndian.Uint16(b[4:wireHeaderLen]), b[wireHeaderLen:], true
+}
+
+func writeUvarintInt(buf *bytes.Buffer, n int) {
+	var v [binary.MaxVarintLen64]byte
+	l := binary.PutUvarint(v[:], uint64(n))
+	buf.Write(v[:l])
+}
+
+func readUvarintInt(r *bytes.Reader) (int, error) {
+	n, err := binary.ReadUvarint(r)
+	return int(n), err
+}
+
+func writeBool(buf *bytes.Buffer, b bool) {
+	if b {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+}
+
+func readBool(r *bytes.Reader) (bool, error) {
+	b, err := r.ReadByte()
+	return b != 0, err
+}
+
 func (dhr *dhRatchet) MarshalBinary() ([]byte, error) {
 	if dhr == nil {
 		return nil, nil
 	}
 
 	var buf bytes.Buffer
-	o := struct {
-		RootKey   []byte
-		DhPub     []byte
-		DhPriv    []byte
-		PeerDhPub []byte
+	writeHeader(&buf, formatDhRatchetV1)
 
-		IsActive      bool
-		IsInitialized bool
-	}{
-		dhr.rootKey,
-		dhr.dhPub,
-		dhr.dhPriv,
-		dhr.peerDhPub,
-		dhr.isActive,
-		dhr.isInitialized,
+	for _, b := range [][]byte{dhr.rootKey, dhr.dhPub, dhr.dhPriv, dhr.peerDhPub} {
+		if err := writeUvarintBytes(&buf, b); err != nil {
+			return nil, err
+		}
 	}
+	writeBool(&buf, dhr.isActive)
+	writeBool(&buf, dhr.isInitialized)
 
-	err := gob.NewEncoder(&buf).Encode(o)
-
-	return buf.Bytes(), err
+	return buf.Bytes(), nil
 }
 
 func (dhr *dhRatchet) UnmarshalBinary(b []byte) error {
@@ -41,6 +100,42 @@ func (dhr *dhRatchet) UnmarshalBinary(b []byte) error {
 		return nil
 	}
 
+	format, payload, tagged := readHeader(b)
+	if !tagged {
+		return dhr.unmarshalLegacyGob(b)
+	}
+	if format != formatDhRatchetV1 {
+		return fmt.Errorf("doubleratchet: unsupported dhRatchet wire format %d", format)
+	}
+
+	r := bytes.NewReader(payload)
+
+	fields := make([][]byte, 4)
+	for i := range fields {
+		v, err := readUvarintBytes(r)
+		if err != nil {
+			return err
+		}
+		fields[i] = v
+	}
+	dhr.rootKey, dhr.dhPub, dhr.dhPriv, dhr.peerDhPub = fields[0], fields[1], fields[2], fields[3]
+
+	var err error
+	if dhr.isActive, err = readBool(r); err != nil {
+		return err
+	}
+	if dhr.isInitialized, err = readBool(r); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// unmarshalLegacyGob restores a dhRatchet from the pre-versioning gob
+// encoding, so state written before this format existed keeps working
+// until it is next saved (at which point it's rewritten in the current
+// format).
+func (dhr *dhRatchet) unmarshalLegacyGob(b []byte) error {
 	var o struct {
 		RootKey   []byte
 		DhPub     []byte
@@ -50,129 +145,108 @@ func (dhr *dhRatchet) UnmarshalBinary(b []byte) error {
 		IsActive      bool
 		IsInitialized bool
 	}
-	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&o)
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&o); err != nil {
+		return err
+	}
 
 	dhr.rootKey = o.RootKey
-	dhr.dhPriv = o.DhPriv
 	dhr.dhPub = o.DhPub
 	dhr.dhPriv = o.DhPriv
 	dhr.peerDhPub = o.PeerDhPub
 	dhr.isActive = o.IsActive
 	dhr.isInitialized = o.IsInitialized
 
-	return err
+	return nil
 }
 
-func (kb *keyBuffer) MarshalBinary() ([]byte, error) {
-	if kb == nil {
-		return nil, nil
+func (dr *DoubleRatchet) MarshalBinary() ([]byte, error) {
+	dhr, err := dr.dhr.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	mkb, err := dr.msgKeyBuffer.MarshalBinary()
+	if err != nil {
+		return nil, err
 	}
 
 	var buf bytes.Buffer
-	lis := make([]*keyBufferElement, kb.buff.Len())
-	i := 0
-	kb.buff.Do(func(a interface{}) {
-		if kbe, ok := a.(*keyBufferElement); ok {
-			lis[i] = kbe
-			i++
+	writeHeader(&buf, formatDoubleRatchetV1)
+
+	for _, b := range [][]byte{dr.associatedData, dhr, dr.peerDhPub, dr.chainKeySend, dr.chainKeyRecv} {
+		if err := writeUvarintBytes(&buf, b); err != nil {
+			return nil, err
 		}
-	})
-	lis = lis[:i]
-	err := gob.NewEncoder(&buf).Encode(lis)
+	}
+	writeUvarintInt(&buf, dr.sendNo)
+	writeUvarintInt(&buf, dr.recvNo)
+	writeUvarintInt(&buf, dr.prevSendNo)
 
-	return buf.Bytes(), err
+	if err := writeUvarintBytes(&buf, mkb); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
 }
 
-func (kb *keyBuffer) UnmarshalBinary(b []byte) error {
+func (dr *DoubleRatchet) UnmarshalBinary(b []byte) error {
 	if len(b) == 0 {
 		return nil
 	}
 
-	lis := make([]*keyBufferElement, maxSkipChains)
-	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&lis)
-	if err != nil {
-		return err
+	format, payload, tagged := readHeader(b)
+	if !tagged {
+		return dr.unmarshalLegacyGob(b)
 	}
-
-	for _, kbe := range lis {
-		kb.buff.Value = kbe
-		kb.buff.Prev()
+	if format != formatDoubleRatchetV1 {
+		return fmt.Errorf("doubleratchet: unsupported DoubleRatchet wire format %d", format)
 	}
 
-	return nil
-}
+	r := bytes.NewReader(payload)
 
-func (kb *keyBufferElement) MarshalBinary() ([]byte, error) {
-	var buf bytes.Buffer
-	o := struct {
-		DhPub   []byte
-		MsgKeys map[int][]byte
-	}{
-		kb.dhPub,
-		kb.msgKeys,
-	}
-	err := gob.NewEncoder(&buf).Encode(o)
-	return buf.Bytes(), err
-}
-func (kb *keyBufferElement) UnmarshalBinary(b []byte) error {
-	var o struct {
-		DhPub   []byte
-		MsgKeys map[int][]byte
+	fields := make([][]byte, 5)
+	for i := range fields {
+		v, err := readUvarintBytes(r)
+		if err != nil {
+			return err
+		}
+		fields[i] = v
 	}
-	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&o)
-
-	kb.dhPub = o.DhPub
-	kb.msgKeys = o.MsgKeys
-
-	return err
-}
+	var dhrBlob []byte
+	dr.associatedData, dhrBlob, dr.peerDhPub, dr.chainKeySend, dr.chainKeyRecv =
+		fields[0], fields[1], fields[2], fields[3], fields[4]
 
-func (dr *DoubleRatchet) MarshalBinary() ([]byte, error) {
-	dhr, err := dr.dhr.MarshalBinary()
-	if err != nil {
-		return nil, err
+	var err error
+	if dr.sendNo, err = readUvarintInt(r); err != nil {
+		return err
+	}
+	if dr.recvNo, err = readUvarintInt(r); err != nil {
+		return err
+	}
+	if dr.prevSendNo, err = readUvarintInt(r); err != nil {
+		return err
 	}
 
-	mkb, err := dr.msgKeyBuffer.MarshalBinary()
+	mkb, err := readUvarintBytes(r)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	o := struct {
-		AssociatedData []byte
-
-		Dhr []byte
-
-		PeerDhPub    []byte
-		ChainKeySend []byte
-		ChainKeyRecv []byte
-
-		SendNo     int
-		RecvNo     int
-		PrevSendNo int
-
-		MsgKeyBuffer []byte
-	}{
-		dr.associatedData,
-		dhr,
-		dr.peerDhPub,
-		dr.chainKeySend,
-		dr.chainKeyRecv,
-		dr.sendNo,
-		dr.recvNo,
-		dr.prevSendNo,
-		mkb,
+	dr.dhr = &dhRatchet{}
+	if err := dr.dhr.UnmarshalBinary(dhrBlob); err != nil {
+		return err
 	}
 
-	var buf bytes.Buffer
-	err = gob.NewEncoder(&buf).Encode(o)
-
-	return buf.Bytes(), err
+	dr.msgKeyBuffer = NewLRUStore(0, 0, 0)
+	return dr.msgKeyBuffer.UnmarshalBinary(mkb)
 }
-func (dr *DoubleRatchet) UnmarshalBinary(b []byte) error {
-	if len(b) == 0 {
-		return nil
-	}
+
+// unmarshalLegacyGob restores a DoubleRatchet from the pre-versioning gob
+// encoding. The nested dhr/msgKeyBuffer blobs it carries are themselves
+// legacy gob/format-0 encodings and are handled by their own
+// UnmarshalBinary, so a state file saved before this format existed reads
+// back correctly end to end.
+func (dr *DoubleRatchet) unmarshalLegacyGob(b []byte) error {
 	var o struct {
 		AssociatedData []byte
 
@@ -188,25 +262,23 @@ func (dr *DoubleRatchet) UnmarshalBinary(b []byte) error {
 
 		MsgKeyBuffer []byte
 	}
-	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&o)
-	if err != nil {
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&o); err != nil {
 		return err
 	}
+
 	dr.associatedData = o.AssociatedData
 	dr.peerDhPub = o.PeerDhPub
 	dr.chainKeySend = o.ChainKeySend
 	dr.chainKeyRecv = o.ChainKeyRecv
 	dr.sendNo = o.SendNo
 	dr.recvNo = o.RecvNo
+	dr.prevSendNo = o.PrevSendNo
 
 	dr.dhr = &dhRatchet{}
-	err = dr.dhr.UnmarshalBinary(o.Dhr)
-	if err != nil {
+	if err := dr.dhr.UnmarshalBinary(o.Dhr); err != nil {
 		return err
 	}
 
-	dr.msgKeyBuffer = newKeyBuffer()
-	err = dr.msgKeyBuffer.UnmarshalBinary(o.MsgKeyBuffer)
-
-	return err
+	dr.msgKeyBuffer = NewLRUStore(0, 0, 0)
+	return dr.msgKeyBuffer.UnmarshalBinary(o.MsgKeyBuffer)
 }
@@ -3,24 +3,77 @@
 package doubleratchet
 
 import (
+	"bytes"
+	"encoding/gob"
 	"testing"
 )
 
 func TestMarshal(t *testing.T) {
 	dr := &DoubleRatchet{
-		dhr: &dhRatchet{},
-		msgKeyBuffer: newKeyBuffer(),
+		dhr:          &dhRatchet{},
+		msgKeyBuffer: NewLRUStore(0, 0, 0),
 	}
-	dr.msgKeyBuffer.elementAdd(nil)
-
+	dr.msgKeyBuffer.Insert([]byte("peer"), 0, []byte("key"))
 
 	b, err := dr.MarshalBinary()
 	if err != nil {
 		t.Fatal(err)
 	}
-	
+	if !bytes.HasPrefix(b, wireMagic[:]) {
+		t.Fatalf("marshaled state missing wire header: %x", b[:min(len(b), wireHeaderLen)])
+	}
+
 	err = dr.UnmarshalBinary(b)
 	if err != nil {
 		t.Fatal(err)
 	}
 }
+
+// TestMarshalLegacyGobMigration verifies that state written by the old,
+// unversioned gob encoding still loads, so upgrading this package doesn't
+// strand a user's existing session state on disk.
+func TestMarshalLegacyGobMigration(t *testing.T) {
+	legacy := struct {
+		RootKey   []byte
+		DhPub     []byte
+		DhPriv    []byte
+		PeerDhPub []byte
+
+		IsActive      bool
+		IsInitialized bool
+	}{
+		RootKey:       []byte("root"),
+		DhPub:         []byte("pub"),
+		DhPriv:        []byte("priv"),
+		PeerDhPub:     []byte("peer-pub"),
+		IsActive:      true,
+		IsInitialized: true,
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	dhr := &dhRatchet{}
+	if err := dhr.UnmarshalBinary(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(dhr.rootKey, legacy.RootKey) {
+		t.Fatalf("rootKey = %q, want %q", dhr.rootKey, legacy.RootKey)
+	}
+	if !bytes.Equal(dhr.dhPriv, legacy.DhPriv) {
+		t.Fatalf("dhPriv = %q, want %q", dhr.dhPriv, legacy.DhPriv)
+	}
+	if !dhr.isInitialized {
+		t.Fatal("isInitialized not restored from legacy gob state")
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
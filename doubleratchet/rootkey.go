@@ -0,0 +1,16 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package doubleratchet
+
+// RootKey returns the ratchet's current root key, the shared secret from
+// which every chain key is derived. It is nil until the first DH ratchet
+// step has run. Both parties to a session compute the same root key, so it
+// is safe to use as shared material for a value that must agree across
+// devices without extra coordination (see session.Export).
+func (dr *DoubleRatchet) RootKey() []byte {
+	if dr == nil || dr.dhr == nil {
+		return nil
+	}
+	return dr.dhr.rootKey
+}
@@ -0,0 +1,365 @@
+// SPDX-FileCopyrightText: 2021 Alvar Penning
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file implements the store used to cache skipped message keys. Unlike
+// the fixed 8-chain x 32-message ring it replaces, chains and the messages
+// within them are evicted on a least-recently-used basis, bounded by both a
+// count and a total byte budget, so a peer that legitimately skips many
+// messages (e.g. over a flaky mobile transport) doesn't lose decryptability
+// while a MITM still can't flood the store with precalculated keys for
+// messages that were never sent.
+
+package doubleratchet
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+const (
+	// defaultMaxChains is the default maximum amount of cached chains.
+	defaultMaxChains = 8
+
+	// defaultMaxPerChain is the default maximum amount of message keys cached
+	// per chain.
+	defaultMaxPerChain = 32
+
+	// defaultMaxBytes bounds the store's total size regardless of how the
+	// chain/message caps above are configured, so a peer can't grow the
+	// store without bound simply by skipping a great many messages.
+	defaultMaxBytes = 1 << 20 // 1 MiB
+)
+
+// EvictedKey describes a skipped message key the store discarded to make
+// room for a newer one, before the key was ever used to decrypt a message.
+type EvictedKey struct {
+	DhPub []byte
+	MsgNo int
+}
+
+// SkippedKeyStore caches message keys for out-of-order/skipped messages,
+// keyed by the sender's DH public key and the message number within that
+// chain. Find/Insert are the low-level LRU operations; Put/Take/Prune (see
+// expiry.go) layer an optional deadline and consume-on-read semantics on
+// top for callers that want them.
+type SkippedKeyStore interface {
+	// Find returns the cached key for (dhPub, msgNo), if any.
+	Find(dhPub []byte, msgNo int) (msgKey []byte, ok bool)
+
+	// Insert caches msgKey for (dhPub, msgNo), evicting older entries if the
+	// store is over one of its configured caps. OnEvict, if set, is called
+	// for every entry discarded this way.
+	Insert(dhPub []byte, msgNo int, msgKey []byte)
+
+	// Put caches msgKey like Insert, additionally scheduling it for
+	// removal by Prune once expiresAt has passed. A zero expiresAt behaves
+	// exactly like Insert.
+	Put(dhPub []byte, msgNo int, msgKey []byte, expiresAt time.Time)
+
+	// Take returns and removes the cached key for (dhPub, msgNo), if any.
+	Take(dhPub []byte, msgNo int) (msgKey []byte, ok bool)
+
+	// Prune discards every entry whose Put deadline has passed and
+	// reports how many it removed.
+	Prune(now time.Time) int
+
+	MarshalBinary() ([]byte, error)
+	UnmarshalBinary([]byte) error
+}
+
+// msgKeyEntry is one cached message key within a chain.
+type msgKeyEntry struct {
+	msgNo  int
+	msgKey []byte
+
+	// expiresAt is when this key should be pruned regardless of LRU
+	// pressure, or the zero Time if it never expires on its own. Set via
+	// Put; entries from the plain Insert path never expire this way.
+	expiresAt time.Time
+}
+
+// chain is the LRU of skipped message keys for a single sender DH public
+// key.
+type chain struct {
+	dhPub []byte
+	msgs  *list.List // of *msgKeyEntry, most-recently-used at the front
+	byNo  map[int]*list.Element
+}
+
+func newChain(dhPub []byte) *chain {
+	return &chain{dhPub: dhPub, msgs: list.New(), byNo: make(map[int]*list.Element)}
+}
+
+// LRUStore is the in-memory SkippedKeyStore implementation: chains are
+// themselves evicted least-recently-used once maxChains is exceeded, and
+// each chain evicts its own oldest message key once maxPerChain or the
+// global maxBytes budget is exceeded.
+type LRUStore struct {
+	maxChains   int
+	maxPerChain int
+	maxBytes    int
+
+	// OnEvict, if set, is called whenever a still-unused key is discarded so
+	// the client can surface "message permanently undecryptable" to the UI.
+	OnEvict func(EvictedKey)
+
+	size    int
+	chains  *list.List // of *chain, most-recently-used at the front
+	byDhPub map[string]*list.Element
+}
+
+// NewLRUStore returns an empty LRUStore. A zero value for any cap falls back
+// to its package default.
+func NewLRUStore(maxChains, maxPerChain, maxBytes int) *LRUStore {
+	if maxChains <= 0 {
+		maxChains = defaultMaxChains
+	}
+	if maxPerChain <= 0 {
+		maxPerChain = defaultMaxPerChain
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxBytes
+	}
+
+	return &LRUStore{
+		maxChains:   maxChains,
+		maxPerChain: maxPerChain,
+		maxBytes:    maxBytes,
+		chains:      list.New(),
+		byDhPub:     make(map[string]*list.Element),
+	}
+}
+
+func (s *LRUStore) Find(dhPub []byte, msgNo int) ([]byte, bool) {
+	el, ok := s.byDhPub[string(dhPub)]
+	if !ok {
+		return nil, false
+	}
+	c := el.Value.(*chain)
+
+	me, ok := c.byNo[msgNo]
+	if !ok {
+		return nil, false
+	}
+
+	s.chains.MoveToFront(el)
+	c.msgs.MoveToFront(me)
+
+	return me.Value.(*msgKeyEntry).msgKey, true
+}
+
+func (s *LRUStore) Insert(dhPub []byte, msgNo int, msgKey []byte) {
+	el, ok := s.byDhPub[string(dhPub)]
+	if !ok {
+		el = s.chains.PushFront(newChain(dhPub))
+		s.byDhPub[string(dhPub)] = el
+
+		for s.chains.Len() > s.maxChains {
+			s.evictOldestChain()
+		}
+	} else {
+		s.chains.MoveToFront(el)
+	}
+	c := el.Value.(*chain)
+
+	if me, ok := c.byNo[msgNo]; ok {
+		c.msgs.MoveToFront(me)
+		s.size -= len(me.Value.(*msgKeyEntry).msgKey)
+		me.Value.(*msgKeyEntry).msgKey = msgKey
+		s.size += len(msgKey)
+		return
+	}
+
+	me := c.msgs.PushFront(&msgKeyEntry{msgNo: msgNo, msgKey: msgKey})
+	c.byNo[msgNo] = me
+	s.size += len(msgKey)
+
+	for c.msgs.Len() > s.maxPerChain {
+		s.evictOldestIn(c)
+	}
+	for s.size > s.maxBytes && (s.chains.Len() > 1 || c.msgs.Len() > 0) {
+		if !s.evictOldestOverBudget() {
+			break
+		}
+	}
+}
+
+// elementFind mirrors the ring buffer's constant-time lookup of a chain by
+// its DH public key. Nothing in this package calls it; Find above is the
+// lookup in use, and this keeps the historical side-channel-resistant
+// compare available for callers that want it.
+func (s *LRUStore) elementFind(dhPub []byte) (c *chain) {
+	for el := s.chains.Front(); el != nil; el = el.Next() {
+		if subtle.ConstantTimeCompare(dhPub, el.Value.(*chain).dhPub) == 1 {
+			return el.Value.(*chain)
+		}
+	}
+	return nil
+}
+
+func (s *LRUStore) evictOldestChain() {
+	el := s.chains.Back()
+	if el == nil {
+		return
+	}
+	c := el.Value.(*chain)
+
+	for me := c.msgs.Back(); me != nil; me = c.msgs.Back() {
+		entry := me.Value.(*msgKeyEntry)
+		s.size -= len(entry.msgKey)
+		c.msgs.Remove(me)
+		s.notifyEvict(c.dhPub, entry.msgNo)
+	}
+
+	s.chains.Remove(el)
+	delete(s.byDhPub, string(c.dhPub))
+}
+
+func (s *LRUStore) evictOldestIn(c *chain) {
+	me := c.msgs.Back()
+	if me == nil {
+		return
+	}
+	entry := me.Value.(*msgKeyEntry)
+	s.size -= len(entry.msgKey)
+	c.msgs.Remove(me)
+	delete(c.byNo, entry.msgNo)
+	s.notifyEvict(c.dhPub, entry.msgNo)
+}
+
+// evictOldestOverBudget evicts the globally least-recently-used message key
+// across every chain, reporting whether it found anything to evict.
+func (s *LRUStore) evictOldestOverBudget() bool {
+	for el := s.chains.Back(); el != nil; el = el.Prev() {
+		c := el.Value.(*chain)
+		if me := c.msgs.Back(); me != nil {
+			entry := me.Value.(*msgKeyEntry)
+			s.size -= len(entry.msgKey)
+			c.msgs.Remove(me)
+			delete(c.byNo, entry.msgNo)
+			s.notifyEvict(c.dhPub, entry.msgNo)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *LRUStore) notifyEvict(dhPub []byte, msgNo int) {
+	if s.OnEvict != nil {
+		s.OnEvict(EvictedKey{DhPub: dhPub, MsgNo: msgNo})
+	}
+}
+
+// MarshalBinary serializes the store as a sequence of length-prefixed chain
+// records: [dhPubLen|dhPub][msgCount][msgNo|keyLen|key|expiresAtUnix]...
+// repeated, expiresAtUnix being 0 for a key with no expiry. Unlike the gob
+// encoding it replaces, a truncated or corrupted tail only loses the
+// chains after the damage instead of failing the entire decode.
+func (s *LRUStore) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+
+	for el := s.chains.Back(); el != nil; el = el.Prev() {
+		c := el.Value.(*chain)
+
+		if err := writeUvarintBytes(&buf, c.dhPub); err != nil {
+			return nil, err
+		}
+
+		var msgCount [4]byte
+		binary.BigEndian.PutUint32(msgCount[:], uint32(c.msgs.Len()))
+		buf.Write(msgCount[:])
+
+		for me := c.msgs.Back(); me != nil; me = me.Prev() {
+			entry := me.Value.(*msgKeyEntry)
+
+			var msgNo [4]byte
+			binary.BigEndian.PutUint32(msgNo[:], uint32(entry.msgNo))
+			buf.Write(msgNo[:])
+
+			if err := writeUvarintBytes(&buf, entry.msgKey); err != nil {
+				return nil, err
+			}
+
+			var expires [8]byte
+			if !entry.expiresAt.IsZero() {
+				binary.BigEndian.PutUint64(expires[:], uint64(entry.expiresAt.Unix()))
+			}
+			buf.Write(expires[:])
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary restores chains from data as written by MarshalBinary, recovering as
+// many leading chains as it can parse from a truncated/corrupt tail.
+func (s *LRUStore) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+
+	for r.Len() > 0 {
+		dhPub, err := readUvarintBytes(r)
+		if err != nil {
+			return nil // stop at the first unreadable chain; keep what we have.
+		}
+
+		var msgCountB [4]byte
+		if _, err := r.Read(msgCountB[:]); err != nil {
+			return nil
+		}
+		msgCount := binary.BigEndian.Uint32(msgCountB[:])
+
+		for i := uint32(0); i < msgCount; i++ {
+			var msgNoB [4]byte
+			if _, err := r.Read(msgNoB[:]); err != nil {
+				return nil
+			}
+			msgNo := int(binary.BigEndian.Uint32(msgNoB[:]))
+
+			msgKey, err := readUvarintBytes(r)
+			if err != nil {
+				return nil
+			}
+
+			var expiresB [8]byte
+			if _, err := r.Read(expiresB[:]); err != nil {
+				return nil
+			}
+
+			var expiresAt time.Time
+			if unix := binary.BigEndian.Uint64(expiresB[:]); unix != 0 {
+				expiresAt = time.Unix(int64(unix), 0)
+			}
+
+			s.Put(dhPub, msgNo, msgKey, expiresAt)
+		}
+	}
+
+	return nil
+}
+
+func writeUvarintBytes(buf *bytes.Buffer, b []byte) error {
+	var n [binary.MaxVarintLen64]byte
+	l := binary.PutUvarint(n[:], uint64(len(b)))
+	buf.Write(n[:l])
+	buf.Write(b)
+	return nil
+}
+
+func readUvarintBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, n)
+	if _, err := r.Read(b); err != nil {
+		return nil, fmt.Errorf("short read: %w", err)
+	}
+	return b, nil
+}
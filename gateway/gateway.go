@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package gateway wires bridge.Bridge implementations to ratchet peers,
+// turning a running Client into a relay between Salty/xochimilco sessions and
+// other chat networks.
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"go.salty.im/ratchet/bridge"
+	"go.salty.im/ratchet/client"
+	"golang.org/x/sync/errgroup"
+)
+
+// GatewayConfig describes a single relay between one bridge.Bridge and one
+// ratchet peer.
+type GatewayConfig struct {
+	// Name identifies this gateway in logs and errors.
+	Name string `json:"name"`
+
+	// Bridge is the name of a bridge.Factory registered in the Registry used
+	// to build the Manager.
+	Bridge string `json:"bridge"`
+
+	// Options is passed verbatim to the bridge.Factory.
+	Options map[string]string `json:"options"`
+
+	// Peer is the ratchet/Salty address this gateway relays to and from.
+	Peer string `json:"peer"`
+
+	// Channel is the bridge-side room/channel this gateway relays to and from.
+	Channel string `json:"channel"`
+}
+
+// Config is the top level gateway config file, a list of independent
+// gateways.
+type Config struct {
+	Gateways []GatewayConfig `json:"gateways"`
+}
+
+// LoadConfig reads and parses a Config from path.
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read gateway config: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parse gateway config: %w", err)
+	}
+
+	return &cfg, nil
+}
+
+// gw pairs a live bridge.Bridge with the GatewayConfig that created it.
+type gw struct {
+	GatewayConfig
+	bridge bridge.Bridge
+}
+
+// Manager owns the set of gateways relaying messages for a Client.
+type Manager struct {
+	c   *client.Client
+	gws []*gw
+}
+
+// New builds a Manager for every gateway in cfg, resolving bridges from reg.
+// The outbound side (Client events) is wired immediately; Connect/Receive is
+// started by Run.
+func New(c *client.Client, reg *bridge.Registry, cfg *Config) (*Manager, error) {
+	m := &Manager{c: c}
+
+	for _, gc := range cfg.Gateways {
+		b, err := reg.New(gc.Bridge, gc.Options)
+		if err != nil {
+			return nil, fmt.Errorf("gateway %q: %w", gc.Name, err)
+		}
+		m.gws = append(m.gws, &gw{GatewayConfig: gc, bridge: b})
+	}
+
+	m.wireOutbound()
+
+	return m, nil
+}
+
+// wireOutbound forwards Client events addressed to a gateway's Peer out to
+// that gateway's Bridge.
+func (m *Manager) wireOutbound() {
+	client.Subscribe(m.c, func(ctx context.Context, e client.OnMessageReceived) error {
+		for _, g := range m.gws {
+			if g.Peer != e.Them {
+				continue
+			}
+			_ = g.bridge.Send(ctx, bridge.Message{Channel: g.Channel, Nick: e.Them, Text: e.Raw})
+		}
+		return nil
+	})
+	client.Subscribe(m.c, func(ctx context.Context, e client.OnSaltyTextReceived) error {
+		for _, g := range m.gws {
+			if g.Peer != e.Msg.User.Nick {
+				continue
+			}
+			_ = g.bridge.Send(ctx, bridge.Message{Channel: g.Channel, Nick: e.Msg.User.Nick, Text: e.Msg.LiteralText()})
+		}
+		return nil
+	})
+}
+
+// Run connects every gateway's Bridge and pumps inbound messages into the
+// Client until ctx is canceled.
+func (m *Manager) Run(ctx context.Context) error {
+	wg, ctx := errgroup.WithContext(ctx)
+
+	for _, g := range m.gws {
+		g := g
+
+		if err := g.bridge.Connect(ctx); err != nil {
+			return fmt.Errorf("gateway %q: connect: %w", g.Name, err)
+		}
+
+		wg.Go(func() error { return m.pump(ctx, g) })
+	}
+
+	return wg.Wait()
+}
+
+// pump relays messages arriving on a gateway's Bridge to its ratchet Peer.
+func (m *Manager) pump(ctx context.Context, g *gw) error {
+	for {
+		select {
+		case msg, ok := <-g.bridge.Receive():
+			if !ok {
+				return nil
+			}
+			if err := m.c.SendSalty(ctx, g.Peer, msg.Text); err != nil {
+				return fmt.Errorf("gateway %q: send: %w", g.Name, err)
+			}
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
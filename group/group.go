@@ -0,0 +1,255 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package group adds many-to-many encrypted conversations on top of the
+// pairwise xochimilco sessions client.Client otherwise builds on. Rather
+// than running an n-way ratchet, it follows the sender-keys pattern used
+// by Signal-style group chat: each member advertises a symmetric chain
+// key (distributed over the existing 1:1 ratchet sessions, see
+// client.Client.CreateGroup), and every message that member sends is
+// sealed with the next key in their own chain. Recipients never need to
+// agree on ordering with each other, only with the single sender whose
+// chain they're advancing.
+package group
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/oklog/ulid/v2"
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"go.salty.im/ratchet/doubleratchet"
+)
+
+// maxSkippedMessages bounds how many not-yet-consumed message keys Open
+// caches per sender while fast-forwarding, the same way the double
+// ratchet's own SkippedKeyStore tolerates out-of-order delivery.
+const maxSkippedMessages = 1000
+
+// skippedChainID is the constant key Open's doubleratchet.SkippedKeyStore
+// caches under: a SenderKeyState only ever advances a single chain, so
+// there's no second DH public key to distinguish entries by.
+var skippedChainID = []byte("group-sender-key")
+
+// chain key ratchet labels, distinguishing the next chain key from the
+// per-message key derived from the same input as in the double ratchet's
+// own symmetric-key ratchet.
+var (
+	labelChain   = []byte{0x01}
+	labelMessage = []byte{0x02}
+)
+
+// SenderKeyState is one member's advancing chain key plus the sequence
+// number of the next message key it will produce. It is distributed to
+// every other member out-of-band (over a pairwise ratchet session) when a
+// member joins, and advanced forward by one step per message sent or
+// received from that member.
+type SenderKeyState struct {
+	ChainKey []byte
+	ChainNo  uint32
+
+	// skipped caches message keys for chain numbers behind ChainNo that
+	// Open has derived while fast-forwarding but not yet consumed,
+	// bounded to maxSkippedMessages entries. It is local, in-memory
+	// state only -- unexported so it is never part of the SenderKeyState
+	// distributed over the wire at invite/join time -- and is created
+	// lazily the first time Open needs it.
+	skipped doubleratchet.SkippedKeyStore
+}
+
+// NewSenderKeyState returns a freshly randomized chain key for a member
+// who just joined or created a group.
+func NewSenderKeyState() (*SenderKeyState, error) {
+	ck := make([]byte, sha256.Size)
+	if _, err := rand.Read(ck); err != nil {
+		return nil, fmt.Errorf("group: generate chain key: %w", err)
+	}
+	return &SenderKeyState{ChainKey: ck}, nil
+}
+
+// Seal encrypts plaintext under the next message key in s's chain and
+// advances s forward, so the same state can never seal two messages
+// under the same key. The returned frame is chainNo(4 bytes BE) || nonce
+// || ciphertext; chainNo lets a recipient fast-forward their copy of s if
+// they've missed earlier messages.
+func (s *SenderKeyState) Seal(plaintext []byte) ([]byte, error) {
+	msgKey := s.deriveMessageKey()
+	chainNo := s.ChainNo
+	s.advance()
+
+	aead, err := chacha20poly1305.New(msgKey)
+	if err != nil {
+		return nil, fmt.Errorf("group: new aead: %w", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("group: generate nonce: %w", err)
+	}
+
+	out := make([]byte, 4, 4+len(nonce)+len(plaintext)+aead.Overhead())
+	out[0], out[1], out[2], out[3] = byte(chainNo>>24), byte(chainNo>>16), byte(chainNo>>8), byte(chainNo)
+	out = append(out, nonce...)
+	out = aead.Seal(out, nonce, plaintext, nil)
+	return out, nil
+}
+
+// Open decrypts a frame produced by Seal. If the frame's chainNo is ahead
+// of s, s is fast-forwarded to it, caching every intermediate message key
+// skipped along the way (bounded to maxSkippedMessages) so a message that
+// arrives later out of order can still be opened. If chainNo is behind s,
+// the skipped cache is consulted instead of rejecting the frame outright,
+// tolerating backward as well as forward reordering; a chainNo neither
+// cached nor ahead of s is a replayed or stale frame and is rejected. A
+// chainNo more than maxSkippedMessages ahead of s is rejected outright
+// rather than fast-forwarded, the same bound doubleratchet.DoubleRatchet's
+// own skipMsgKeys enforces, so a single forged frame can't force a
+// recipient to derive millions of HMAC-SHA256 outputs before the AEAD tag
+// is even checked.
+func (s *SenderKeyState) Open(frame []byte) ([]byte, error) {
+	if len(frame) < 4 {
+		return nil, fmt.Errorf("group: truncated frame")
+	}
+	chainNo := uint32(frame[0])<<24 | uint32(frame[1])<<16 | uint32(frame[2])<<8 | uint32(frame[3])
+
+	if chainNo < s.ChainNo {
+		msgKey, ok := s.ensureSkipped().Take(skippedChainID, int(chainNo))
+		if !ok {
+			return nil, fmt.Errorf("group: chain key for message %d already consumed", chainNo)
+		}
+		return openFrame(msgKey, frame)
+	}
+
+	if chainNo-s.ChainNo > maxSkippedMessages {
+		return nil, fmt.Errorf("group: chain number %d is too far ahead of %d", chainNo, s.ChainNo)
+	}
+
+	for s.ChainNo < chainNo {
+		s.ensureSkipped().Insert(skippedChainID, int(s.ChainNo), s.deriveMessageKey())
+		s.advance()
+	}
+
+	msgKey := s.deriveMessageKey()
+	s.advance()
+
+	return openFrame(msgKey, frame)
+}
+
+// ensureSkipped lazily creates s's bounded skipped-message cache, so a
+// SenderKeyState learned from the wire (which never carries it) still has
+// somewhere to stash keys the first time Open needs to fast-forward.
+func (s *SenderKeyState) ensureSkipped() doubleratchet.SkippedKeyStore {
+	if s.skipped == nil {
+		s.skipped = doubleratchet.NewLRUStore(1, maxSkippedMessages, 0)
+	}
+	return s.skipped
+}
+
+// openFrame decrypts frame (chainNo(4 bytes BE) || nonce || ciphertext)
+// under msgKey.
+func openFrame(msgKey, frame []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(msgKey)
+	if err != nil {
+		return nil, fmt.Errorf("group: new aead: %w", err)
+	}
+	if len(frame) < 4+aead.NonceSize() {
+		return nil, fmt.Errorf("group: truncated frame")
+	}
+	nonce := frame[4 : 4+aead.NonceSize()]
+	ciphertext := frame[4+aead.NonceSize():]
+
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+func (s *SenderKeyState) deriveMessageKey() []byte {
+	return hmacSum(s.ChainKey, labelMessage)
+}
+
+func (s *SenderKeyState) advance() {
+	s.ChainKey = hmacSum(s.ChainKey, labelChain)
+	s.ChainNo++
+}
+
+func hmacSum(key, label []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(label)
+	return mac.Sum(nil)
+}
+
+// Session is a many-to-many conversation: a name, the set of members, and
+// every member's SenderKeyState known so far (including our own, used to
+// Seal outgoing messages). The caller's own identity is always a key of
+// Senders.
+type Session struct {
+	ID      ulid.ULID
+	Name    string
+	Me      string
+	Members []string
+
+	Senders map[string]*SenderKeyState
+}
+
+// New creates a Session for name with members (which should include Me),
+// generating a fresh SenderKeyState for Me.
+func New(me, name string, members []string) (*Session, error) {
+	own, err := NewSenderKeyState()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		ID:      ulid.Make(),
+		Name:    name,
+		Me:      me,
+		Members: members,
+		Senders: map[string]*SenderKeyState{me: own},
+	}, nil
+}
+
+// AddMember registers a newly joined member's SenderKeyState, learned out
+// of band (see client.Client.JoinGroup).
+func (g *Session) AddMember(who string, sender *SenderKeyState) {
+	for _, m := range g.Members {
+		if m == who {
+			g.Senders[who] = sender
+			return
+		}
+	}
+	g.Members = append(g.Members, who)
+	g.Senders[who] = sender
+}
+
+// RemoveMember drops who from the group; their SenderKeyState is
+// discarded so a former member's already-distributed chain key can't be
+// used to decrypt anything sent after they left.
+func (g *Session) RemoveMember(who string) {
+	delete(g.Senders, who)
+	for i, m := range g.Members {
+		if m == who {
+			g.Members = append(g.Members[:i], g.Members[i+1:]...)
+			return
+		}
+	}
+}
+
+// Seal encrypts plaintext under the caller's own advancing chain key.
+func (g *Session) Seal(plaintext []byte) ([]byte, error) {
+	own, ok := g.Senders[g.Me]
+	if !ok {
+		return nil, fmt.Errorf("group: no sender key state for self")
+	}
+	return own.Seal(plaintext)
+}
+
+// Open decrypts a frame received from who, using (and advancing) their
+// SenderKeyState.
+func (g *Session) Open(who string, frame []byte) ([]byte, error) {
+	sender, ok := g.Senders[who]
+	if !ok {
+		return nil, fmt.Errorf("group: no sender key state for %s", who)
+	}
+	return sender.Open(frame)
+}
@@ -0,0 +1,114 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+//
+// SPDX-License-Identifier: BSD-3-Clause
+package group_test
+
+import (
+	"testing"
+
+	"github.com/matryer/is"
+	"go.salty.im/ratchet/group"
+)
+
+func TestSenderKeyStateRoundTrip(t *testing.T) {
+	is := is.New(t)
+
+	sender, err := group.NewSenderKeyState()
+	is.NoErr(err)
+	recipient := &group.SenderKeyState{ChainKey: sender.ChainKey, ChainNo: sender.ChainNo}
+
+	sealed, err := sender.Seal([]byte("hello"))
+	is.NoErr(err)
+
+	got, err := recipient.Open(sealed)
+	is.NoErr(err)
+	is.Equal(string(got), "hello")
+}
+
+func TestSenderKeyStateOutOfOrderForward(t *testing.T) {
+	is := is.New(t)
+
+	sender, err := group.NewSenderKeyState()
+	is.NoErr(err)
+	recipient := &group.SenderKeyState{ChainKey: sender.ChainKey, ChainNo: sender.ChainNo}
+
+	var frames [][]byte
+	for _, msg := range []string{"one", "two", "three"} {
+		f, err := sender.Seal([]byte(msg))
+		is.NoErr(err)
+		frames = append(frames, f)
+	}
+
+	// Deliver "three" before "one" and "two": Open must fast-forward and
+	// cache the skipped keys rather than reject it.
+	got, err := recipient.Open(frames[2])
+	is.NoErr(err)
+	is.Equal(string(got), "three")
+
+	got, err = recipient.Open(frames[0])
+	is.NoErr(err)
+	is.Equal(string(got), "one")
+
+	got, err = recipient.Open(frames[1])
+	is.NoErr(err)
+	is.Equal(string(got), "two")
+}
+
+func TestSenderKeyStateForwardJumpBounded(t *testing.T) {
+	is := is.New(t)
+
+	sender, err := group.NewSenderKeyState()
+	is.NoErr(err)
+	recipient := &group.SenderKeyState{ChainKey: sender.ChainKey, ChainNo: sender.ChainNo}
+
+	// Forge a frame claiming a chain number far beyond anything a real
+	// sender (which only ever advances by one per Seal) would produce.
+	sealed, err := sender.Seal([]byte("hi"))
+	is.NoErr(err)
+	forged := append([]byte(nil), sealed...)
+	forged[0], forged[1], forged[2], forged[3] = 0x01, 0x31, 0x2d, 0x00 // chainNo ~= 20,000,000
+
+	_, err = recipient.Open(forged)
+	is.True(err != nil)
+}
+
+func TestSenderKeyStateReplayRejected(t *testing.T) {
+	is := is.New(t)
+
+	sender, err := group.NewSenderKeyState()
+	is.NoErr(err)
+	recipient := &group.SenderKeyState{ChainKey: sender.ChainKey, ChainNo: sender.ChainNo}
+
+	sealed, err := sender.Seal([]byte("hello"))
+	is.NoErr(err)
+
+	_, err = recipient.Open(sealed)
+	is.NoErr(err)
+
+	_, err = recipient.Open(sealed)
+	is.True(err != nil)
+}
+
+func TestSessionRemoveMemberDropsSenderKey(t *testing.T) {
+	is := is.New(t)
+
+	sess, err := group.New("alice", "crew", []string{"alice", "bob"})
+	is.NoErr(err)
+
+	bobSender, err := group.NewSenderKeyState()
+	is.NoErr(err)
+	bobReplica := &group.SenderKeyState{ChainKey: bobSender.ChainKey, ChainNo: bobSender.ChainNo}
+	sess.AddMember("bob", bobReplica)
+
+	sealed, err := bobSender.Seal([]byte("hi"))
+	is.NoErr(err)
+
+	got, err := sess.Open("bob", sealed)
+	is.NoErr(err)
+	is.Equal(string(got), "hi")
+
+	sess.RemoveMember("bob")
+
+	_, err = sess.Open("bob", sealed)
+	is.True(err != nil)
+}
@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package group
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotExist is returned by Get/Delete for a group that isn't known, and
+// ErrExist by New for a name already in use.
+var (
+	ErrNotExist = errors.New("does not exist")
+	ErrExist    = errors.New("already exists")
+)
+
+// Manager is the storage backend client.Client's group-chat support is
+// built on, mirroring session.Manager's shape for the pairwise case: a
+// place to create, fetch, persist, and enumerate a user's Sessions by
+// name.
+type Manager interface {
+	New(me, name string, members []string) (*Session, error)
+	Get(name string) (*Session, error)
+	Put(g *Session) error
+	Delete(g *Session) error
+	Groups() []string
+}
+
+// MemManager is a Manager backed by an in-process map. It never touches
+// the filesystem, so it suits tests and the default client.Client
+// configuration; state does not outlive the process.
+type MemManager struct {
+	groups map[string]*Session
+}
+
+// NewMemManager returns an empty MemManager.
+func NewMemManager() *MemManager {
+	return &MemManager{groups: make(map[string]*Session)}
+}
+
+func (m *MemManager) New(me, name string, members []string) (*Session, error) {
+	if _, ok := m.groups[name]; ok {
+		return nil, fmt.Errorf("group %s: %w", name, ErrExist)
+	}
+	return New(me, name, members)
+}
+func (m *MemManager) Get(name string) (*Session, error) {
+	g, ok := m.groups[name]
+	if !ok {
+		return nil, fmt.Errorf("group %s: %w", name, ErrNotExist)
+	}
+	return g, nil
+}
+func (m *MemManager) Put(g *Session) error {
+	m.groups[g.Name] = g
+	return nil
+}
+func (m *MemManager) Delete(g *Session) error {
+	if _, ok := m.groups[g.Name]; !ok {
+		return fmt.Errorf("group %s: %w", g.Name, ErrNotExist)
+	}
+	delete(m.groups, g.Name)
+	return nil
+}
+func (m *MemManager) Groups() []string {
+	lis := make([]string, 0, len(m.groups))
+	for name := range m.groups {
+		lis = append(lis, name)
+	}
+	return lis
+}
+
+var _ Manager = (*MemManager)(nil)
@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package headless drives a client.Client from line-oriented commands on
+// stdin and reports every client event as a JSON line on stdout. The
+// ui/interactive front-ends are built for a human at a terminal; this one
+// exists so bots, test harnesses, and other external programs can attach
+// to a running ratchet session without a TTY.
+package headless
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"go.salty.im/ratchet/client"
+	"go.salty.im/ratchet/interactive"
+)
+
+// Service is the headless counterpart to interactive.Service: a subset of
+// its verbs (/chat, /close, /salty, /quit, and plain text to the current
+// peer), the same underlying client.Client, a JSON line protocol instead
+// of a prompt.
+type Service struct {
+	*client.Client
+	out io.Writer
+}
+
+// New wraps c for headless control. Events are written to stdout.
+func New(c *client.Client) *Service {
+	return &Service{Client: c, out: os.Stdout}
+}
+
+// Run starts the REPL on stdin and blocks on the client's driver, same
+// contract as interactive.Service.Run.
+func (svc *Service) Run(ctx context.Context, me, them string) error {
+	ctx2, cancel := context.WithCancel(ctx)
+	go svc.repl(ctx, me, them, cancel)
+	return svc.Client.Run(ctx2)
+}
+
+// event is the envelope every client event and command reply is printed
+// as: one JSON object per line, e.g. {"event":"OnMessageReceived","data":{...}}.
+type event struct {
+	Event string `json:"event"`
+	Data  any    `json:"data,omitempty"`
+}
+
+// reply is the envelope for the result of a command read from stdin.
+type reply struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func (svc *Service) repl(ctx context.Context, me, them string, quit func()) {
+	subscribeJSON[client.OnOfferSent](svc)
+	subscribeJSON[client.OnOfferReceived](svc)
+	subscribeJSON[client.OnSessionStarted](svc)
+	subscribeJSON[client.OnSessionClosed](svc)
+	subscribeJSON[client.OnMessageReceived](svc)
+	subscribeJSON[client.OnMessageSent](svc)
+	subscribeJSON[client.OnSaltySent](svc)
+	subscribeJSON[client.OnSaltyTextReceived](svc)
+	subscribeJSON[client.OnSaltyEventReceived](svc)
+	subscribeJSON[client.OnReceived](svc)
+	subscribeJSON[error](svc)
+
+	scanner := bufio.NewScanner(interactive.NewCtxReader(ctx, os.Stdin))
+
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return
+		}
+
+		input := strings.TrimSpace(scanner.Text())
+		if input == "" {
+			continue
+		}
+
+		if strings.HasPrefix(input, "/quit") {
+			quit()
+			return
+		}
+
+		err := svc.dispatch(ctx, me, &them, input)
+		r := reply{OK: err == nil}
+		if err != nil {
+			r.Error = err.Error()
+		}
+		svc.write(r)
+	}
+}
+
+func (svc *Service) dispatch(ctx context.Context, me string, them *string, input string) error {
+	switch {
+	case strings.HasPrefix(input, "/chat "):
+		*them = strings.TrimSpace(strings.TrimPrefix(input, "/chat "))
+		if me == *them {
+			return fmt.Errorf("cant ratchet with self")
+		}
+		_, err := svc.Chat(ctx, *them)
+		return err
+
+	case strings.HasPrefix(input, "/close"):
+		target := strings.TrimSpace(strings.TrimPrefix(input, "/close"))
+		if target == "" {
+			target = *them
+		}
+		if target == "" {
+			return fmt.Errorf("no active session")
+		}
+		*them = ""
+		return svc.Close(ctx, target)
+
+	case strings.HasPrefix(input, "/salty "):
+		target, msg, _ := strings.Cut(strings.TrimPrefix(input, "/salty "), " ")
+		return svc.SendSalty(ctx, target, msg)
+
+	default:
+		if *them == "" {
+			return fmt.Errorf("no active session; /chat <peer> first")
+		}
+		return svc.Send(ctx, *them, input)
+	}
+}
+
+func subscribeJSON[T any](svc *Service) {
+	client.Subscribe(svc.Client, func(ctx context.Context, args T) error {
+		svc.write(event{Event: eventName(args), Data: args})
+		return nil
+	})
+}
+
+func eventName(v any) string {
+	if t := reflect.TypeOf(v); t != nil {
+		return t.Name()
+	}
+	return "error"
+}
+
+func (svc *Service) write(v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(svc.out, string(b))
+}
@@ -0,0 +1,163 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package interactive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrQuit is returned by the /quit command to tell the read loop in
+// Interactive to stop, rather than just log the error and continue like
+// every other command's failure.
+var ErrQuit = errors.New("quit")
+
+// Command is one slash command the interactive loop can dispatch to.
+// Built-ins (/help, /chat, /close, /salty, /send, /file, /edit, /alias,
+// /log, /quit) are
+// registered through RegisterCommand the same as anything else, so a
+// caller embedding Service can add its own without forking the dispatch
+// loop.
+type Command struct {
+	// Usage is the one-line invocation form /help prints, e.g. "/chat [name]".
+	Usage string
+	// Help is a short sentence describing the command, printed next to
+	// Usage by /help.
+	Help string
+	// MinArgs is the fewest whitespace-separated arguments (the command
+	// name itself doesn't count) Run requires. Fewer prints Usage instead
+	// of calling Run.
+	MinArgs int
+	// Perm reports whether me may run this command. A nil Perm permits
+	// everyone.
+	Perm func(me string) bool
+	// Run executes the command against svc with the tokenized arguments.
+	Run func(ctx context.Context, svc *Service, args []string) error
+}
+
+var (
+	commandsMu sync.Mutex
+	commands   = map[string]Command{}
+)
+
+// RegisterCommand adds cmd to the registry under name, including the
+// leading "/". Registering the same name twice replaces the earlier
+// Command.
+func RegisterCommand(name string, cmd Command) {
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+	commands[name] = cmd
+}
+
+func lookupCommand(name string) (Command, bool) {
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+	cmd, ok := commands[name]
+	return cmd, ok
+}
+
+// dispatch tokenizes input, looks up the leading word in the registry, and
+// enforces its Perm and MinArgs before calling Run. A name with no matching
+// Command reports "unknown command", same as the prefix chain it replaces
+// used to fall through when nothing matched.
+func (svc *Service) dispatch(ctx context.Context, input string) error {
+	args := strings.Fields(input)
+	name, args := args[0], args[1:]
+
+	cmd, ok := lookupCommand(name)
+	if !ok {
+		return fmt.Errorf("unknown command: %s", name)
+	}
+
+	if cmd.Perm != nil && !cmd.Perm(svc.me) {
+		return fmt.Errorf("permission denied: %s", name)
+	}
+
+	if len(args) < cmd.MinArgs {
+		svc.log.Info("usage: " + cmd.Usage)
+		return nil
+	}
+
+	return cmd.Run(ctx, svc, args)
+}
+
+// help prints Usage and Help for every registered command, sorted by name.
+func (svc *Service) help() {
+	commandsMu.Lock()
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	commandsMu.Unlock()
+	sort.Strings(names)
+
+	fmt.Fprintln(svc.out, "\nCOMMANDS:")
+	for _, name := range names {
+		cmd, _ := lookupCommand(name)
+		fmt.Fprintf(svc.out, "  %-20s %s\n", cmd.Usage, cmd.Help)
+	}
+}
+
+func init() {
+	RegisterCommand("/help", Command{
+		Usage: "/help",
+		Help:  "list available commands",
+		Run:   func(ctx context.Context, svc *Service, args []string) error { svc.help(); return nil },
+	})
+	RegisterCommand("/chat", Command{
+		Usage: "/chat [name]",
+		Help:  "start or switch to a conversation; with no name, list open sessions",
+		Run:   cmdChat,
+	})
+	RegisterCommand("/close", Command{
+		Usage: "/close [name]",
+		Help:  "close the current or named session",
+		Run:   cmdClose,
+	})
+	RegisterCommand("/salty", Command{
+		Usage:   "/salty name message",
+		Help:    "send a one-off salty message without opening a session",
+		MinArgs: 1,
+		Run:     cmdSalty,
+	})
+	RegisterCommand("/send", Command{
+		Usage:   "/send path",
+		Help:    "send the file at path as a blob to the current session",
+		MinArgs: 1,
+		Run:     cmdSend,
+	})
+	// /file is an alias for /send: attaching a file is already chunked,
+	// checksummed and reassembled by client.SendBlob/OnBlobReceived, so
+	// there's no separate transfer path to build here.
+	RegisterCommand("/file", Command{
+		Usage:   "/file path",
+		Help:    "alias for /send",
+		MinArgs: 1,
+		Run:     cmdSend,
+	})
+	RegisterCommand("/edit", Command{
+		Usage: "/edit",
+		Help:  "compose a message in $VISUAL/$EDITOR and send it",
+		Run:   cmdEdit,
+	})
+	RegisterCommand("/alias", Command{
+		Usage:   "/alias name address",
+		Help:    "bind a short name to a full salty address for /chat, /close and /salty to resolve",
+		MinArgs: 2,
+		Run:     cmdAlias,
+	})
+	RegisterCommand("/log", Command{
+		Usage: "/log [name]",
+		Help:  "show the last lines of the current or named log",
+		Run:   cmdLog,
+	})
+	RegisterCommand("/quit", Command{
+		Usage: "/quit",
+		Help:  "exit ratchet",
+		Run:   func(ctx context.Context, svc *Service, args []string) error { svc.quit(); return ErrQuit },
+	})
+}
@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+// This file lives in package interactive (not interactive_test) so it can
+// exercise dispatch and the unexported commands map directly, without
+// standing up a real readline prompt.
+package interactive
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/matryer/is"
+	rlog "go.salty.im/ratchet/log"
+)
+
+func newTestService() *Service {
+	return &Service{log: rlog.New(io.Discard, rlog.JSON, rlog.Info)}
+}
+
+func TestRegisterCommand(t *testing.T) {
+	is := is.New(t)
+
+	var got []string
+	RegisterCommand("/test-register", Command{
+		Usage: "/test-register",
+		Help:  "a command registered for TestRegisterCommand",
+		Run: func(ctx context.Context, svc *Service, args []string) error {
+			got = args
+			return nil
+		},
+	})
+
+	svc := newTestService()
+	is.NoErr(svc.dispatch(context.Background(), "/test-register a b"))
+	is.Equal(got, []string{"a", "b"})
+}
+
+func TestDispatchUnknownCommand(t *testing.T) {
+	is := is.New(t)
+
+	svc := newTestService()
+	err := svc.dispatch(context.Background(), "/does-not-exist")
+	is.True(err != nil)
+}
+
+func TestDispatchMinArgs(t *testing.T) {
+	is := is.New(t)
+
+	var ran bool
+	RegisterCommand("/test-minargs", Command{
+		Usage:   "/test-minargs arg",
+		MinArgs: 1,
+		Run: func(ctx context.Context, svc *Service, args []string) error {
+			ran = true
+			return nil
+		},
+	})
+
+	svc := newTestService()
+
+	is.NoErr(svc.dispatch(context.Background(), "/test-minargs"))
+	is.True(!ran) // too few args: Run must not be called
+
+	is.NoErr(svc.dispatch(context.Background(), "/test-minargs arg"))
+	is.True(ran)
+}
+
+func TestDispatchPermDenied(t *testing.T) {
+	is := is.New(t)
+
+	RegisterCommand("/test-perm", Command{
+		Usage: "/test-perm",
+		Perm:  func(me string) bool { return me == "allowed@sour.is" },
+		Run:   func(ctx context.Context, svc *Service, args []string) error { return nil },
+	})
+
+	svc := newTestService()
+	svc.me = "someone-else@sour.is"
+
+	err := svc.dispatch(context.Background(), "/test-perm")
+	is.True(err != nil)
+
+	svc.me = "allowed@sour.is"
+	is.NoErr(svc.dispatch(context.Background(), "/test-perm"))
+}
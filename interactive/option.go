@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package interactive
+
+import (
+	"io"
+
+	"github.com/chzyer/readline"
+	"go.salty.im/ratchet/roster"
+)
+
+// Option customizes a Service before its readline prompt starts, letting a
+// caller host the same chat loop somewhere other than the local terminal
+// (an SSH channel, say) without forking Interactive.
+type Option func(svc *Service, cfg *readline.Config)
+
+// WithIO redirects the prompt's input/output away from os.Stdin/os.Stdout,
+// and tells readline the stream isn't a real tty so it doesn't try to put
+// one in raw mode itself -- the remote end (an ssh client with a pty) owns
+// that already.
+func WithIO(stdin io.ReadCloser, stdout io.Writer) Option {
+	return func(svc *Service, cfg *readline.Config) {
+		svc.out = stdout
+		cfg.Stdin = stdin
+		cfg.Stdout = stdout
+		cfg.Stderr = stdout
+		cfg.ForceUseInteractive = true
+		cfg.FuncMakeRaw = func() error { return nil }
+		cfg.FuncExitRaw = func() error { return nil }
+	}
+}
+
+// WithTerminalSize wires readline's width query and resize notification to
+// width and notify instead of the local terminal's own SIGWINCH handling.
+func WithTerminalSize(width func() int, notify func(onChange func())) Option {
+	return func(svc *Service, cfg *readline.Config) {
+		cfg.FuncGetWidth = width
+		cfg.FuncOnWidthChanged = notify
+	}
+}
+
+// WithHistoryFile overrides the default shared history file, so concurrent
+// Services (one per SSH session, say) don't corrupt each other's history.
+func WithHistoryFile(path string) Option {
+	return func(svc *Service, cfg *readline.Config) {
+		cfg.HistoryFile = path
+	}
+}
+
+// WithRoster lets /chat, /close, /salty and /alias resolve short names
+// through r instead of requiring a fully qualified address every time.
+func WithRoster(r *roster.Roster) Option {
+	return func(svc *Service, cfg *readline.Config) {
+		svc.roster = r
+	}
+}
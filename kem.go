@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package xochimilco
+
+import (
+	"crypto/sha256"
+	"io"
+
+	"github.com/cloudflare/circl/kem/kyber/kyber768"
+	"golang.org/x/crypto/hkdf"
+)
+
+// kemScheme is the post-quantum KEM mixed into the X3DH handshake; see
+// offerMessagePQ and ackMessagePQ for the wire shapes it rides in, and
+// mixKEM for how its shared secret reaches the session key.
+var kemScheme = kyber768.Scheme()
+
+const (
+	kemPublicKeySize  = kyber768.PublicKeySize
+	kemCiphertextSize = kyber768.CiphertextSize
+)
+
+// kemGenerateKey creates a one-time Kyber768 encapsulation/decapsulation key
+// pair, the PQ analogue of x3dh.CreateNewSpk's signed prekey: used for a
+// single handshake, then discarded.
+func kemGenerateKey() (pub, priv []byte, err error) {
+	pk, sk, err := kemScheme.GenerateKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+	if pub, err = pk.MarshalBinary(); err != nil {
+		return nil, nil, err
+	}
+	priv, err = sk.MarshalBinary()
+	return pub, priv, err
+}
+
+// kemEncapsulate produces a ciphertext and shared secret for pubKey.
+func kemEncapsulate(pubKey []byte) (ciphertext, sharedSecret []byte, err error) {
+	pk, err := kemScheme.UnmarshalBinaryPublicKey(pubKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return kemScheme.Encapsulate(pk)
+}
+
+// kemDecapsulate recovers the shared secret ciphertext encapsulates to
+// privKey.
+func kemDecapsulate(privKey, ciphertext []byte) (sharedSecret []byte, err error) {
+	sk, err := kemScheme.UnmarshalBinaryPrivateKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+	return kemScheme.Decapsulate(sk, ciphertext)
+}
+
+// mixKEM folds a Kyber768 shared secret into an already-derived X3DH session
+// key via a second HKDF pass, hardening it against a future quantum
+// adversary who recorded today's handshake. The vendored x3dh package
+// (github.com/sour-is/xochimilco/x3dh) derives sessKey from an HKDF over
+// DH1||DH2||DH3 internally and doesn't expose those intermediate values, so
+// this can't fold kemSS into that single derivation as one concatenated
+// input; chaining a second HKDF over sessKey||kemSS gives the same
+// quantum-hardening property, since sessKey already depends on all three DH
+// outputs.
+func mixKEM(sessKey, kemSS []byte) ([]byte, error) {
+	ikm := append(append([]byte{}, sessKey...), kemSS...)
+	h := hkdf.New(sha256.New, ikm, nil, []byte("xochimilco-pqxdh"))
+
+	out := make([]byte, len(sessKey))
+	if _, err := io.ReadFull(h, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package xochimilco
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestKEMDeriveKeyPairIsKnownAnswer checks that DeriveKeyPair is a known-answer
+// function: the same seed always reproduces the same Kyber768 key pair, which
+// is what lets offer/ack KAT vectors for a fixed seed stay stable across runs
+// and circl versions.
+func TestKEMDeriveKeyPairIsKnownAnswer(t *testing.T) {
+	seedA := bytes.Repeat([]byte{0x01}, kemScheme.SeedSize())
+	seedB := bytes.Repeat([]byte{0x02}, kemScheme.SeedSize())
+
+	pub1, _ := deriveKEMPublicKey(t, seedA)
+	pub2, _ := deriveKEMPublicKey(t, seedA)
+	if !bytes.Equal(pub1, pub2) {
+		t.Fatalf("deriving from the same seed twice gave different public keys")
+	}
+
+	pub3, _ := deriveKEMPublicKey(t, seedB)
+	if bytes.Equal(pub1, pub3) {
+		t.Fatal("deriving from different seeds gave the same public key")
+	}
+}
+
+func deriveKEMPublicKey(t *testing.T, seed []byte) (pub, priv []byte) {
+	t.Helper()
+
+	pk, sk := kemScheme.DeriveKeyPair(seed)
+	pub, err := pk.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err = sk.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// TestKEMRoundTrip derives a key pair from a fixed seed, the way an offer/ack
+// KAT vector would, and checks that encapsulating to its public key followed
+// by decapsulating with its private key yields matching shared secrets.
+func TestKEMRoundTrip(t *testing.T) {
+	seed := bytes.Repeat([]byte{0x2a}, kemScheme.SeedSize())
+	pub, priv := deriveKEMPublicKey(t, seed)
+
+	ciphertext, sharedSecret, err := kemEncapsulate(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ciphertext) != kemCiphertextSize {
+		t.Fatalf("unexpected ciphertext size %d, want %d", len(ciphertext), kemCiphertextSize)
+	}
+
+	gotSecret, err := kemDecapsulate(priv, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sharedSecret, gotSecret) {
+		t.Fatalf("shared secrets differ, %x %x", sharedSecret, gotSecret)
+	}
+}
+
+// TestKEMDecapsulateWrongKey ensures a ciphertext encapsulated to one key
+// does not decapsulate to the same shared secret under an unrelated key.
+func TestKEMDecapsulateWrongKey(t *testing.T) {
+	pub1, _, err := kemGenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, priv2, err := kemGenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext, sharedSecret, err := kemEncapsulate(pub1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotSecret, err := kemDecapsulate(priv2, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(sharedSecret, gotSecret) {
+		t.Fatal("decapsulating under the wrong key produced the same shared secret")
+	}
+}
+
+// TestMixKEM checks that mixKEM is deterministic given the same inputs, and
+// that it diverges when the KEM shared secret changes, since a future quantum
+// adversary's recorded DH transcript alone must not be enough to derive the
+// mixed root key.
+func TestMixKEM(t *testing.T) {
+	sessKey := bytes.Repeat([]byte{0x11}, 32)
+	kemSS := bytes.Repeat([]byte{0x22}, 32)
+
+	out1, err := mixKEM(sessKey, kemSS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out2, err := mixKEM(sessKey, kemSS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out1, out2) {
+		t.Fatalf("mixKEM is not deterministic, %x %x", out1, out2)
+	}
+
+	otherSS := bytes.Repeat([]byte{0x33}, 32)
+	out3, err := mixKEM(sessKey, otherSS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(out1, out3) {
+		t.Fatal("mixKEM ignored the KEM shared secret")
+	}
+}
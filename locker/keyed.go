@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+//
+// SPDX-License-Identifier: BSD-3-Clause
+package locker
+
+import (
+	"context"
+	"sync"
+)
+
+// Keyed holds a distinct Locked[V] per key K, so Use calls against different
+// keys queue independently instead of all serialising behind one Locked the
+// way a single shared resource would. This suits a backend where each key
+// identifies its own independent critical section (e.g. one ratchet
+// session's remote blob) but the backend as a whole has no single value to
+// guard.
+type Keyed[K comparable, V any] struct {
+	mu    sync.Mutex
+	new   func() V
+	locks map[K]*Locked[V]
+}
+
+// NewKeyed creates a Keyed locker that lazily allocates a Locked[V] the
+// first time a given key is used, seeded with new().
+func NewKeyed[K comparable, V any](new func() V) *Keyed[K, V] {
+	return &Keyed[K, V]{
+		new:   new,
+		locks: make(map[K]*Locked[V]),
+	}
+}
+
+// Use calls fn with the value locked under key, allocating that key's
+// Locked[V] on first use. It never removes a key's Locked once created, so a
+// caller cycling through an unbounded key space will grow this map
+// unboundedly; that's fine for ratchet's per-session use (a session-UUID
+// keyspace bounded by how many peers a user has), but Keyed isn't meant for
+// ephemeral or unbounded keys.
+func (k *Keyed[K, V]) Use(ctx context.Context, key K, fn func(context.Context, V) error) error {
+	k.mu.Lock()
+	l, ok := k.locks[key]
+	if !ok {
+		l = New(k.new())
+		k.locks[key] = l
+	}
+	k.mu.Unlock()
+
+	return l.Use(ctx, fn)
+}
@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+//
+// SPDX-License-Identifier: BSD-3-Clause
+package locker_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"go.salty.im/ratchet/locker"
+)
+
+func TestKeyed(t *testing.T) {
+	is := is.New(t)
+
+	k := locker.NewKeyed[string](func() int { return 0 })
+	ctx := context.Background()
+
+	is.NoErr(k.Use(ctx, "a", func(ctx context.Context, v int) error { return nil }))
+
+	var got int
+	is.NoErr(k.Use(ctx, "a", func(ctx context.Context, v int) error {
+		got = v
+		return nil
+	}))
+	is.Equal(got, 0)
+}
+
+func TestKeyedDifferentKeysDontSerialize(t *testing.T) {
+	k := locker.NewKeyed[string](func() int { return 0 })
+	ctx := context.Background()
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = k.Use(ctx, "a", func(ctx context.Context, v int) error {
+			close(entered)
+			<-release
+			return nil
+		})
+	}()
+
+	<-entered
+
+	// "b" must not block behind "a"'s held lock.
+	done := make(chan struct{})
+	go func() {
+		_ = k.Use(ctx, "b", func(ctx context.Context, v int) error { return nil })
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Use(\"b\", ...) blocked behind a held lock on \"a\"")
+	}
+
+	close(release)
+	wg.Wait()
+}
@@ -0,0 +1,215 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package log is ratchet's structured logging. Every session event — an
+// offer sent or received, a session started or closed, a message or salty
+// text sent or received — goes through Logger.Event, and everything else
+// through Debug/Info/Warn/Error, replacing the ad-hoc fmt.Printf/os.Stderr
+// calls that main and interactive used to duplicate. A Format (see
+// ParseFormat) controls whether a line comes out as the ANSI-colored text
+// the interactive TUI has always shown, or as JSON/logfmt for a
+// --log-format daemon operators can pipe into journald or Loki.
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	stdsync "sync"
+	"time"
+)
+
+// Level orders log severity, least to most urgent.
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+func (l Level) String() string {
+	switch l {
+	case Debug:
+		return "debug"
+	case Info:
+		return "info"
+	case Warn:
+		return "warn"
+	case Error:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Dir tags an Event by who originated it, so the pretty renderer can color
+// outbound, inbound, and system lines differently.
+type Dir string
+
+const (
+	Out Dir = "->" // sent to a peer
+	In  Dir = "<-" // received from a peer
+	Sys Dir = "::" // neither: a local state change or error
+)
+
+// Format selects how a Logger renders each line.
+type Format int
+
+const (
+	Pretty Format = iota
+	JSON
+	Logfmt
+)
+
+// ParseFormat maps a --log-format value to a Format. An empty string is
+// Pretty, the interactive TUI's long-standing default.
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "", "pretty":
+		return Pretty, nil
+	case "json":
+		return JSON, nil
+	case "logfmt":
+		return Logfmt, nil
+	default:
+		return Pretty, fmt.Errorf("unknown log format %q", s)
+	}
+}
+
+// Logger writes leveled, optionally directional log lines to w in Format.
+// It is safe for concurrent use.
+type Logger struct {
+	mu     stdsync.Mutex
+	w      io.Writer
+	format Format
+	level  Level
+}
+
+// New returns a Logger that writes to w in format, suppressing anything
+// below level.
+func New(w io.Writer, format Format, level Level) *Logger {
+	return &Logger{w: w, format: format, level: level}
+}
+
+func (l *Logger) Debug(msg string, kv ...any) { l.log(Debug, "", Sys, msg, kv) }
+func (l *Logger) Info(msg string, kv ...any)  { l.log(Info, "", Sys, msg, kv) }
+func (l *Logger) Warn(msg string, kv ...any)  { l.log(Warn, "", Sys, msg, kv) }
+func (l *Logger) Error(msg string, kv ...any) { l.log(Error, "", Sys, msg, kv) }
+
+// Event logs one directional session event: peer is who it's to or from
+// (empty for a purely local event), dir is Out/In/Sys, verb names the kind
+// of event ("offer", "session-started", "message", "salty", ...), and kv
+// are alternating key/value detail pairs (e.g. "text", msg.LiteralText()).
+// Event always logs at Info: by the time a caller has a session event
+// worth reporting, it's never merely diagnostic.
+func (l *Logger) Event(peer string, dir Dir, verb string, kv ...any) {
+	l.log(Info, peer, dir, verb, kv)
+}
+
+func (l *Logger) log(level Level, peer string, dir Dir, verb string, kv []any) {
+	if level < l.level {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch l.format {
+	case JSON:
+		l.writeJSON(level, peer, dir, verb, kv)
+	case Logfmt:
+		l.writeLogfmt(level, peer, dir, verb, kv)
+	default:
+		l.writePretty(level, peer, dir, verb, kv)
+	}
+}
+
+func (l *Logger) writePretty(_ Level, peer string, dir Dir, verb string, kv []any) {
+	var b strings.Builder
+
+	b.WriteString(colorGrey)
+	b.WriteString(time.Now().Format("15:04:05"))
+	b.WriteByte(' ')
+	b.WriteString(colorForDir(dir))
+	b.WriteString(string(dir))
+	b.WriteString(colorGrey)
+
+	if peer != "" {
+		fmt.Fprintf(&b, " %s", peer)
+	}
+	fmt.Fprintf(&b, " %s", verb)
+
+	for i := 0; i+1 < len(kv); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", kv[i], kv[i+1])
+	}
+	b.WriteString(resetColor)
+
+	fmt.Fprintln(l.w, b.String())
+}
+
+func (l *Logger) writeJSON(level Level, peer string, dir Dir, verb string, kv []any) {
+	m := make(map[string]any, 4+len(kv)/2)
+	m["time"] = time.Now().Format(time.RFC3339)
+	m["level"] = level.String()
+	m["dir"] = string(dir)
+	m["verb"] = verb
+	if peer != "" {
+		m["peer"] = peer
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		if k, ok := kv[i].(string); ok {
+			m[k] = kv[i+1]
+		}
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	l.w.Write(append(b, '\n'))
+}
+
+func (l *Logger) writeLogfmt(level Level, peer string, dir Dir, verb string, kv []any) {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "time=%s level=%s dir=%q verb=%q", time.Now().Format(time.RFC3339), level, string(dir), verb)
+	if peer != "" {
+		fmt.Fprintf(&b, " peer=%s", logfmtValue(peer))
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		fmt.Fprintf(&b, " %v=%s", kv[i], logfmtValue(kv[i+1]))
+	}
+
+	fmt.Fprintln(l.w, b.String())
+}
+
+// logfmtValue quotes v's string form if it contains anything that would
+// make it ambiguous as a bare logfmt value.
+func logfmtValue(v any) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " \t\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+const (
+	colorGrey  = "\033[90m"
+	colorGreen = "\033[32m"
+	colorBlue  = "\033[34m"
+	resetColor = "\033[0m"
+)
+
+func colorForDir(d Dir) string {
+	switch d {
+	case Out:
+		return colorGreen
+	case In:
+		return colorBlue
+	default:
+		return colorGrey
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"os/signal"
 	"strings"
@@ -26,9 +27,14 @@ import (
 
 	"go.salty.im/ratchet/cli"
 	"go.salty.im/ratchet/client"
-	driver_msgbus "go.salty.im/ratchet/client/driver-msgbus"
+	"go.salty.im/ratchet/daemon"
 	"go.salty.im/ratchet/interactive"
+	rlog "go.salty.im/ratchet/log"
+	"go.salty.im/ratchet/roster"
 	"go.salty.im/ratchet/session"
+	"go.salty.im/ratchet/sshd"
+	"go.salty.im/ratchet/transport/msgbus"
+	"go.salty.im/ratchet/transport/tor"
 	"go.salty.im/ratchet/ui"
 )
 
@@ -36,70 +42,112 @@ var usage = `Ratchet Chat.
 Usage:
   ratchet [options] recv
   ratchet [options] (offer|send|close) <them>
+  ratchet [options] send-file <them> <path>
   ratchet [options] chat [<them>]
   ratchet [options] ui
+  ratchet [options] daemon
+  ratchet [options] sshd <addr>
 
 Args:
   <them>             Receiver acct name to use in offer.
+  <path>             File to send as an attachment.
+  <addr>             Address for sshd to listen on, e.g. :2222.
 
 Options:
   --key <key>        Sender private key [default: ` + xdg.Get(xdg.EnvConfigHome, "racthet/$USER.key") + `]
   --state <state>    Session state path [default: ` + xdg.Get(xdg.EnvStateHome, "racthet") + `]
+  --store <uri>      Session store, redis://host:port/db to share state
+                      across processes. Defaults to a file store under --state.
   --log <logs>       Log storage path   [default: ` + xdg.Get(xdg.EnvDataHome, "ratchet") + `]
   --msg <msg>        Msg to read in.    [default to read Standard Input]
   --msg-file <file>  File to read input from.
   --msg-stdin        Read standard input.
   --post             Send to msgbus
+  --socket <path>    JSON-RPC control socket for daemon [default: ` + xdg.Get(xdg.EnvRuntime, "ratchet.sock") + `]
+  --tcp <addr>       Also serve the daemon's JSON-RPC control protocol on this TCP address.
+  --log-format <fmt> Log output format: pretty, json, or logfmt [default: pretty]
+  --transport <name> How to deliver outbound payloads: http or tor [default: http]
+  --tor-proxy <addr> SOCKS5 proxy to dial for --transport=tor [default: ` + tor.DefaultProxy + `]
+  --host-key <path>  sshd host key, generated on first run if missing [default: ` + xdg.Get(xdg.EnvStateHome, "ratchet/sshd/host_key") + `]
+  --authorized-keys <path> sshd authorized_keys-style file binding pubkey fingerprints to me@domain identities [default: ` + xdg.Get(xdg.EnvConfigHome, "ratchet/sshd/authorized_keys") + `]
+  --roster <path>    Contact aliases for /chat, /close, /salty and /alias [default: ` + xdg.Get(xdg.EnvStateHome, "ratchet/roster.json") + `]
 `
 
 type opts struct {
-	Offer bool `docopt:"offer"`
-	Send  bool `docopt:"send"`
-	Recv  bool `docopt:"recv"`
-	Close bool `docopt:"close"`
-	Chat  bool `docopt:"chat"`
-	UI    bool `docopt:"ui"`
+	Offer    bool `docopt:"offer"`
+	Send     bool `docopt:"send"`
+	SendFile bool `docopt:"send-file"`
+	Recv     bool `docopt:"recv"`
+	Close    bool `docopt:"close"`
+	Chat     bool `docopt:"chat"`
+	UI       bool `docopt:"ui"`
+	Daemon   bool `docopt:"daemon"`
+	Sshd     bool `docopt:"sshd"`
 
 	Them string `docopt:"<them>"`
-
-	Key      string `docopt:"--key"`
-	Session  string `docopt:"--session"`
-	State    string `docopt:"--state"`
-	Log      string `docopt:"--log"`
-	Msg      string `docopt:"--msg"`
-	MsgFile  string `docopt:"--msg-file"`
-	MsgStdin bool   `docopt:"--msg-stdin"`
-	Post     bool   `docopt:"--post"`
+	Path string `docopt:"<path>"`
+	Addr string `docopt:"<addr>"`
+
+	Key       string `docopt:"--key"`
+	Session   string `docopt:"--session"`
+	State     string `docopt:"--state"`
+	Store     string `docopt:"--store"`
+	Log       string `docopt:"--log"`
+	Msg       string `docopt:"--msg"`
+	MsgFile   string `docopt:"--msg-file"`
+	MsgStdin  bool   `docopt:"--msg-stdin"`
+	Post      bool   `docopt:"--post"`
+	Socket    string `docopt:"--socket"`
+	TCP       string `docopt:"--tcp"`
+	LogFmt    string `docopt:"--log-format"`
+	Transport string `docopt:"--transport"`
+	TorProxy  string `docopt:"--tor-proxy"`
+
+	HostKey        string `docopt:"--host-key"`
+	AuthorizedKeys string `docopt:"--authorized-keys"`
+	Roster         string `docopt:"--roster"`
 }
 
 func main() {
+	bootLog := rlog.New(os.Stderr, rlog.Pretty, rlog.Info)
+
 	o, err := docopt.ParseDoc(usage)
 	if err != nil {
-		log(err)
+		bootLog.Error(err.Error())
 		os.Exit(2)
 	}
 
 	var opts opts
 	o.Bind(&opts)
 
+	format, err := rlog.ParseFormat(opts.LogFmt)
+	if err != nil {
+		bootLog.Error(err.Error())
+		os.Exit(2)
+	}
+	log := rlog.New(os.Stderr, format, rlog.Info)
+
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
 	go func() {
 		<-ctx.Done()
 		defer cancel() // restore interrupt function
 	}()
 
-	if err := run(ctx, opts); err != nil {
-		log(err)
+	if err := run(ctx, opts, log, format); err != nil {
+		log.Error(err.Error())
 		os.Exit(1)
 	}
 }
 
-func run(ctx context.Context, opts opts) error {
-	// log(opts)
+func run(ctx context.Context, opts opts, log *rlog.Logger, format rlog.Format) error {
+	transportOpt, err := transportOption(opts)
+	if err != nil {
+		return err
+	}
 
 	switch {
 	case opts.Offer:
-		return cli.Offer(ctx, opts.Key, opts.State, opts.Them)
+		return cli.Offer(ctx, opts.Key, opts.Store, opts.State, opts.Them, transportOpt)
 
 	case opts.Send:
 		input, err := readInput(opts)
@@ -107,17 +155,20 @@ func run(ctx context.Context, opts opts) error {
 			return err
 		}
 
-		return cli.Send(ctx, opts.Key, opts.State, opts.Them, input)
+		return cli.Send(ctx, opts.Key, opts.Store, opts.State, opts.Them, input, transportOpt)
+
+	case opts.SendFile:
+		return cli.SendFile(ctx, opts.Key, opts.Store, opts.State, opts.Them, opts.Path, transportOpt)
 
 	case opts.Recv:
 		input, err := readInput(opts)
 		if err != nil {
 			return err
 		}
-		return cli.Recv(ctx, opts.Key, opts.State, opts.Them, input)
+		return cli.Recv(ctx, opts.Key, opts.Store, opts.State, opts.Them, input, transportOpt)
 
 	case opts.Close:
-		return cli.Close(ctx, opts.Key, opts.State, opts.Them)
+		return cli.Close(ctx, opts.Key, opts.Store, opts.State, opts.Them, transportOpt)
 
 	case opts.Chat:
 		me, key, err := cli.ReadSaltyIdentity(opts.Key)
@@ -125,19 +176,24 @@ func run(ctx context.Context, opts opts) error {
 			return fmt.Errorf("reading keyfile: %w", err)
 		}
 
-		sm, close, err := session.NewSessionManager(opts.State, me, key)
+		sm, close, err := session.Open(opts.Store, opts.State, me, key)
 		if err != nil {
 			return err
 		}
 		defer close()
 
-		c, err := client.New(sm, me, driver_msgbus.WithMsgbus(sm.Position()))
+		c, err := client.New(sm, me, msgbus.WithMsgbus(sm.Position()), transportOpt)
 		if err != nil {
 			return err
 		}
 		c.BaseCTX = func() context.Context { return ctx }
 
-		return interactive.New(c).Run(ctx, me, opts.Them)
+		r, err := roster.Open(opts.Roster)
+		if err != nil {
+			return fmt.Errorf("opening roster: %w", err)
+		}
+
+		return interactive.New(c, format, interactive.WithRoster(r)).Run(ctx, me, opts.Them)
 
 	case opts.UI:
 		ctx, cancel := context.WithCancel(ctx)
@@ -148,13 +204,13 @@ func run(ctx context.Context, opts opts) error {
 			return fmt.Errorf("reading keyfile: %w", err)
 		}
 
-		sm, close, err := session.NewSessionManager(opts.State, me, key)
+		sm, close, err := session.Open(opts.Store, opts.State, me, key)
 		if err != nil {
 			return err
 		}
 		defer close()
 
-		c, err := client.New(sm, me, driver_msgbus.WithMsgbus(sm.Position()))
+		c, err := client.New(sm, me, msgbus.WithMsgbus(sm.Position()), transportOpt)
 		if err != nil {
 			return err
 		}
@@ -164,7 +220,12 @@ func run(ctx context.Context, opts opts) error {
 
 		wg.Go(func() error { return c.Run(ctx) })
 
-		m := ui.InitialModel(c, opts.Them)
+		r, err := roster.Open(opts.Roster)
+		if err != nil {
+			return fmt.Errorf("opening roster: %w", err)
+		}
+
+		m := ui.InitialModel(c, opts.Them, r)
 		p := tea.NewProgram(m, tea.WithAltScreen(), tea.WithMouseAllMotion())
 
 		wg.Go(func() error {
@@ -175,15 +236,98 @@ func run(ctx context.Context, opts opts) error {
 
 		return wg.Wait()
 
+	case opts.Daemon:
+		me, key, err := cli.ReadSaltyIdentity(opts.Key)
+		if err != nil {
+			return fmt.Errorf("reading keyfile: %w", err)
+		}
+
+		sm, closeSM, err := session.Open(opts.Store, opts.State, me, key)
+		if err != nil {
+			return err
+		}
+		defer closeSM()
+
+		c, err := client.New(sm, me, msgbus.WithMsgbus(sm.Position()), transportOpt)
+		if err != nil {
+			return err
+		}
+		c.BaseCTX = func() context.Context { return ctx }
+
+		d := daemon.New(c)
+
+		wg, ctx := errgroup.WithContext(ctx)
+		wg.Go(func() error { return c.Run(ctx) })
+
+		if err := os.RemoveAll(opts.Socket); err != nil {
+			return fmt.Errorf("removing stale socket: %w", err)
+		}
+		ul, err := net.Listen("unix", opts.Socket)
+		if err != nil {
+			return fmt.Errorf("listen %s: %w", opts.Socket, err)
+		}
+		wg.Go(func() error { return d.Serve(ctx, ul) })
+
+		if opts.TCP != "" {
+			tl, err := net.Listen("tcp", opts.TCP)
+			if err != nil {
+				return fmt.Errorf("listen %s: %w", opts.TCP, err)
+			}
+			wg.Go(func() error { return d.Serve(ctx, tl) })
+		}
+
+		return wg.Wait()
+
+	case opts.Sshd:
+		resolver, err := sshd.LoadIdentityResolver(opts.AuthorizedKeys, opts.State)
+		if err != nil {
+			return err
+		}
+
+		hostKey, err := sshd.LoadOrCreateHostKey(opts.HostKey)
+		if err != nil {
+			return err
+		}
+
+		srv := sshd.New(hostKey, resolver, opts.Store, opts.State, format, transportOpt)
+
+		l, err := net.Listen("tcp", opts.Addr)
+		if err != nil {
+			return fmt.Errorf("listen %s: %w", opts.Addr, err)
+		}
+
+		return srv.Serve(ctx, l)
+
 	default:
-		log(usage)
+		log.Info(usage)
 	}
 
 	return nil
 }
 
-func log(a ...any) {
-	fmt.Fprintf(os.Stderr, "\033[90m%s\033[0m\n", fmt.Sprint(a...))
+// noopOption is returned by transportOption for --transport=http, since the
+// plain HTTPS POST client.Transport is already client.New's default: there
+// is nothing to override.
+type noopOption struct{}
+
+func (noopOption) ApplyClient(*client.Client) {}
+
+// transportOption builds the client.Option that picks --transport's
+// delivery path. http (the default) changes nothing; tor dials
+// --tor-proxy's SOCKS5 proxy for every outbound payload instead.
+func transportOption(opts opts) (client.Option, error) {
+	switch opts.Transport {
+	case "", "http":
+		return noopOption{}, nil
+	case "tor":
+		t, err := tor.New(opts.TorProxy)
+		if err != nil {
+			return nil, err
+		}
+		return client.WithTransport(t), nil
+	default:
+		return nil, fmt.Errorf("unknown --transport %q: want http or tor", opts.Transport)
+	}
 }
 
 func readInput(opts opts) (msg string, err error) {
@@ -10,9 +10,11 @@ import (
 	"crypto/subtle"
 	"encoding"
 	"encoding/base64"
+	"encoding/binary"
 	"fmt"
 	"strings"
 
+	"go.salty.im/ratchet/obfs"
 	"golang.org/x/crypto/nacl/box"
 )
 
@@ -43,6 +45,18 @@ const (
 	// is used for concealing the offer so the nick is not exposed.
 	sessSealed
 
+	// sessOfferPQ is sessOffer's post-quantum hybrid counterpart: the same
+	// Alice-initiated handshake, plus a Kyber768 encapsulation key signed
+	// under idKey. A peer that doesn't speak sessOfferPQ never sees one;
+	// PQ support is negotiated by Alice choosing to send this type instead
+	// of sessOffer. See offerMessagePQ.
+	sessOfferPQ
+
+	// sessAckPQ is sessOfferPQ's sessAck: Bob's answer, carrying a Kyber768
+	// ciphertext encapsulated to Alice's offered key so both sides can mix
+	// its shared secret into the X3DH root key. See ackMessagePQ.
+	sessAckPQ
+
 	// Prefix indicates the beginning of an encoded message.
 	Prefix string = "!RAT!"
 
@@ -52,7 +66,18 @@ const (
 
 type Msg interface{ interface{ ID() []byte } }
 
-func Parse(in string) (Msg, error) {
+// Parse decodes an encoded message. If obfuscators are given, each is tried
+// in order to Unwrap in before falling back to the current prefix/suffix
+// framing, so a Client configured with an obfs.Obfuscator can still parse
+// messages it receives in the clear.
+func Parse(in string, obfuscators ...obfs.Obfuscator) (Msg, error) {
+	for _, o := range obfuscators {
+		if out, err := o.Unwrap(in); err == nil {
+			in = out
+			break
+		}
+	}
+
 	_, m, err := unmarshalMessage(in)
 	return m, err
 }
@@ -118,6 +143,10 @@ func container(t messageType) (m Msg, err error) {
 		m = new(closeMessage)
 	case sessSealed:
 		m = new(sealedMessage)
+	case sessOfferPQ:
+		m = new(offerMessagePQ)
+	case sessAckPQ:
+		m = new(ackMessagePQ)
 	default:
 		err = fmt.Errorf("unsupported message type %d", t)
 	}
@@ -235,6 +264,157 @@ func (msg *ackMessage) Equal(k ed25519.PublicKey) bool {
 	return bytes.Equal(msg.idKey, k)
 }
 
+// offerMessagePQ is sessOfferPQ's payload: offerMessage's fields plus a
+// Kyber768 encapsulation key, signed under idKey, for the PQXDH-style hybrid
+// handshake described in mixKEM.
+//
+// Wire layout (idKey/spKey/spSig/uuid keep offerMessage's fixed 144 byte
+// prefix so a non-PQ peer's UnmarshalBinary can still parse it, just with a
+// garbled nick):
+//
+//	idKey(32) || spKey(32) || spSig(64) || uuid(16) ||
+//	kemLen(2, big endian) || kemKey(kemLen-64) || kemSig(64) || nick
+//
+// kemKey||kemSig is length-prefixed, rather than relying on kemPublicKeySize
+// being fixed, so a future switch to a different KEM size doesn't also
+// require a new messageType.
+type offerMessagePQ struct {
+	idKey  []byte
+	spKey  []byte
+	spSig  []byte
+	uuid   []byte
+	kemKey []byte
+	kemSig []byte
+	nick   []byte
+}
+
+func (msg offerMessagePQ) MarshalBinary() (data []byte, err error) {
+	kemLen := len(msg.kemKey) + len(msg.kemSig)
+
+	data = make([]byte, 32+32+64+16+2+kemLen+len(msg.nick))
+
+	copy(data[:32], msg.idKey)
+	copy(data[32:64], msg.spKey)
+	copy(data[64:128], msg.spSig)
+	copy(data[128:144], msg.uuid)
+	binary.BigEndian.PutUint16(data[144:146], uint16(kemLen))
+	copy(data[146:146+len(msg.kemKey)], msg.kemKey)
+	copy(data[146+len(msg.kemKey):146+kemLen], msg.kemSig)
+	copy(data[146+kemLen:], msg.nick)
+
+	return
+}
+
+func (msg *offerMessagePQ) UnmarshalBinary(data []byte) (err error) {
+	if len(data) < 32+32+64+16+2 {
+		return fmt.Errorf("sessOfferPQ payload MUST be greater than 146 byte")
+	}
+
+	kemLen := int(binary.BigEndian.Uint16(data[144:146]))
+	if kemLen < ed25519.SignatureSize || len(data) < 146+kemLen {
+		return fmt.Errorf("sessOfferPQ payload has an invalid KEM key length")
+	}
+
+	msg.idKey = make([]byte, 32)
+	msg.spKey = make([]byte, 32)
+	msg.spSig = make([]byte, 64)
+	msg.uuid = make([]byte, 16)
+	msg.kemKey = make([]byte, kemLen-ed25519.SignatureSize)
+	msg.kemSig = make([]byte, ed25519.SignatureSize)
+	msg.nick = make([]byte, len(data)-146-kemLen)
+
+	copy(msg.idKey, data[:32])
+	copy(msg.spKey, data[32:64])
+	copy(msg.spSig, data[64:128])
+	copy(msg.uuid, data[128:144])
+	copy(msg.kemKey, data[146:146+len(msg.kemKey)])
+	copy(msg.kemSig, data[146+len(msg.kemKey):146+kemLen])
+	copy(msg.nick, data[146+kemLen:])
+
+	return
+}
+
+func (msg *offerMessagePQ) Nick() string {
+	return string(msg.nick)
+}
+
+func (msg *offerMessagePQ) ID() []byte {
+	return msg.uuid
+}
+
+func (msg *offerMessagePQ) Key() ed25519.PublicKey {
+	return msg.idKey
+}
+
+func (msg *offerMessagePQ) Equal(k ed25519.PublicKey) bool {
+	return bytes.Equal(msg.idKey, k)
+}
+
+// ackMessagePQ is sessAckPQ's payload: ackMessage's fields plus the Kyber768
+// ciphertext Bob encapsulated to Alice's offered kemKey.
+//
+// Wire layout:
+//
+//	idKey(32) || eKey(32) || uuid(16) ||
+//	kemCipherLen(2, big endian) || kemCipher(kemCipherLen) || cipher
+type ackMessagePQ struct {
+	idKey     []byte
+	eKey      []byte
+	uuid      []byte
+	kemCipher []byte
+	cipher    []byte
+}
+
+func (msg ackMessagePQ) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 32+32+16+2+len(msg.kemCipher)+len(msg.cipher))
+
+	copy(data[:32], msg.idKey)
+	copy(data[32:64], msg.eKey)
+	copy(data[64:80], msg.uuid)
+	binary.BigEndian.PutUint16(data[80:82], uint16(len(msg.kemCipher)))
+	copy(data[82:82+len(msg.kemCipher)], msg.kemCipher)
+	copy(data[82+len(msg.kemCipher):], msg.cipher)
+
+	return
+}
+
+func (msg *ackMessagePQ) UnmarshalBinary(data []byte) (err error) {
+	if len(data) < 32+32+16+2 {
+		return fmt.Errorf("sessAckPQ payload MUST be greater than 82 byte")
+	}
+
+	kemCipherLen := int(binary.BigEndian.Uint16(data[80:82]))
+	if len(data) <= 82+kemCipherLen {
+		return fmt.Errorf("sessAckPQ payload MUST carry a cipher after the KEM ciphertext")
+	}
+
+	msg.idKey = make([]byte, 32)
+	msg.eKey = make([]byte, 32)
+	msg.uuid = make([]byte, 16)
+	msg.kemCipher = make([]byte, kemCipherLen)
+	msg.cipher = make([]byte, len(data)-82-kemCipherLen)
+
+	copy(msg.idKey, data[:32])
+	copy(msg.eKey, data[32:64])
+	copy(msg.uuid, data[64:80])
+	copy(msg.kemCipher, data[82:82+kemCipherLen])
+	copy(msg.cipher, data[82+kemCipherLen:])
+
+	return
+}
+
+func (msg *ackMessagePQ) ID() []byte {
+	return msg.uuid
+}
+
+func (msg *ackMessagePQ) Key() ed25519.PublicKey {
+	return msg.idKey
+}
+
+func (msg *ackMessagePQ) Equal(k ed25519.PublicKey) bool {
+	return bytes.Equal(msg.idKey, k)
+}
+
 // dataMessage is the sessData message for the bidirectional exchange of
 // encrypted ciphertext. Thus, its length is dynamic.
 type dataMessage struct {
@@ -325,6 +505,10 @@ func Seal(m encoding.BinaryMarshaler, k []byte) (out sealedMessage, err error) {
 		data = append([]byte{'3'}, data...)
 	case *closeMessage:
 		data = append([]byte{'4'}, data...)
+	case *offerMessagePQ:
+		data = append([]byte{'6'}, data...)
+	case *ackMessagePQ:
+		data = append([]byte{'7'}, data...)
 	default:
 		err = fmt.Errorf("unsupported message type %T", m)
 		return
@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+//
+// SPDX-License-Identifier: BSD-3-Clause
+
+package xochimilco
+
+import (
+	"bytes"
+	"encoding"
+	"reflect"
+	"testing"
+)
+
+// TestMessagePQMarshal is the sessOfferPQ/sessAckPQ counterpart to
+// TestMessageMarshall: known-answer fixed-size fields round-tripped through
+// marshalMessage/unmarshalMessage.
+func TestMessagePQMarshal(t *testing.T) {
+	kemKey := bytes.Repeat([]byte{0x0a}, kemPublicKeySize)
+	kemSig := bytes.Repeat([]byte{0x0b}, 64)
+	kemCipher := bytes.Repeat([]byte{0x0c}, kemCiphertextSize)
+
+	testcases := []struct {
+		t messageType
+		m encoding.BinaryMarshaler
+	}{
+		{
+			t: sessOfferPQ,
+			m: &offerMessagePQ{
+				idKey:  bytes.Repeat([]byte{1}, 32),
+				spKey:  bytes.Repeat([]byte{2}, 32),
+				spSig:  bytes.Repeat([]byte{3}, 64),
+				uuid:   bytes.Repeat([]byte{0}, 16),
+				kemKey: kemKey,
+				kemSig: kemSig,
+				nick:   []byte("alice@sour.is"),
+			},
+		},
+		{
+			t: sessAckPQ,
+			m: &ackMessagePQ{
+				idKey:     bytes.Repeat([]byte{1}, 32),
+				eKey:      bytes.Repeat([]byte{2}, 32),
+				uuid:      bytes.Repeat([]byte{0}, 16),
+				kemCipher: kemCipher,
+				cipher:    []byte{1, 2, 3, 4, 5, 6, 7},
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		txt, err := marshalMessage(testcase.t, testcase.m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ty, m, err := unmarshalMessage(txt)
+		if err != nil {
+			t.Fatal(err)
+		} else if ty != testcase.t {
+			t.Errorf("unexpected type, %d %d", ty, testcase.t)
+		} else if !reflect.DeepEqual(m, testcase.m) {
+			t.Errorf("messages differ, %#v %#v", m, testcase.m)
+		}
+	}
+}
+
+// TestOfferMessagePQUnmarshalInvalid checks that a non-PQ peer's fixed-size
+// prefix (idKey/spKey/spSig/uuid) still parses even though offerMessagePQ
+// carries the extra length-prefixed KEM key afterwards.
+func TestOfferMessagePQUnmarshalInvalid(t *testing.T) {
+	short := make([]byte, 32+32+64+16+1)
+	pq := new(offerMessagePQ)
+	if err := pq.UnmarshalBinary(short); err == nil {
+		t.Fatal("expected an error for a payload without a KEM length")
+	}
+
+	classical := new(offerMessage)
+	full := &offerMessagePQ{
+		idKey:  bytes.Repeat([]byte{1}, 32),
+		spKey:  bytes.Repeat([]byte{2}, 32),
+		spSig:  bytes.Repeat([]byte{3}, 64),
+		uuid:   bytes.Repeat([]byte{0}, 16),
+		kemKey: bytes.Repeat([]byte{4}, kemPublicKeySize),
+		kemSig: bytes.Repeat([]byte{5}, 64),
+		nick:   []byte("alice@sour.is"),
+	}
+	data, err := full.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := classical.UnmarshalBinary(data); err != nil {
+		t.Fatalf("a non-PQ peer should still parse the fixed-size prefix: %v", err)
+	}
+	if !bytes.Equal(classical.idKey, full.idKey) || !bytes.Equal(classical.spKey, full.spKey) || !bytes.Equal(classical.spSig, full.spSig) {
+		t.Fatal("non-PQ peer parsed the fixed-size prefix incorrectly")
+	}
+}
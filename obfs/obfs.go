@@ -0,0 +1,23 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package obfs disguises ratchet's wire framing so an on-path observer
+// cannot fingerprint a conversation by its fixed `!RAT!...!CHT!` prefix,
+// its `BEGIN SALTPACK ENCRYPTED MESSAGE.` preamble, or its consistent
+// ciphertext sizes. An Obfuscator only rewrites the outer envelope; the
+// enclosed ratchet/saltpack payload is unchanged and must already be
+// encrypted before it reaches Wrap.
+package obfs
+
+// Obfuscator disguises an outbound payload before it is handed to a
+// client.Transport, and restores it on receipt. Unwrap MUST return an
+// error for input it did not produce so a Client can try several
+// Obfuscators in turn and fall back to the un-obfuscated wire format.
+type Obfuscator interface {
+	// Wrap disguises out for transit.
+	Wrap(out string) string
+
+	// Unwrap restores the payload that Wrap disguised as in, or
+	// returns an error if in was not produced by this Obfuscator.
+	Unwrap(in string) (string, error)
+}
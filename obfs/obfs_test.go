@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+//
+// SPDX-License-Identifier: BSD-3-Clause
+package obfs_test
+
+import (
+	"testing"
+
+	"github.com/matryer/is"
+	"go.salty.im/ratchet/obfs"
+)
+
+func TestScrambleSuitRoundTrip(t *testing.T) {
+	is := is.New(t)
+
+	o := obfs.NewScrambleSuit([]byte("shared-secret"), 0, 64)
+
+	wrapped := o.Wrap("!RAT!0hello!CHT!")
+	is.True(wrapped != "!RAT!0hello!CHT!")
+
+	out, err := o.Unwrap(wrapped)
+	is.NoErr(err)
+	is.Equal(out, "!RAT!0hello!CHT!")
+}
+
+func TestScrambleSuitRejectsForeignInput(t *testing.T) {
+	is := is.New(t)
+
+	o := obfs.NewScrambleSuit([]byte("shared-secret"), 0, 64)
+
+	_, err := o.Unwrap("!RAT!0hello!CHT!")
+	is.True(err != nil)
+
+	other := obfs.NewScrambleSuit([]byte("different-secret"), 0, 64)
+	_, err = other.Unwrap(o.Wrap("hello"))
+	is.True(err != nil)
+}
+
+func TestSaltPackFTERoundTrip(t *testing.T) {
+	is := is.New(t)
+
+	o := obfs.NewSaltPackFTE()
+
+	wrapped := o.Wrap("!RAT!0hello!CHT!")
+	is.True(wrapped != "!RAT!0hello!CHT!")
+
+	out, err := o.Unwrap(wrapped)
+	is.NoErr(err)
+	is.Equal(out, "!RAT!0hello!CHT!")
+}
+
+func TestSaltPackFTERejectsForeignInput(t *testing.T) {
+	is := is.New(t)
+
+	o := obfs.NewSaltPackFTE()
+
+	_, err := o.Unwrap("not a saltpack message")
+	is.True(err != nil)
+}
@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package obfs
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// SaltPackFTE is a format-transforming Obfuscator that re-encodes a ratchet
+// frame so it is indistinguishable, at a glance and to line-based DPI, from
+// an ordinary SaltPack ASCII-armored message: a `BEGIN SALTPACK ENCRYPTED
+// MESSAGE.` / `END SALTPACK ENCRYPTED MESSAGE.` envelope wrapping
+// space-separated words of armoredWordLen characters.
+type SaltPackFTE struct{}
+
+// NewSaltPackFTE returns a ready-to-use SaltPackFTE.
+func NewSaltPackFTE() SaltPackFTE {
+	return SaltPackFTE{}
+}
+
+const (
+	saltPackFTEBegin = "BEGIN SALTPACK ENCRYPTED MESSAGE. "
+	saltPackFTEEnd   = " END SALTPACK ENCRYPTED MESSAGE."
+
+	// armoredWordLen mirrors SaltPack's armor, which breaks ciphertext into
+	// fixed-width words rather than one unbroken run of base64.
+	armoredWordLen = 15
+)
+
+func (SaltPackFTE) Wrap(out string) string {
+	body := base64.RawURLEncoding.EncodeToString([]byte(out))
+
+	words := make([]string, 0, len(body)/armoredWordLen+1)
+	for len(body) > armoredWordLen {
+		words = append(words, body[:armoredWordLen])
+		body = body[armoredWordLen:]
+	}
+	words = append(words, body)
+
+	return saltPackFTEBegin + strings.Join(words, " ") + saltPackFTEEnd
+}
+
+func (SaltPackFTE) Unwrap(in string) (string, error) {
+	if !strings.HasPrefix(in, saltPackFTEBegin) || !strings.HasSuffix(in, saltPackFTEEnd) {
+		return "", fmt.Errorf("obfs: saltpackfte: not a wrapped message")
+	}
+
+	body := in[len(saltPackFTEBegin) : len(in)-len(saltPackFTEEnd)]
+	body = strings.ReplaceAll(body, " ", "")
+
+	data, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return "", fmt.Errorf("obfs: saltpackfte: %w", err)
+	}
+
+	return string(data), nil
+}
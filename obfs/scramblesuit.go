@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package obfs
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+)
+
+// ScrambleSuit is a scramblesuit-style probabilistic padding Obfuscator. It
+// pads every message to a random length drawn from [MinPad, MaxPad) and
+// authenticates the result with a keyed HMAC, so neither the fixed ratchet
+// prefix/suffix nor a consistent ciphertext size survives on the wire.
+//
+// Wire format (base64-url, no padding):
+//
+//	uint16(padLen) || pad[padLen] || payload || hmac-sha256(key, padLen||pad||payload)[:tagSize]
+type ScrambleSuit struct {
+	Key            []byte
+	MinPad, MaxPad int
+}
+
+// tagSize is the truncated HMAC length appended to every message.
+const tagSize = 16
+
+// NewScrambleSuit returns a ScrambleSuit keyed by key, padding each message
+// with a random length in [minPad, maxPad).
+func NewScrambleSuit(key []byte, minPad, maxPad int) *ScrambleSuit {
+	return &ScrambleSuit{Key: key, MinPad: minPad, MaxPad: maxPad}
+}
+
+func (s *ScrambleSuit) Wrap(out string) string {
+	padLen := s.MinPad
+	if s.MaxPad > s.MinPad {
+		var n [2]byte
+		_, _ = rand.Read(n[:])
+		padLen += int(binary.BigEndian.Uint16(n[:])) % (s.MaxPad - s.MinPad)
+	}
+
+	pad := make([]byte, padLen)
+	_, _ = rand.Read(pad)
+
+	body := make([]byte, 2+padLen+len(out))
+	binary.BigEndian.PutUint16(body[:2], uint16(padLen))
+	copy(body[2:2+padLen], pad)
+	copy(body[2+padLen:], out)
+
+	mac := hmac.New(sha256.New, s.Key)
+	mac.Write(body)
+	tag := mac.Sum(nil)[:tagSize]
+
+	return base64.RawURLEncoding.EncodeToString(append(body, tag...))
+}
+
+func (s *ScrambleSuit) Unwrap(in string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(in)
+	if err != nil {
+		return "", fmt.Errorf("obfs: scramblesuit: %w", err)
+	}
+	if len(data) < 2+tagSize {
+		return "", fmt.Errorf("obfs: scramblesuit: message too short")
+	}
+
+	body, gotTag := data[:len(data)-tagSize], data[len(data)-tagSize:]
+
+	mac := hmac.New(sha256.New, s.Key)
+	mac.Write(body)
+	wantTag := mac.Sum(nil)[:tagSize]
+	if !hmac.Equal(gotTag, wantTag) {
+		return "", fmt.Errorf("obfs: scramblesuit: invalid tag")
+	}
+
+	padLen := int(binary.BigEndian.Uint16(body[:2]))
+	if len(body) < 2+padLen {
+		return "", fmt.Errorf("obfs: scramblesuit: invalid pad length")
+	}
+
+	return string(body[2+padLen:]), nil
+}
@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package roster resolves short, user-chosen names to full salty addresses
+// (me@domain) and offers them back as tab-completion candidates, so a
+// ratchet user doesn't have to retype a peer's fully qualified address on
+// every /chat, /close or /salty.
+package roster
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Roster holds a set of user-defined aliases, persisted as JSON at path.
+type Roster struct {
+	path string
+
+	mu      sync.Mutex
+	Aliases map[string]string
+}
+
+// Open loads the roster stored at path, starting empty if it doesn't exist
+// yet.
+func Open(path string) (*Roster, error) {
+	r := &Roster{path: path, Aliases: map[string]string{}}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &r.Aliases); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Alias binds name to addr, persisting the roster to disk.
+func (r *Roster) Alias(name, addr string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Aliases[name] = addr
+	return r.save()
+}
+
+// save writes the roster as write-temp+rename, the same pattern
+// DiskSessionManager.Rekey uses for session files.
+func (r *Roster) save() error {
+	b, err := json.Marshal(r.Aliases)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0700); err != nil {
+		return err
+	}
+
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// Resolve returns the address name is bound to, or name itself if it isn't
+// a known alias -- so a fully qualified address always works even if it
+// was never aliased.
+func (r *Roster) Resolve(name string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if addr, ok := r.Aliases[name]; ok {
+		return addr, nil
+	}
+	return name, nil
+}
+
+// Complete returns every alias with prefix, sorted, for use as readline tab
+// completion after /chat, /close and /salty.
+func (r *Roster) Complete(prefix string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var names []string
+	for name := range r.Aliases {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
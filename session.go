@@ -12,8 +12,8 @@ import (
 	"fmt"
 
 	"github.com/oklog/ulid"
-	"github.com/sour-is/xochimilco/doubleratchet"
 	"github.com/sour-is/xochimilco/x3dh"
+	"go.salty.im/ratchet/doubleratchet"
 )
 
 // Session between two parties to exchange encrypted messages.
@@ -56,6 +56,10 @@ type Session struct {
 	// spkPub / spkPriv is the X3DH signed prekey for our opening party.
 	spkPub, spkPriv []byte
 
+	// kemPriv is the one-time Kyber768 decapsulation key for an in-flight
+	// PQ handshake started by OfferPQ; see mixKEM.
+	kemPriv []byte
+
 	// doubleRatchet is the internal Double Ratchet.
 	doubleRatchet *doubleratchet.DoubleRatchet
 }
@@ -76,6 +80,7 @@ func (sess *Session) MarshalBinary() ([]byte, error) {
 		Me           string
 		SpkPub       []byte
 		SpkPriv      []byte
+		KemPriv      []byte
 		DoubleRachet []byte
 	}{
 		sess.LocalUUID,
@@ -83,6 +88,7 @@ func (sess *Session) MarshalBinary() ([]byte, error) {
 		sess.Me,
 		sess.spkPub,
 		sess.spkPriv,
+		sess.kemPriv,
 		dr,
 	}
 	var buf bytes.Buffer
@@ -96,6 +102,7 @@ func (sess *Session) UnmarshalBinary(b []byte) error {
 		Me           string
 		SpkPub       []byte
 		SpkPriv      []byte
+		KemPriv      []byte
 		DoubleRachet []byte
 	}
 	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&o)
@@ -108,6 +115,7 @@ func (sess *Session) UnmarshalBinary(b []byte) error {
 	sess.RemoteUUID = o.RemoteUUID
 	sess.spkPub = o.SpkPub
 	sess.spkPriv = o.SpkPriv
+	sess.kemPriv = o.KemPriv
 	if len(o.DoubleRachet) > 0 {
 		sess.doubleRatchet = &doubleratchet.DoubleRatchet{}
 		err = sess.doubleRatchet.UnmarshalBinary(o.DoubleRachet)
@@ -120,6 +128,17 @@ func (sess *Session) Active() bool {
 	return sess.doubleRatchet != nil
 }
 
+// RootKey returns the Double Ratchet's current root key, or nil if the
+// session has not been established yet. Both parties derive the same root
+// key, so callers may mix it into material that must agree across devices,
+// such as session.Export's migration envelope ID.
+func (sess *Session) RootKey() []byte {
+	if sess.doubleRatchet == nil {
+		return nil
+	}
+	return sess.doubleRatchet.RootKey()
+}
+
 // Offer to establish an encrypted Session.
 //
 // This method MUST be called initially by the active resp. opening party
@@ -168,6 +187,65 @@ func (sess *Session) createOffer() (offer *offerMessage, err error) {
 	return
 }
 
+// OfferPQ to establish an encrypted Session with a post-quantum hardened
+// handshake.
+//
+// This is OfferPQ's alternative to Offer: it negotiates PQ support by
+// choosing to send a sessOfferPQ message instead of a sessOffer one, carrying
+// a one-time Kyber768 key alongside the usual X3DH parameters. A peer that
+// doesn't speak sessOfferPQ will never see one, so this MUST only be sent to
+// a peer already known to support it.
+func (sess *Session) OfferPQ() (offerMsg string, err error) {
+	offer, err := sess.createOfferPQ()
+	if err != nil {
+		return
+	}
+
+	offerMsg, err = marshalMessage(sessOfferPQ, offer)
+	return
+}
+
+func (sess *Session) OfferSealedPQ(k []byte) (offerMsg string, err error) {
+	offer, err := sess.createOfferPQ()
+	if err != nil {
+		return
+	}
+
+	sealed, err := Seal(offer, k)
+	if err != nil {
+		return
+	}
+
+	offerMsg, err = marshalMessage(sessSealed, sealed)
+	return
+}
+
+func (sess *Session) createOfferPQ() (offer *offerMessagePQ, err error) {
+	spkPub, spkPriv, spkSig, err := x3dh.CreateNewSpk(sess.IdentityKey)
+	if err != nil {
+		return
+	}
+
+	kemPub, kemPriv, err := kemGenerateKey()
+	if err != nil {
+		return
+	}
+
+	sess.spkPub, sess.spkPriv = spkPub, spkPriv
+	sess.kemPriv = kemPriv
+
+	offer = &offerMessagePQ{
+		uuid:   sess.LocalUUID,
+		nick:   []byte(sess.Me),
+		idKey:  sess.IdentityKey.Public().(ed25519.PublicKey),
+		spKey:  spkPub,
+		spSig:  spkSig,
+		kemKey: kemPub,
+		kemSig: ed25519.Sign(sess.IdentityKey, kemPub),
+	}
+	return
+}
+
 // Acknowledge to establish an encrypted Session.
 //
 // This method MUST be called by the passive party (Bob) with the active party's
@@ -188,6 +266,25 @@ func (sess *Session) Acknowledge(offerMsg string) (ackMsg string, err error) {
 	return
 }
 
+// AcknowledgePQ is Acknowledge's counterpart for a sessOfferPQ message.
+//
+// This method MUST be called by the passive party (Bob) with the active
+// party's (Alice's) sessOfferPQ message. The resulting sessAckPQ message MUST
+// be sent back.
+func (sess *Session) AcknowledgePQ(offerMsg string) (ackMsg string, err error) {
+	msgType, offerIf, err := unmarshalMessage(offerMsg)
+	if err != nil {
+		return
+	} else if msgType != sessOfferPQ {
+		err = fmt.Errorf("unexpected message type %d", msgType)
+		return
+	}
+	offer := offerIf.(*offerMessagePQ)
+	_, ackMsg, err = sess.receiveOfferPQ(offer)
+
+	return
+}
+
 func (sess *Session) receiveOffer(offer *offerMessage) (isEstablished bool, ackMsg string, err error) {
 	if !sess.VerifyPeer(offer.idKey) {
 		err = fmt.Errorf("verification function refuses public key")
@@ -230,6 +327,67 @@ func (sess *Session) receiveOffer(offer *offerMessage) (isEstablished bool, ackM
 	return
 }
 
+// receiveOfferPQ is receiveOffer's counterpart for a sessOfferPQ message. In
+// addition to the classical X3DH steps, it verifies the Kyber768 key's
+// signature and encapsulates a shared secret to it, then mixes that secret
+// into the X3DH session key via mixKEM before setting up the Double Ratchet.
+func (sess *Session) receiveOfferPQ(offer *offerMessagePQ) (isEstablished bool, ackMsg string, err error) {
+	if !sess.VerifyPeer(offer.idKey) {
+		err = fmt.Errorf("verification function refuses public key")
+		return
+	}
+
+	if !ed25519.Verify(offer.idKey, offer.kemKey, offer.kemSig) {
+		err = fmt.Errorf("invalid KEM key signature")
+		return
+	}
+
+	sessKey, associatedData, ekPub, err := x3dh.CreateInitialMessage(
+		sess.IdentityKey, offer.idKey, offer.spKey, offer.spSig)
+	if err != nil {
+		return
+	}
+
+	kemCipher, kemSS, err := kemEncapsulate(offer.kemKey)
+	if err != nil {
+		return
+	}
+	sessKey, err = mixKEM(sessKey, kemSS)
+	if err != nil {
+		return
+	}
+
+	sess.RemoteUUID = offer.uuid
+	sess.doubleRatchet, err = doubleratchet.CreateActive(sessKey, associatedData, offer.spKey)
+	if err != nil {
+		return
+	}
+
+	// This will be padded up to 32 bytes for AES-256.
+	initialPayload := make([]byte, 23)
+	copy(initialPayload[:16], sess.LocalUUID)
+	if _, err = rand.Read(initialPayload[16:]); err != nil {
+		return
+	}
+	initialCiphertext, err := sess.doubleRatchet.Encrypt(initialPayload)
+	if err != nil {
+		return
+	}
+
+	isEstablished = true
+	ack := ackMessagePQ{
+		idKey:     sess.IdentityKey.Public().(ed25519.PublicKey),
+		eKey:      ekPub,
+		cipher:    initialCiphertext,
+		uuid:      sess.RemoteUUID,
+		kemCipher: kemCipher,
+	}
+	sess.IdentityKey = nil
+	ackMsg, err = marshalMessage(sessAckPQ, ack)
+
+	return
+}
+
 // receiveAck deals with incoming sessAck messages.
 //
 // The active / opening party receives the other party's acknowledgement and
@@ -268,6 +426,54 @@ func (sess *Session) receiveAck(ack *ackMessage) (isEstablished bool, err error)
 	return
 }
 
+// receiveAckPQ is receiveAck's counterpart for a sessAckPQ message: it
+// decapsulates Bob's Kyber768 ciphertext with the decapsulation key held
+// since OfferPQ and folds the resulting shared secret into the X3DH session
+// key via mixKEM, the same way receiveOfferPQ did on Bob's side.
+func (sess *Session) receiveAckPQ(ack *ackMessagePQ) (isEstablished bool, err error) {
+	if sess.doubleRatchet != nil {
+		err = fmt.Errorf("received sessAckPQ while being in an active session")
+		return
+	}
+
+	if !sess.VerifyPeer(ack.idKey) {
+		err = fmt.Errorf("verification function refuses public key")
+		return
+	}
+
+	sessKey, associatedData, err := x3dh.ReceiveInitialMessage(
+		sess.IdentityKey, ack.idKey, sess.spkPriv, ack.eKey)
+	if err != nil {
+		return
+	}
+
+	kemSS, err := kemDecapsulate(sess.kemPriv, ack.kemCipher)
+	if err != nil {
+		return
+	}
+	sessKey, err = mixKEM(sessKey, kemSS)
+	if err != nil {
+		return
+	}
+
+	sess.doubleRatchet, err = doubleratchet.CreatePassive(
+		sessKey, associatedData, sess.spkPub, sess.spkPriv)
+	if err != nil {
+		return
+	}
+	sess.spkPub, sess.spkPriv = nil, nil
+	sess.kemPriv = nil
+	plaintext, err := sess.doubleRatchet.Decrypt(ack.cipher)
+	if err != nil {
+		return
+	}
+
+	sess.RemoteUUID = plaintext[:16]
+	sess.IdentityKey = nil
+	isEstablished = true
+	return
+}
+
 // receiveData deals with incoming sessData messages.
 func (sess *Session) receiveData(data *dataMessage) (plaintext []byte, err error) {
 	if sess.doubleRatchet == nil {
@@ -307,6 +513,14 @@ func (sess *Session) ReceiveMsg(msg Msg) (isEstablished, isClosed bool, plaintex
 	case *ackMessage:
 		isEstablished, err = sess.receiveAck(msg)
 
+	case *offerMessagePQ:
+		var txt string
+		isEstablished, txt, err = sess.receiveOfferPQ(msg)
+		plaintext = []byte(txt)
+
+	case *ackMessagePQ:
+		isEstablished, err = sess.receiveAckPQ(msg)
+
 	case *dataMessage:
 		plaintext, err = sess.receiveData(msg)
 
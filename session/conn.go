@@ -0,0 +1,163 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package session
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"go.salty.im/ratchet/xochimilco"
+)
+
+// defaultMaxFrameSize bounds a Conn frame's marshalled-message length so a
+// peer can't force the reader loop to allocate an unbounded buffer off a
+// single 4-byte length header. See WithMaxFrameSize to raise or lower it.
+const defaultMaxFrameSize = 1 << 20 // 1 MiB
+
+// Conn wraps an already-established Session over rw with net.Conn-like
+// Read/Write, framing each marshalled xochimilco message (Session.Send's
+// result, or an offer/ack/close string fed in from rw) as a 4-byte
+// big-endian length header followed by its bytes — the same shape RLPx
+// gives an Ethereum devp2p message. This spares a caller running a Session
+// over a raw stream (a TCP dial, a Unix socket, a WebSocket) from inventing
+// its own framing on top of Send/Receive's bare strings, the way
+// session.Open's disk/redis/sql backends spare it from inventing its own
+// storage format.
+//
+// Read's loop folds offer/ack renegotiation frames back into the ratchet
+// without handing their (empty) plaintext to the caller, and turns an
+// incoming closeMessage frame into io.EOF, so none of Session's control
+// traffic ever surfaces as payload bytes. Close mirrors this on the way
+// out: it sends a closeMessage frame before the underlying rw is closed
+// (if it's an io.Closer), so the peer's Read observes a clean EOF rather
+// than a connection that just stopped.
+type Conn struct {
+	rw   io.ReadWriter
+	sess *Session
+
+	maxFrameSize uint32
+	pending      bytes.Buffer
+}
+
+// ConnOption configures a Conn at construction time. See WithMaxFrameSize.
+type ConnOption interface {
+	ApplyConn(*Conn)
+}
+
+type withMaxFrameSize uint32
+
+// WithMaxFrameSize rejects any frame whose length header exceeds n, in
+// place of defaultMaxFrameSize.
+func WithMaxFrameSize(n uint32) withMaxFrameSize {
+	return withMaxFrameSize(n)
+}
+
+func (n withMaxFrameSize) ApplyConn(c *Conn) {
+	c.maxFrameSize = uint32(n)
+}
+
+// NewConn wraps sess, whose handshake (Offer/Acknowledge) must already be
+// under way or complete, for framed use over rw.
+func NewConn(rw io.ReadWriter, sess *Session, opts ...ConnOption) *Conn {
+	c := &Conn{rw: rw, sess: sess, maxFrameSize: defaultMaxFrameSize}
+	for _, o := range opts {
+		o.ApplyConn(c)
+	}
+	return c
+}
+
+// Read implements io.Reader, returning the plaintext of the next data
+// frame. Control frames (a handshake ack, the peer's Close) are consumed
+// internally: an ack produces no plaintext and the loop reads on; a close
+// frame is returned as io.EOF.
+func (c *Conn) Read(p []byte) (int, error) {
+	for c.pending.Len() == 0 {
+		frame, err := c.readFrame()
+		if err != nil {
+			return 0, err
+		}
+
+		xmsg, err := xochimilco.Parse(string(frame))
+		if err != nil {
+			return 0, fmt.Errorf("conn: parse frame: %w", err)
+		}
+
+		isEstablished, isClosed, plaintext, err := c.sess.ReceiveMsg(xmsg)
+		if err != nil {
+			return 0, fmt.Errorf("conn: receive: %w", err)
+		}
+		if isClosed {
+			return 0, io.EOF
+		}
+		if isEstablished {
+			continue // offer/ack frame: its plaintext is handshake output, not payload
+		}
+		c.pending.Write(plaintext)
+	}
+
+	return c.pending.Read(p)
+}
+
+// Write encrypts p as a single data frame and sends it to rw. Unlike
+// net.Conn, a short write never happens: Write either frames and sends the
+// whole of p, or returns an error having sent none of it.
+func (c *Conn) Write(p []byte) (int, error) {
+	msg, err := c.sess.Send(p)
+	if err != nil {
+		return 0, fmt.Errorf("conn: send: %w", err)
+	}
+	if err := c.writeFrame([]byte(msg)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close sends a closeMessage frame, then closes rw if it implements
+// io.Closer.
+func (c *Conn) Close() error {
+	msg, err := c.sess.Close()
+	if err != nil {
+		return fmt.Errorf("conn: close: %w", err)
+	}
+	if err := c.writeFrame([]byte(msg)); err != nil {
+		return err
+	}
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (c *Conn) readFrame() ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(c.rw, hdr[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > c.maxFrameSize {
+		return nil, fmt.Errorf("conn: frame of %d bytes exceeds max %d", n, c.maxFrameSize)
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c.rw, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (c *Conn) writeFrame(b []byte) error {
+	if uint32(len(b)) > c.maxFrameSize {
+		return fmt.Errorf("conn: frame of %d bytes exceeds max %d", len(b), c.maxFrameSize)
+	}
+
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(b)))
+	if _, err := c.rw.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := c.rw.Write(b)
+	return err
+}
@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package session_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/oklog/ulid/v2"
+
+	"go.salty.im/ratchet/session"
+	"go.salty.im/ratchet/xochimilco"
+)
+
+// establishedPair hand-shakes two xochimilco Sessions in-process (no
+// network lookup, unlike session.NewSession) and returns them wrapped for
+// Conn use, already Active.
+func establishedPair(t *testing.T) (alice, bob *session.Session) {
+	t.Helper()
+	is := is.New(t)
+
+	alicePub, alicePriv, err := ed25519.GenerateKey(nil)
+	is.NoErr(err)
+	bobPub, bobPriv, err := ed25519.GenerateKey(nil)
+	is.NoErr(err)
+
+	aliceX := &xochimilco.Session{
+		Me:          "alice",
+		IdentityKey: alicePriv,
+		LocalUUID:   ulid.Make().Bytes(),
+		VerifyPeer:  func(peer ed25519.PublicKey) bool { return bytes.Equal(peer, bobPub) },
+	}
+	bobX := &xochimilco.Session{
+		Me:          "bob",
+		IdentityKey: bobPriv,
+		LocalUUID:   ulid.Make().Bytes(),
+		VerifyPeer:  func(peer ed25519.PublicKey) bool { return bytes.Equal(peer, alicePub) },
+	}
+
+	offer, err := aliceX.Offer()
+	is.NoErr(err)
+
+	ack, err := bobX.Acknowledge(offer)
+	is.NoErr(err)
+
+	_, _, _, err = aliceX.Receive(ack)
+	is.NoErr(err)
+
+	is.True(aliceX.Active())
+	is.True(bobX.Active())
+
+	return &session.Session{Name: "bob", Session: aliceX}, &session.Session{Name: "alice", Session: bobX}
+}
+
+func TestConnRoundTrip(t *testing.T) {
+	is := is.New(t)
+
+	alice, bob := establishedPair(t)
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	aliceConn := session.NewConn(c1, alice)
+	bobConn := session.NewConn(c2, bob)
+
+	go func() {
+		_, _ = aliceConn.Write([]byte("hello bob"))
+	}()
+
+	buf := make([]byte, 64)
+	n, err := bobConn.Read(buf)
+	is.NoErr(err)
+	is.Equal(string(buf[:n]), "hello bob")
+}
+
+func TestConnClose(t *testing.T) {
+	is := is.New(t)
+
+	alice, bob := establishedPair(t)
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	aliceConn := session.NewConn(c1, alice)
+	bobConn := session.NewConn(c2, bob)
+
+	go func() {
+		_ = aliceConn.Close()
+	}()
+
+	_, err := bobConn.Read(make([]byte, 64))
+	is.Equal(err, io.EOF)
+}
+
+func TestConnMaxFrameSize(t *testing.T) {
+	is := is.New(t)
+
+	alice, bob := establishedPair(t)
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	aliceConn := session.NewConn(c1, alice)
+	bobConn := session.NewConn(c2, bob, session.WithMaxFrameSize(4))
+
+	go func() {
+		_, _ = aliceConn.Write([]byte("this payload encrypts to more than 4 bytes"))
+	}()
+
+	_, err := bobConn.Read(make([]byte, 64))
+	is.True(err != nil)
+}
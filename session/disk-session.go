@@ -4,6 +4,7 @@ package session
 
 import (
 	"bytes"
+	"context"
 	"crypto/ed25519"
 	"encoding/base64"
 	"encoding/gob"
@@ -11,12 +12,13 @@ import (
 	"errors"
 	"fmt"
 	"hash/fnv"
-	"io"
 	"os"
 	"path/filepath"
 
 	"github.com/keys-pub/keys"
 	"github.com/oklog/ulid/v2"
+	"go.salty.im/ratchet/crypto"
+	"go.salty.im/ratchet/locker"
 	"go.salty.im/ratchet/xochimilco"
 	"go.salty.im/saltyim"
 )
@@ -28,13 +30,21 @@ type Session struct {
 
 	PendingAck string
 
+	// DeviceID identifies which of the user's own devices last wrote this
+	// session, and SyncCounter is a per-session lamport clock: both are
+	// carried in sync.Frame so another device can tell whether its own copy
+	// is stale. See go.salty.im/ratchet/sync.
+	DeviceID    string
+	SyncCounter uint64
+
 	*xochimilco.Session
 }
 
-func NewSession(id ulid.ULID, me string, key *keys.EdX25519Key, name string, them saltyim.Addr) *Session {
+func NewSession(id ulid.ULID, me, deviceID string, key *keys.EdX25519Key, name string, them saltyim.Addr) *Session {
 	sess := &Session{
 		Endpoint: them.Endpoint().String(),
 		PeerKey:  them.Key(),
+		DeviceID: deviceID,
 		Session: &xochimilco.Session{
 			IdentityKey: key.Private(),
 			Me:          me,
@@ -57,17 +67,21 @@ func (s *Session) MarshalBinary() ([]byte, error) {
 	}
 
 	o := struct {
-		Name       string
-		Key        string
-		Endpoint   string
-		PendingAck string
-		Session    []byte
+		Name        string
+		Key         string
+		Endpoint    string
+		PendingAck  string
+		DeviceID    string
+		SyncCounter uint64
+		Session     []byte
 	}{
-		Name:       s.Name,
-		Key:        s.PeerKey.String(),
-		Endpoint:   s.Endpoint,
-		Session:    sess,
-		PendingAck: s.PendingAck,
+		Name:        s.Name,
+		Key:         s.PeerKey.String(),
+		Endpoint:    s.Endpoint,
+		Session:     sess,
+		PendingAck:  s.PendingAck,
+		DeviceID:    s.DeviceID,
+		SyncCounter: s.SyncCounter,
 	}
 
 	var buf bytes.Buffer
@@ -76,11 +90,13 @@ func (s *Session) MarshalBinary() ([]byte, error) {
 }
 func (s *Session) UnmarshalBinary(b []byte) error {
 	var o struct {
-		Name       string
-		Endpoint   string
-		Key        string
-		PendingAck string
-		Session    []byte
+		Name        string
+		Endpoint    string
+		Key         string
+		PendingAck  string
+		DeviceID    string
+		SyncCounter uint64
+		Session     []byte
 	}
 
 	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&o)
@@ -101,6 +117,8 @@ func (s *Session) UnmarshalBinary(b []byte) error {
 	s.SetPeerKey(o.Name, s.PeerKey.Bytes())
 	s.Endpoint = o.Endpoint
 	s.PendingAck = o.PendingAck
+	s.DeviceID = o.DeviceID
+	s.SyncCounter = o.SyncCounter
 
 	return err
 }
@@ -116,27 +134,165 @@ func (s *Session) Offer() (string, error) {
 	return s.Session.OfferSealed(s.PeerKey.X25519PublicKey().Bytes32())
 }
 
+// Device identifies one of a user's own devices that shares ratchet state
+// via sync.Frame messages.
+type Device struct {
+	ID       string
+	Key      *keys.EdX25519PublicKey
+	Endpoint string
+}
+
 type DiskSessionManager struct {
-	me       string
-	key      *keys.EdX25519Key
-	path     string
+	me          string
+	key         *keys.EdX25519Key
+	path        string
+	keyProvider crypto.KeyProvider
+
+	// state holds everything concurrent callers can mutate, behind a
+	// locker.Locked so two devices' goroutines (or a direct caller racing
+	// the client's own SessionManager lock, such as migrate.go's Export and
+	// Import) can't corrupt sessions/devices/migrations with interleaved
+	// writes.
+	state *locker.Locked[*diskState]
+}
+
+// diskState is the mutable body of a DiskSessionManager, guarded by state.
+type diskState struct {
 	pos      int64
+	deviceID string
 	sessions map[string]ulid.ULID
+	devices  map[string]Device
+
+	// migrations tracks, per globalSessionID, the highest SyncCounter Put
+	// has accepted, so a migrated or synced copy with stale ratchet state
+	// is rejected as a fork instead of silently overwriting newer state.
+	migrations map[[16]byte]migrationRecord
+}
+
+// migrationRecord is the last-accepted state for one globalSessionID.
+type migrationRecord struct {
+	LocalUUID ulid.ULID
+	Counter   uint64
+}
+
+// Option configures a DiskSessionManager at construction time. See
+// WithPassphrase and WithKeyProvider.
+type Option interface {
+	ApplyDiskSessionManager(*DiskSessionManager)
+}
+
+type withKeyProvider struct {
+	crypto.KeyProvider
 }
 
-func NewSessionManager(path, me string, key *keys.EdX25519Key) (*DiskSessionManager, func() error, error) {
-	dm := &DiskSessionManager{me, key, path, -1, make(map[string]ulid.ULID)}
-	return dm, dm.Close, dm.Load()
+// WithKeyProvider encrypts session blobs at rest under a key derived by kp,
+// rather than writing them as plain gob. See WithPassphrase for the common
+// case of a fixed passphrase.
+func WithKeyProvider(kp crypto.KeyProvider) Option {
+	return withKeyProvider{kp}
+}
+
+func (w withKeyProvider) ApplyDiskSessionManager(sm *DiskSessionManager) {
+	sm.keyProvider = w.KeyProvider
+}
+
+// WithPassphrase encrypts session blobs at rest, deriving the key from pass
+// via scrypt. A plain (pre-encryption) session file is still read
+// transparently by Get; a file sealed under a different passphrase fails to
+// open. Call Rekey to bring every file up to a new passphrase.
+func WithPassphrase(pass string) Option {
+	return WithKeyProvider(crypto.Passphrase(pass))
+}
+
+func NewSessionManager(path, me string, key *keys.EdX25519Key, opts ...Option) (*DiskSessionManager, func() error, error) {
+	dm := &DiskSessionManager{
+		me:   me,
+		key:  key,
+		path: path,
+		state: locker.New(&diskState{
+			pos:        -1,
+			sessions:   make(map[string]ulid.ULID),
+			devices:    make(map[string]Device),
+			migrations: make(map[[16]byte]migrationRecord),
+		}),
+	}
+	for _, o := range opts {
+		o.ApplyDiskSessionManager(dm)
+	}
+
+	if err := dm.Load(); err != nil {
+		return nil, nil, err
+	}
+
+	_ = dm.state.Use(context.Background(), func(_ context.Context, s *diskState) error {
+		if s.deviceID == "" {
+			s.deviceID = ulid.Make().String()
+		}
+		return nil
+	})
+
+	return dm, dm.Close, nil
 }
 func (sm *DiskSessionManager) Identity() *keys.EdX25519Key {
 	return sm.key
 }
+
+// DeviceID identifies this SessionManager's device for sync.Frame conflict
+// resolution.
+func (sm *DiskSessionManager) DeviceID() string {
+	var id string
+	_ = sm.state.Use(context.Background(), func(_ context.Context, s *diskState) error {
+		id = s.deviceID
+		return nil
+	})
+	return id
+}
+
+// Peers lists the user's other known devices, as registered with AddDevice.
+func (sm *DiskSessionManager) Peers() []Device {
+	var lis []Device
+	_ = sm.state.Use(context.Background(), func(_ context.Context, s *diskState) error {
+		lis = make([]Device, 0, len(s.devices))
+		for _, d := range s.devices {
+			lis = append(lis, d)
+		}
+		return nil
+	})
+	return lis
+}
+
+// AddDevice registers another of the user's devices so future session
+// mutations are synced to it.
+func (sm *DiskSessionManager) AddDevice(d Device) error {
+	return sm.state.Use(context.Background(), func(_ context.Context, s *diskState) error {
+		s.devices[d.ID] = d
+		return nil
+	})
+}
+
+// Register maps name to id, overwriting any previous mapping. Unlike
+// ByName, it never allocates: it's for a caller (e.g. Client's sync.Frame
+// handling) that already knows the id a session must use, such as one
+// assigned by another of the user's devices.
+func (sm *DiskSessionManager) Register(name string, id ulid.ULID) {
+	_ = sm.state.Use(context.Background(), func(_ context.Context, s *diskState) error {
+		s.sessions[name] = id
+		return nil
+	})
+}
+
 func (sm *DiskSessionManager) ByName(name string) ulid.ULID {
-	if u, ok := sm.sessions[name]; ok {
-		return u
-	}
-	sm.sessions[name] = ulid.Make()
-	return sm.sessions[name]
+	var id ulid.ULID
+	_ = sm.state.Use(context.Background(), func(_ context.Context, s *diskState) error {
+		if u, ok := s.sessions[name]; ok {
+			id = u
+			return nil
+		}
+		id = ulid.Make()
+		s.sessions[name] = id
+		return nil
+	})
+	return id
 }
 func (sm *DiskSessionManager) New(them string) (*Session, error) {
 	id := sm.ByName(them)
@@ -144,7 +300,7 @@ func (sm *DiskSessionManager) New(them string) (*Session, error) {
 	if err != nil {
 		return nil, fmt.Errorf("fetching key for %s: %w", them, err)
 	}
-	return NewSession(id, sm.me, sm.key, them, addr), nil
+	return NewSession(id, sm.me, sm.DeviceID(), sm.key, them, addr), nil
 }
 func (sm *DiskSessionManager) Get(id ulid.ULID) (*Session, error) {
 	sh := sessionhash(sm.me, id)
@@ -159,13 +315,24 @@ func (sm *DiskSessionManager) Get(id ulid.ULID) (*Session, error) {
 		return nil, fmt.Errorf("permissions are too weak")
 	}
 
-	f, err := os.Open(filename)
+	recs, err := walLoad(filename)
 	if err != nil {
-		return nil, fmt.Errorf("open %w", err)
+		return nil, fmt.Errorf("open %s: %w", filename, err)
 	}
-	b, err := io.ReadAll(f)
-	if err != nil {
-		return nil, fmt.Errorf("read %d bytes: %w", len(b), err)
+	if len(recs) == 0 {
+		return nil, fmt.Errorf("open %s: no valid session record", filename)
+	}
+	last := recs[len(recs)-1]
+
+	b := last.Payload
+	if crypto.IsSealed(b) {
+		if sm.keyProvider == nil {
+			return nil, fmt.Errorf("open %s: sealed but no key provider was given", filename)
+		}
+		b, err = crypto.Open(sm.keyProvider, b)
+		if err != nil {
+			return nil, fmt.Errorf("open %s: %w", filename, err)
+		}
 	}
 
 	sess := &Session{}
@@ -176,6 +343,13 @@ func (sm *DiskSessionManager) Get(id ulid.ULID) (*Session, error) {
 		sess.IdentityKey = sm.key.Private()
 	}
 
+	// Fold a log that has grown past the threshold back down to one
+	// Snapshot record, same as Put does, so a session that's only ever
+	// read (never written) doesn't grow its WAL unbounded either.
+	if len(recs) > walCompactThreshold {
+		_ = walCompact(filename, last)
+	}
+
 	return sess, err
 }
 func (sm *DiskSessionManager) Put(sess *Session) error {
@@ -183,26 +357,126 @@ func (sm *DiskSessionManager) Put(sess *Session) error {
 	filename := filepath.Join(sm.path, sh)
 
 	// log("SAVE: ", filename)
-	err := os.MkdirAll(filepath.Dir(filename), 0700)
-	if err != nil {
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
 		return err
 	}
 
-	fp, err := os.OpenFile(filename, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0600)
+	b, err := sess.MarshalBinary()
 	if err != nil {
 		return err
 	}
 
-	b, err := sess.MarshalBinary()
-	if err != nil {
-		return err
+	if sm.keyProvider != nil {
+		b, err = crypto.Seal(sm.keyProvider, b)
+		if err != nil {
+			return fmt.Errorf("seal %s: %w", filename, err)
+		}
 	}
 
-	_, err = fp.Write(b)
-	if err != nil {
-		return err
+	// The migrations check-and-update and the WAL read-modify-write both
+	// run inside this single sm.state.Use call, not separate ones: the WAL
+	// file is as much shared, mutable state as the address-book maps, and
+	// two concurrent Put calls for the same session racing walLoad against
+	// walAppend/walCompact could otherwise corrupt it.
+	return sm.state.Use(context.Background(), func(_ context.Context, s *diskState) error {
+		if sess.Active() {
+			if globalID, err := globalSessionID(sess); err == nil {
+				if prev, ok := s.migrations[globalID]; ok && prev.LocalUUID == toULID(sess.LocalUUID) && sess.SyncCounter < prev.Counter {
+					return fmt.Errorf("%w: ratchet counter %d is older than the stored %d", ErrFork, sess.SyncCounter, prev.Counter)
+				}
+				s.migrations[globalID] = migrationRecord{LocalUUID: toULID(sess.LocalUUID), Counter: sess.SyncCounter}
+			}
+		}
+
+		recs, err := walLoad(filename)
+		if err != nil {
+			return fmt.Errorf("put %s: %w", filename, err)
+		}
+
+		op := walOpRatchetStep
+		if sess.PendingAck != "" {
+			op = walOpPendingAck
+		}
+		rec := walRecord{Seq: uint64(len(recs)) + 1, Op: op, Payload: b}
+
+		// Put only ever appends; the log is folded back into a single
+		// Snapshot record once it grows past walCompactThreshold, instead of
+		// rewriting the whole file (and risking a torn write) on every call.
+		if len(recs) >= walCompactThreshold {
+			return walCompact(filename, rec)
+		}
+		return walAppend(filename, rec)
+	})
+}
+
+// Sync forces sess's on-disk log to stable storage. Put itself only
+// appends without an fsync, so a caller that wants a message's state
+// durable before, say, acknowledging it to the user should call Sync at
+// that point rather than relying on every Put to pay for one.
+func (sm *DiskSessionManager) Sync(sess *Session) error {
+	sh := sessionhash(sm.me, toULID(sess.LocalUUID))
+	return walSync(filepath.Join(sm.path, sh))
+}
+
+// Rekey rewrites every session file on disk under a key derived from
+// newPass, replacing whatever key (or lack of one) previously protected
+// each file. Each file is migrated with write-temp+rename, so a crash
+// mid-rotation leaves every file either fully rotated or untouched, never
+// partially written.
+func (sm *DiskSessionManager) Rekey(newPass string) error {
+	newKeyProvider := crypto.Passphrase(newPass)
+
+	var ids []ulid.ULID
+	_ = sm.state.Use(context.Background(), func(_ context.Context, s *diskState) error {
+		ids = make([]ulid.ULID, 0, len(s.sessions))
+		for _, id := range s.sessions {
+			ids = append(ids, id)
+		}
+		return nil
+	})
+
+	for _, id := range ids {
+		sh := sessionhash(sm.me, id)
+		filename := filepath.Join(sm.path, sh)
+
+		recs, err := walLoad(filename)
+		if err != nil {
+			return fmt.Errorf("rekey: read %s: %w", filename, err)
+		}
+		if len(recs) == 0 {
+			continue
+		}
+		last := recs[len(recs)-1]
+
+		b := last.Payload
+		if crypto.IsSealed(b) {
+			if sm.keyProvider == nil {
+				return fmt.Errorf("rekey: %s is sealed but no key provider was given", filename)
+			}
+			b, err = crypto.Open(sm.keyProvider, b)
+			if err != nil {
+				return fmt.Errorf("rekey: open %s: %w", filename, err)
+			}
+		}
+
+		b, err = crypto.Seal(newKeyProvider, b)
+		if err != nil {
+			return fmt.Errorf("rekey: seal %s: %w", filename, err)
+		}
+
+		// Rekey already does a full write-temp+rename per file, so fold
+		// the log down to one Snapshot record while we're at it.
+		tmp := filename + ".tmp"
+		if err := os.WriteFile(tmp, encodeWALRecord(walRecord{Seq: last.Seq, Op: walOpSnapshot, Payload: b}), 0600); err != nil {
+			return fmt.Errorf("rekey: write %s: %w", tmp, err)
+		}
+		if err := os.Rename(tmp, filename); err != nil {
+			return fmt.Errorf("rekey: rename %s: %w", tmp, err)
+		}
 	}
-	return fp.Close()
+
+	sm.keyProvider = newKeyProvider
+	return nil
 }
 func (sm *DiskSessionManager) Delete(sess *Session) error {
 	u := ulid.ULID{}
@@ -210,7 +484,10 @@ func (sm *DiskSessionManager) Delete(sess *Session) error {
 	sh := sessionhash(sm.me, u)
 	filename := filepath.Join(sm.path, sh)
 	// log("REMOVE:", filename)
-	delete(sm.sessions, sess.Name)
+	_ = sm.state.Use(context.Background(), func(_ context.Context, s *diskState) error {
+		delete(s.sessions, sess.Name)
+		return nil
+	})
 	return os.Remove(filename)
 }
 func (sm *DiskSessionManager) Load() error {
@@ -228,9 +505,22 @@ func (sm *DiskSessionManager) Load() error {
 		Name    string
 		Session ulid.ULID
 	}
+	type device struct {
+		ID       string
+		Key      string
+		Endpoint string
+	}
+	type migration struct {
+		GlobalID  string
+		LocalUUID ulid.ULID
+		Counter   uint64
+	}
 	var data struct {
-		Position int64
-		Sessions []item
+		Position   int64
+		DeviceID   string
+		Sessions   []item
+		Devices    []device
+		Migrations []migration
 	}
 
 	err = json.NewDecoder(fp).Decode(&data)
@@ -238,14 +528,36 @@ func (sm *DiskSessionManager) Load() error {
 		return err
 	}
 
-	if data.Position > 0 {
-		sm.pos = data.Position
-	}
-	for _, v := range data.Sessions {
-		sm.sessions[v.Name] = v.Session
-	}
-
-	return nil
+	return sm.state.Use(context.Background(), func(_ context.Context, s *diskState) error {
+		if data.Position > 0 {
+			s.pos = data.Position
+		}
+		s.deviceID = data.DeviceID
+		for _, v := range data.Sessions {
+			s.sessions[v.Name] = v.Session
+		}
+		for _, v := range data.Devices {
+			id, err := keys.ParseID(v.Key)
+			if err != nil {
+				return fmt.Errorf("parse device key for %s: %w", v.ID, err)
+			}
+			key, err := keys.NewEdX25519PublicKeyFromID(id)
+			if err != nil {
+				return fmt.Errorf("parse device key for %s: %w", v.ID, err)
+			}
+			s.devices[v.ID] = Device{ID: v.ID, Key: key, Endpoint: v.Endpoint}
+		}
+		for _, v := range data.Migrations {
+			raw, err := base64.RawURLEncoding.DecodeString(v.GlobalID)
+			if err != nil || len(raw) != 16 {
+				continue
+			}
+			var globalID [16]byte
+			copy(globalID[:], raw)
+			s.migrations[globalID] = migrationRecord{LocalUUID: v.LocalUUID, Counter: v.Counter}
+		}
+		return nil
+	})
 }
 func (sm *DiskSessionManager) Close() error {
 	name := filepath.Join(sm.path, "sess-"+sm.me+".json")
@@ -260,22 +572,53 @@ func (sm *DiskSessionManager) Close() error {
 		Name    string
 		Session ulid.ULID
 	}
-	var data struct {
-		Position int64
-		Sessions []item
+	type device struct {
+		ID       string
+		Key      string
+		Endpoint string
 	}
-	data.Position = sm.pos
-	for k, v := range sm.sessions {
-		data.Sessions = append(data.Sessions, item{k, v})
+	type migration struct {
+		GlobalID  string
+		LocalUUID ulid.ULID
+		Counter   uint64
 	}
+	var data struct {
+		Position   int64
+		DeviceID   string
+		Sessions   []item
+		Devices    []device
+		Migrations []migration
+	}
+	_ = sm.state.Use(context.Background(), func(_ context.Context, s *diskState) error {
+		data.Position = s.pos
+		data.DeviceID = s.deviceID
+		for k, v := range s.sessions {
+			data.Sessions = append(data.Sessions, item{k, v})
+		}
+		for _, d := range s.devices {
+			data.Devices = append(data.Devices, device{d.ID, d.Key.String(), d.Endpoint})
+		}
+		for globalID, v := range s.migrations {
+			data.Migrations = append(data.Migrations, migration{enc(globalID[:]), v.LocalUUID, v.Counter})
+		}
+		return nil
+	})
 
 	return json.NewEncoder(fp).Encode(data)
 }
 func (sm *DiskSessionManager) Position() int64 {
-	return sm.pos
+	var pos int64
+	_ = sm.state.Use(context.Background(), func(_ context.Context, s *diskState) error {
+		pos = s.pos
+		return nil
+	})
+	return pos
 }
 func (sm *DiskSessionManager) SetPosition(pos int64) {
-	sm.pos = pos
+	_ = sm.state.Use(context.Background(), func(_ context.Context, s *diskState) error {
+		s.pos = pos
+		return nil
+	})
 }
 
 type Pair[K, V any] struct {
@@ -284,10 +627,14 @@ type Pair[K, V any] struct {
 }
 
 func (sm *DiskSessionManager) Sessions() []Pair[string, ulid.ULID] {
-	lis := make([]Pair[string, ulid.ULID], 0, len(sm.sessions))
-	for k, v := range sm.sessions {
-		lis = append(lis, Pair[string, ulid.ULID]{k, v})
-	}
+	var lis []Pair[string, ulid.ULID]
+	_ = sm.state.Use(context.Background(), func(_ context.Context, s *diskState) error {
+		lis = make([]Pair[string, ulid.ULID], 0, len(s.sessions))
+		for k, v := range s.sessions {
+			lis = append(lis, Pair[string, ulid.ULID]{k, v})
+		}
+		return nil
+	})
 	return lis
 }
 
@@ -326,4 +673,9 @@ func fetchKey(to string) (saltyim.Addr, error) {
 var (
 	ErrNotExist = errors.New("does not exist")
 	ErrInternal = errors.New("internal error")
+
+	// ErrFork is returned by Put when a session's ratchet counter is older
+	// than the last one accepted for the same globalSessionID, meaning two
+	// devices mutated the session concurrently.
+	ErrFork = errors.New("concurrent session fork detected")
 )
@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package session
+
+import (
+	"github.com/keys-pub/keys"
+	"github.com/oklog/ulid/v2"
+)
+
+// Manager is the storage backend a client.SessionManager is built on: a
+// place to create, fetch, persist, and enumerate a user's ratchet Sessions,
+// plus the multi-device Peers/AddDevice bookkeeping and the sync.Frame
+// Position watermark. DiskSessionManager, MemorySessionManager,
+// SQLSessionManager, and RedisSessionManager all implement it, so the
+// backing store can be swapped without touching client.Client.
+type Manager interface {
+	Identity() *keys.EdX25519Key
+
+	// DeviceID identifies this Manager's device for sync.Frame conflict
+	// resolution.
+	DeviceID() string
+
+	ByName(name string) ulid.ULID
+
+	// Register maps name to id, overwriting any previous mapping. Unlike
+	// ByName, it never allocates: it's for a caller that already knows the
+	// id a session must use, such as one assigned by another of the user's
+	// devices.
+	Register(name string, id ulid.ULID)
+
+	New(them string) (*Session, error)
+	Get(id ulid.ULID) (*Session, error)
+	Put(sess *Session) error
+	Delete(sess *Session) error
+	Sessions() []Pair[string, ulid.ULID]
+
+	// Peers lists the user's other known devices, as registered with
+	// AddDevice.
+	Peers() []Device
+	AddDevice(d Device) error
+
+	Position() int64
+	SetPosition(pos int64)
+
+	Close() error
+}
+
+var _ Manager = (*DiskSessionManager)(nil)
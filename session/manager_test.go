@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package session_test
+
+import (
+	"database/sql"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/foxcpp/go-mockdns"
+	"github.com/keys-pub/keys"
+	"github.com/matryer/is"
+	_ "modernc.org/sqlite"
+
+	"go.salty.im/ratchet/session"
+)
+
+func mockBobLookup(t *testing.T) func() {
+	t.Helper()
+
+	http.DefaultClient.Transport = httpMock(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			Status:     http.StatusText(http.StatusOK),
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"endpoint":"https://ev.sour.is/inbox/01GPYAXX53N6GCKJV2BPJGTQPB","key":"kex1ac2s0vwskgctgjucqldtd5k4v5xjxv80smf0n9dqqags43keu7usqfh9ud"}`)),
+		}, nil
+	})
+
+	srv, _ := mockdns.NewServer(map[string]mockdns.Zone{
+		"_salty._tcp.sour.is.": {
+			SRV: []net.SRV{{Target: "test.sour.is.", Port: 443}},
+		},
+	}, false)
+	srv.PatchNet(net.DefaultResolver)
+
+	return func() {
+		http.DefaultClient = &http.Client{}
+		srv.Close()
+		mockdns.UnpatchNet(net.DefaultResolver)
+	}
+}
+
+// TestMemorySessionManager exercises MemorySessionManager the same way
+// TestSessionManager exercises DiskSessionManager, minus the reopen/close
+// persistence checks: memory state does not outlive the process.
+func TestMemorySessionManager(t *testing.T) {
+	defer mockBobLookup(t)()
+	is := is.New(t)
+
+	key := keys.GenerateEdX25519Key()
+
+	sm, close, err := session.NewMemorySessionManager("me@sour.is", key)
+	is.NoErr(err)
+	defer is.NoErr(close())
+
+	is.Equal(len(sm.Sessions()), 0)
+
+	them, err := sm.New("bob@sour.is")
+	is.NoErr(err)
+
+	is.NoErr(sm.Put(them))
+	is.Equal(len(sm.Sessions()), 1)
+
+	got, err := sm.Get(sm.ByName("bob@sour.is"))
+	is.NoErr(err)
+	is.Equal(got.Name, "bob@sour.is")
+
+	is.NoErr(sm.Delete(got))
+	is.Equal(len(sm.Sessions()), 0)
+}
+
+// TestSQLSessionManager exercises SQLSessionManager against the in-memory
+// sqlite driver, including that device id and sync position survive a
+// fresh manager opened against the same *sql.DB.
+func TestSQLSessionManager(t *testing.T) {
+	defer mockBobLookup(t)()
+	is := is.New(t)
+
+	key := keys.GenerateEdX25519Key()
+
+	db, err := sql.Open("sqlite", ":memory:")
+	is.NoErr(err)
+	defer db.Close()
+
+	sm, close, err := session.NewSQLSessionManager(db, "me@sour.is", key)
+	is.NoErr(err)
+	defer is.NoErr(close())
+
+	is.Equal(len(sm.Sessions()), 0)
+
+	them, err := sm.New("bob@sour.is")
+	is.NoErr(err)
+	is.NoErr(sm.Put(them))
+	is.Equal(len(sm.Sessions()), 1)
+
+	got, err := sm.Get(sm.ByName("bob@sour.is"))
+	is.NoErr(err)
+	is.Equal(got.Name, "bob@sour.is")
+
+	sm.SetPosition(42)
+
+	// Device id and sync position persist for an identity even across a
+	// fresh manager, as long as it's opened against the same *sql.DB.
+	sm2, close2, err := session.NewSQLSessionManager(db, "me@sour.is", key)
+	is.NoErr(err)
+	defer is.NoErr(close2())
+	is.Equal(sm2.DeviceID(), sm.DeviceID())
+	is.Equal(sm2.Position(), int64(42))
+
+	is.NoErr(sm.Delete(got))
+	is.Equal(len(sm.Sessions()), 0)
+}
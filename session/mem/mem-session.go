@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"go.salty.im/ratchet/client"
+	"go.salty.im/ratchet/store"
 	"go.sour.is/pkg/locker"
 	"go.sour.is/pkg/math"
 )
@@ -12,6 +13,10 @@ type logs map[string][]any
 
 type MemSession struct {
 	logs *locker.Locked[logs]
+
+	// log, if set, durably journals every Update so a restarted process can
+	// recover scrollback NewMemSession alone would have lost.
+	log store.EventLog
 }
 
 type SessionLogger interface {
@@ -21,16 +26,16 @@ type SessionLogger interface {
 func NewMemSession(c *client.Client) *MemSession {
 	m := &MemSession{logs: locker.New(make(logs))}
 
-	client.On(c, func(ctx context.Context, args client.OnOfferSent) { m.Update(ctx, args) })
-	client.On(c, func(ctx context.Context, args client.OnOfferReceived) { m.Update(ctx, args) })
-	client.On(c, func(ctx context.Context, args client.OnSessionStarted) { m.Update(ctx, args) })
-	client.On(c, func(ctx context.Context, args client.OnSessionClosed) { m.Update(ctx, args) })
-	client.On(c, func(ctx context.Context, args client.OnMessageReceived) { m.Update(ctx, args) })
-	client.On(c, func(ctx context.Context, args client.OnMessageSent) { m.Update(ctx, args) })
-	client.On(c, func(ctx context.Context, args client.OnSaltySent) { m.Update(ctx, args) })
-	client.On(c, func(ctx context.Context, args client.OnSaltyTextReceived) { m.Update(ctx, args) })
-	client.On(c, func(ctx context.Context, args client.OnSaltyEventReceived) { m.Update(ctx, args) })
-	client.On(c, func(ctx context.Context, args client.OnReceived) { m.Update(ctx, args) })
+	client.Subscribe(c, func(ctx context.Context, args client.OnOfferSent) error { m.Update(ctx, args); return nil })
+	client.Subscribe(c, func(ctx context.Context, args client.OnOfferReceived) error { m.Update(ctx, args); return nil })
+	client.Subscribe(c, func(ctx context.Context, args client.OnSessionStarted) error { m.Update(ctx, args); return nil })
+	client.Subscribe(c, func(ctx context.Context, args client.OnSessionClosed) error { m.Update(ctx, args); return nil })
+	client.Subscribe(c, func(ctx context.Context, args client.OnMessageReceived) error { m.Update(ctx, args); return nil })
+	client.Subscribe(c, func(ctx context.Context, args client.OnMessageSent) error { m.Update(ctx, args); return nil })
+	client.Subscribe(c, func(ctx context.Context, args client.OnSaltySent) error { m.Update(ctx, args); return nil })
+	client.Subscribe(c, func(ctx context.Context, args client.OnSaltyTextReceived) error { m.Update(ctx, args); return nil })
+	client.Subscribe(c, func(ctx context.Context, args client.OnSaltyEventReceived) error { m.Update(ctx, args); return nil })
+	client.Subscribe(c, func(ctx context.Context, args client.OnReceived) error { m.Update(ctx, args); return nil })
 
 	return m
 }
@@ -40,6 +45,23 @@ func (m *MemSession) ReadLog(ctx context.Context, streamID string, after, count
 	err := m.logs.Use(ctx, func(ctx context.Context, l logs) error {
 		stream, ok := l[streamID]
 		if !ok || len(stream) == 0 {
+			if m.log == nil {
+				return nil
+			}
+
+			recs, err := m.log.Read(ctx, streamID, after, count)
+			if err != nil || len(recs) == 0 {
+				return err
+			}
+
+			lis = make([]any, 0, len(recs))
+			for _, rec := range recs {
+				ev, err := decodeEvent(rec.Data)
+				if err != nil {
+					return err
+				}
+				lis = append(lis, ev)
+			}
 			return nil
 		}
 
@@ -81,41 +103,46 @@ func (m *MemSession) ReadLog(ctx context.Context, streamID string, after, count
 	return lis, err
 }
 func (m *MemSession) Update(ctx context.Context, args any) {
+	streamID, ok := streamIDFor(args)
+	if !ok {
+		return
+	}
+
 	_ = m.logs.Use(ctx, func(ctx context.Context, l logs) error {
-		switch msg := args.(type) {
-		// case client.OnOfferSent:
-		// case client.OnOfferReceived:
-		case client.OnSessionStarted:
-			log := l["user:"+msg.Them]
-			log = append(log, msg)
-			l["user:"+msg.Them] = log
-		case client.OnSessionClosed:
-			log := l["user:"+msg.Them]
-			log = append(log, msg)
-			l["user:"+msg.Them] = log
-		case client.OnMessageReceived:
-			log := l["user:"+msg.Them]
-			log = append(log, msg)
-			l["user:"+msg.Them] = log
-		case client.OnMessageSent:
-			log := l["user:"+msg.Them]
-			log = append(log, msg)
-			l["user:"+msg.Them] = log
-		case client.OnSaltySent:
-			log := l["user:"+msg.Them]
-			log = append(log, msg)
-			l["user:"+msg.Them] = log
-		case client.OnSaltyTextReceived:
-			log := l["user:"+msg.Msg.User.Nick]
-			log = append(log, msg)
-			l["user:"+msg.Msg.User.Nick] = log
-		// case client.OnSaltyEventReceived:
-		case client.OnReceived:
-		default:
-			log := l["system"]
-			log = append(log, msg)
-			l["system"] = log
-		}
+		l[streamID] = append(l[streamID], args)
 		return nil
 	})
+
+	if m.log == nil {
+		return
+	}
+
+	data, err := encodeEvent(args)
+	if err != nil {
+		return
+	}
+	_, _ = m.log.Append(ctx, streamID, data)
+}
+
+// streamIDFor picks the stream an event belongs in, the same way Update's
+// old type switch did. ok is false for events that aren't logged at all.
+func streamIDFor(args any) (streamID string, ok bool) {
+	switch msg := args.(type) {
+	case client.OnSessionStarted:
+		return "user:" + msg.Them, true
+	case client.OnSessionClosed:
+		return "user:" + msg.Them, true
+	case client.OnMessageReceived:
+		return "user:" + msg.Them, true
+	case client.OnMessageSent:
+		return "user:" + msg.Them, true
+	case client.OnSaltySent:
+		return "user:" + msg.Them, true
+	case client.OnSaltyTextReceived:
+		return "user:" + msg.Msg.User.Nick, true
+	case client.OnReceived:
+		return "", false
+	default:
+		return "system", true
+	}
 }
@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package mem
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"go.salty.im/ratchet/client"
+	"go.salty.im/ratchet/store"
+)
+
+func init() {
+	gob.Register(client.OnOfferSent{})
+	gob.Register(client.OnOfferReceived{})
+	gob.Register(client.OnSessionStarted{})
+	gob.Register(client.OnSessionClosed{})
+	gob.Register(client.OnMessageReceived{})
+	gob.Register(client.OnMessageSent{})
+	gob.Register(client.OnSaltySent{})
+	gob.Register(client.OnSaltyTextReceived{})
+	gob.Register(client.OnSaltyEventReceived{})
+}
+
+// NewPersistentMemSession is a drop-in replacement for NewMemSession that
+// additionally journals every event to log, so ReadLog can recover
+// scrollback a restarted process would otherwise have lost.
+func NewPersistentMemSession(c *client.Client, log store.EventLog) *MemSession {
+	m := NewMemSession(c)
+	m.log = log
+	return m
+}
+
+func encodeEvent(args any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&args)
+	return buf.Bytes(), err
+}
+
+func decodeEvent(data []byte) (any, error) {
+	var args any
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&args)
+	return args, err
+}
@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package session
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/keys-pub/keys"
+	"github.com/oklog/ulid/v2"
+)
+
+// MemorySessionManager is a Manager backed by in-process maps instead of a
+// directory on disk. It never touches the filesystem, so it suits tests and
+// other short-lived processes that want Manager's semantics without
+// DiskSessionManager's file layout.
+//
+// State does not outlive the process: Close is a no-op, and there is
+// nothing to Load.
+type MemorySessionManager struct {
+	me       string
+	key      *keys.EdX25519Key
+	pos      int64
+	deviceID string
+	sessions map[string]ulid.ULID
+	data     map[ulid.ULID][]byte
+	devices  map[string]Device
+}
+
+// NewMemorySessionManager mirrors NewSessionManager's signature minus the
+// path, since there is no directory to load from or save to.
+func NewMemorySessionManager(me string, key *keys.EdX25519Key) (*MemorySessionManager, func() error, error) {
+	sm := &MemorySessionManager{
+		me:       me,
+		key:      key,
+		pos:      -1,
+		deviceID: ulid.Make().String(),
+		sessions: make(map[string]ulid.ULID),
+		data:     make(map[ulid.ULID][]byte),
+		devices:  make(map[string]Device),
+	}
+	return sm, sm.Close, nil
+}
+
+func (sm *MemorySessionManager) Identity() *keys.EdX25519Key { return sm.key }
+func (sm *MemorySessionManager) DeviceID() string            { return sm.deviceID }
+
+func (sm *MemorySessionManager) Peers() []Device {
+	lis := make([]Device, 0, len(sm.devices))
+	for _, d := range sm.devices {
+		lis = append(lis, d)
+	}
+	return lis
+}
+func (sm *MemorySessionManager) AddDevice(d Device) error {
+	sm.devices[d.ID] = d
+	return nil
+}
+
+func (sm *MemorySessionManager) Register(name string, id ulid.ULID) {
+	sm.sessions[name] = id
+}
+func (sm *MemorySessionManager) ByName(name string) ulid.ULID {
+	if u, ok := sm.sessions[name]; ok {
+		return u
+	}
+	sm.sessions[name] = ulid.Make()
+	return sm.sessions[name]
+}
+func (sm *MemorySessionManager) New(them string) (*Session, error) {
+	id := sm.ByName(them)
+	addr, err := fetchKey(them)
+	if err != nil {
+		return nil, fmt.Errorf("fetching key for %s: %w", them, err)
+	}
+	return NewSession(id, sm.me, sm.deviceID, sm.key, them, addr), nil
+}
+func (sm *MemorySessionManager) Get(id ulid.ULID) (*Session, error) {
+	b, ok := sm.data[id]
+	if !ok {
+		return nil, fmt.Errorf("get %s: %w", id, os.ErrNotExist)
+	}
+
+	sess := &Session{}
+	if err := sess.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+
+	// session only needs private key during initial handshake.
+	if !sess.Active() {
+		sess.IdentityKey = sm.key.Private()
+	}
+
+	return sess, nil
+}
+func (sm *MemorySessionManager) Put(sess *Session) error {
+	b, err := sess.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	sm.data[toULID(sess.LocalUUID)] = b
+	return nil
+}
+func (sm *MemorySessionManager) Delete(sess *Session) error {
+	id := toULID(sess.LocalUUID)
+	if _, ok := sm.data[id]; !ok {
+		return fmt.Errorf("delete %s: %w", id, ErrNotExist)
+	}
+	delete(sm.data, id)
+	delete(sm.sessions, sess.Name)
+	return nil
+}
+func (sm *MemorySessionManager) Sessions() []Pair[string, ulid.ULID] {
+	lis := make([]Pair[string, ulid.ULID], 0, len(sm.sessions))
+	for k, v := range sm.sessions {
+		lis = append(lis, Pair[string, ulid.ULID]{k, v})
+	}
+	return lis
+}
+func (sm *MemorySessionManager) Position() int64       { return sm.pos }
+func (sm *MemorySessionManager) SetPosition(pos int64) { sm.pos = pos }
+func (sm *MemorySessionManager) Close() error          { return nil }
+
+var _ Manager = (*MemorySessionManager)(nil)
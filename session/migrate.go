@@ -0,0 +1,191 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package session
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/keys-pub/keys"
+	"github.com/oklog/ulid/v2"
+	"golang.org/x/crypto/hkdf"
+)
+
+// migrationVersion is bumped if the envelope layout changes.
+const migrationVersion = 1
+
+// migrationWindow bounds how far an envelope's timestamp may drift from now
+// before Import refuses it as a possible replay of a captured export.
+const migrationWindow = 5 * time.Minute
+
+// migrationBody is the plaintext sealed inside a migration envelope. Sig is
+// a detached signature by SenderKey over the struct with Sig itself zeroed,
+// so Import can authenticate the exporting identity without a prior
+// exchange with the recipient.
+type migrationBody struct {
+	GlobalID  [16]byte
+	Version   byte
+	Timestamp int64
+	SenderKey []byte
+	Session   []byte
+	Sig       []byte
+}
+
+// globalSessionID derives a stable identifier for sess from the ratchet's
+// current root key and the peer's identity key, so two of the user's
+// devices holding the same session land on the same ID without exchanging
+// anything extra.
+func globalSessionID(sess *Session) ([16]byte, error) {
+	var id [16]byte
+
+	root := sess.RootKey()
+	if len(root) == 0 {
+		return id, errors.New("session has no established root key yet")
+	}
+
+	h := hkdf.New(sha256.New, root, sess.PeerKey.Bytes(), []byte("go.salty.im/ratchet session migration"))
+	if _, err := io.ReadFull(h, id[:]); err != nil {
+		return id, fmt.Errorf("derive global id: %w", err)
+	}
+	return id, nil
+}
+
+// Export produces a sealed envelope carrying sess's live ratchet state, so
+// recipient can install it on another of the user's devices without redoing
+// the X3DH handshake. sess.IdentityKey must hold the exporting identity's
+// private key; Get only repopulates it for sessions that have not yet been
+// established, so an active session must have it set explicitly before
+// calling Export.
+//
+// The envelope is anonymously encrypted to recipient's X25519 key and
+// signed by the exporting identity, so Import can authenticate the sender
+// without a prior exchange.
+func Export(sess *Session, recipient *keys.EdX25519PublicKey) ([]byte, error) {
+	if len(sess.IdentityKey) == 0 {
+		return nil, errors.New("export: session has no identity key to sign with")
+	}
+	identity := keys.NewEdX25519KeyFromPrivateKey((*[64]byte)(sess.IdentityKey))
+
+	globalID, err := globalSessionID(sess)
+	if err != nil {
+		return nil, fmt.Errorf("export: %w", err)
+	}
+
+	state, err := sess.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("export: marshal session: %w", err)
+	}
+
+	body := migrationBody{
+		GlobalID:  globalID,
+		Version:   migrationVersion,
+		Timestamp: time.Now().Unix(),
+		SenderKey: identity.Public(),
+		Session:   state,
+	}
+
+	unsigned, err := gobEncode(&body)
+	if err != nil {
+		return nil, fmt.Errorf("export: %w", err)
+	}
+	body.Sig = identity.SignDetached(unsigned)
+
+	plain, err := gobEncode(&body)
+	if err != nil {
+		return nil, fmt.Errorf("export: %w", err)
+	}
+
+	return keys.CryptoBoxSeal(plain, recipient.X25519PublicKey()), nil
+}
+
+// Import reverses Export: it decrypts env under myKey, verifies the
+// envelope's signature against its embedded sender key, rejects a
+// timestamp outside migrationWindow as a possible replay, and returns the
+// live Session, still carrying its original LocalUUID, ready to hand to
+// DiskSessionManager.Put.
+func Import(env []byte, myKey *keys.EdX25519Key) (*Session, error) {
+	plain, err := keys.CryptoBoxSealOpen(env, myKey.X25519Key())
+	if err != nil {
+		return nil, fmt.Errorf("import: open envelope: %w", err)
+	}
+
+	var body migrationBody
+	if err := gobDecode(plain, &body); err != nil {
+		return nil, fmt.Errorf("import: decode envelope: %w", err)
+	}
+	if body.Version != migrationVersion {
+		return nil, fmt.Errorf("import: unsupported envelope version %d", body.Version)
+	}
+
+	sender := keys.NewEdX25519PublicKey(keys.Bytes32(body.SenderKey))
+	sig := body.Sig
+	body.Sig = nil
+	unsigned, err := gobEncode(&body)
+	if err != nil {
+		return nil, fmt.Errorf("import: %w", err)
+	}
+	if err := sender.VerifyDetached(sig, unsigned); err != nil {
+		return nil, fmt.Errorf("import: bad signature: %w", err)
+	}
+
+	if age := time.Since(time.Unix(body.Timestamp, 0)); age > migrationWindow || age < -migrationWindow {
+		return nil, fmt.Errorf("import: envelope is outside the %s replay window", migrationWindow)
+	}
+
+	sess := &Session{}
+	if err := sess.UnmarshalBinary(body.Session); err != nil {
+		return nil, fmt.Errorf("import: unmarshal session: %w", err)
+	}
+	if !sess.Active() {
+		sess.IdentityKey = myKey.Private()
+	}
+
+	return sess, nil
+}
+
+// Export produces a migration envelope for the session named id, signed by
+// sm's identity. Unlike the package-level Export, it needs no caller setup:
+// it supplies sm.key itself, including for an already-established session
+// whose IdentityKey was dropped by Get.
+func (sm *DiskSessionManager) Export(id ulid.ULID, recipient *keys.EdX25519PublicKey) ([]byte, error) {
+	sess, err := sm.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("export: %w", err)
+	}
+	sess.IdentityKey = sm.key.Private()
+
+	return Export(sess, recipient)
+}
+
+// Import installs a migration envelope produced by Export, writing it to
+// disk under its original LocalUUID and name via Put. Put's fork check
+// applies, so an envelope describing an older ratchet state than what sm
+// already has for the same session is rejected rather than overwriting it.
+func (sm *DiskSessionManager) Import(env []byte) (*Session, error) {
+	sess, err := Import(env, sm.key)
+	if err != nil {
+		return nil, fmt.Errorf("import: %w", err)
+	}
+
+	if err := sm.Put(sess); err != nil {
+		return nil, fmt.Errorf("import: %w", err)
+	}
+	sm.Register(sess.Name, toULID(sess.LocalUUID))
+
+	return sess, nil
+}
+
+func gobEncode(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func gobDecode(b []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
@@ -0,0 +1,417 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package mux layers smux-style logical streams over a single byte-oriented
+// connection such as a session.Conn, the way Cloak multiplexes many logical
+// conversations over one obfuscated transport. A single X3DH+Double-Ratchet
+// handshake can then carry many concurrent conversations (file transfer,
+// chat, presence) without paying the handshake cost per stream, and without
+// the transport ever seeing how many logical streams are in flight.
+//
+// Each multiplexed frame is {streamID uint32, flags uint8, length uint16,
+// payload}; Session reads and writes these frames directly against the
+// wrapped io.ReadWriteCloser, so the frames themselves become the plaintext
+// of whatever is carrying them (e.g. the dataMessage payload of an
+// underlying session.Conn). Flow control is a simple per-stream credit
+// scheme: each Stream starts with defaultWindow bytes of credit, consumed by
+// the sender and replenished by the receiver's WINDOW_UPDATE frames as it
+// drains its read buffer.
+package mux
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultWindow is the initial per-stream flow-control credit. Once a
+// Stream's reader has drained at least half of it back out of its buffer,
+// a WINDOW_UPDATE grants the peer the bytes drained since the last update.
+const defaultWindow = 64 * 1024 // 64 KiB
+
+// headerSize is streamID(4) + flags(1) + length(2).
+const headerSize = 7
+
+type flag uint8
+
+const (
+	flagSYN flag = 1 << iota // opens a new stream; may carry the first chunk of data
+	flagFIN                  // peer is done sending on this stream
+	flagRST                  // abort the stream; nothing further should be sent or read
+	flagWUP                  // WINDOW_UPDATE; payload is a 4-byte big-endian credit increment
+)
+
+// ErrReset is returned by a Stream's Read and Write once the peer has sent
+// flagRST for it.
+var ErrReset = errors.New("mux: stream reset")
+
+// Session multiplexes logical Streams over rw. Callers on both ends of rw
+// must agree on which side passes client=true, since stream IDs are chosen
+// client-odd/server-even to avoid the two peers' independently-opened
+// streams colliding.
+type Session struct {
+	rw io.ReadWriteCloser
+
+	writeMu sync.Mutex
+
+	nextID uint32 // atomic; next call to Open consumes this value then advances it by 2
+
+	streamsMu sync.Mutex
+	streams   map[uint32]*Stream
+
+	acceptCh chan *Stream
+
+	closed    chan struct{}
+	closeOnce sync.Once
+	teardown  sync.Once
+	err       error
+	errMu     sync.Mutex
+}
+
+// NewSession wraps rw and starts its read loop. client selects the parity of
+// locally-opened stream IDs: true for odd (1, 3, 5, ...), false for even (2,
+// 4, 6, ...).
+func NewSession(rw io.ReadWriteCloser, client bool) *Session {
+	s := &Session{
+		rw:       rw,
+		streams:  make(map[uint32]*Stream),
+		acceptCh: make(chan *Stream, 16),
+		closed:   make(chan struct{}),
+	}
+	if client {
+		s.nextID = 1
+	} else {
+		s.nextID = 2
+	}
+
+	go s.readLoop()
+
+	return s
+}
+
+// Open starts a new stream and sends its SYN frame. It does not wait for the
+// peer to acknowledge: Stream's own flow control blocks Write if the peer
+// never grants it credit.
+func (s *Session) Open(ctx context.Context) (*Stream, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.closed:
+		return nil, s.closeErr()
+	default:
+	}
+
+	id := atomic.AddUint32(&s.nextID, 2) - 2
+
+	st := s.newStream(id)
+	s.streamsMu.Lock()
+	s.streams[id] = st
+	s.streamsMu.Unlock()
+
+	if err := s.writeFrame(id, flagSYN, nil); err != nil {
+		s.removeStream(id)
+		return nil, fmt.Errorf("mux: open stream %d: %w", id, err)
+	}
+	return st, nil
+}
+
+// AcceptStream blocks until the peer opens a stream, ctx is done, or the
+// Session closes.
+func (s *Session) AcceptStream(ctx context.Context) (*Stream, error) {
+	select {
+	case st, ok := <-s.acceptCh:
+		if !ok {
+			return nil, s.closeErr()
+		}
+		return st, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close closes the underlying connection and resets every open Stream.
+func (s *Session) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		err = s.rw.Close()
+		s.teardownSession(io.ErrClosedPipe)
+	})
+	return err
+}
+
+func (s *Session) newStream(id uint32) *Stream {
+	st := &Stream{
+		id:         id,
+		sess:       s,
+		sendWindow: defaultWindow,
+	}
+	st.readCond = sync.NewCond(&st.readMu)
+	st.sendWindowCond = sync.NewCond(&st.sendWindowMu)
+	return st
+}
+
+func (s *Session) getStream(id uint32) *Stream {
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	return s.streams[id]
+}
+
+func (s *Session) removeStream(id uint32) {
+	s.streamsMu.Lock()
+	delete(s.streams, id)
+	s.streamsMu.Unlock()
+}
+
+func (s *Session) readLoop() {
+	for {
+		var hdr [headerSize]byte
+		if _, err := io.ReadFull(s.rw, hdr[:]); err != nil {
+			s.teardownSession(err)
+			return
+		}
+
+		id := binary.BigEndian.Uint32(hdr[0:4])
+		fl := flag(hdr[4])
+		n := binary.BigEndian.Uint16(hdr[5:7])
+
+		var payload []byte
+		if n > 0 {
+			payload = make([]byte, n)
+			if _, err := io.ReadFull(s.rw, payload); err != nil {
+				s.teardownSession(err)
+				return
+			}
+		}
+
+		s.dispatch(id, fl, payload)
+	}
+}
+
+func (s *Session) dispatch(id uint32, fl flag, payload []byte) {
+	switch {
+	case fl&flagRST != 0:
+		if st := s.getStream(id); st != nil {
+			st.onReset()
+			s.removeStream(id)
+		}
+		return
+
+	case fl&flagWUP != 0:
+		if st := s.getStream(id); st != nil && len(payload) == 4 {
+			st.grantCredit(binary.BigEndian.Uint32(payload))
+		}
+		return
+
+	case fl&flagSYN != 0:
+		st := s.newStream(id)
+		s.streamsMu.Lock()
+		s.streams[id] = st
+		s.streamsMu.Unlock()
+
+		select {
+		case s.acceptCh <- st:
+		case <-s.closed:
+			return
+		}
+	}
+
+	st := s.getStream(id)
+	if st == nil {
+		return // unknown or already-closed stream: drop, as a TCP stack drops a segment for a closed socket
+	}
+	if len(payload) > 0 {
+		st.pushData(payload)
+	}
+	if fl&flagFIN != 0 {
+		st.onFin()
+	}
+}
+
+func (s *Session) writeFrame(id uint32, fl flag, payload []byte) error {
+	if len(payload) > math.MaxUint16 {
+		return fmt.Errorf("mux: frame payload of %d bytes exceeds max %d", len(payload), math.MaxUint16)
+	}
+
+	buf := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], id)
+	buf[4] = byte(fl)
+	binary.BigEndian.PutUint16(buf[5:7], uint16(len(payload)))
+	copy(buf[headerSize:], payload)
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, err := s.rw.Write(buf)
+	return err
+}
+
+func (s *Session) teardownSession(err error) {
+	s.teardown.Do(func() {
+		s.errMu.Lock()
+		s.err = err
+		s.errMu.Unlock()
+
+		close(s.closed)
+		close(s.acceptCh)
+
+		s.streamsMu.Lock()
+		for _, st := range s.streams {
+			st.onReset()
+		}
+		s.streamsMu.Unlock()
+	})
+}
+
+func (s *Session) closeErr() error {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	return io.EOF
+}
+
+// Stream is a single logical sub-channel multiplexed over a Session. It
+// implements io.ReadWriteCloser.
+type Stream struct {
+	id   uint32
+	sess *Session
+
+	readMu   sync.Mutex
+	readCond *sync.Cond
+	readBuf  bytes.Buffer
+	finRecv  bool
+	rstRecv  bool
+
+	recvWindowMu sync.Mutex
+	recvOwed     uint32 // bytes read out of readBuf since the last WINDOW_UPDATE we sent
+
+	sendWindowMu   sync.Mutex
+	sendWindowCond *sync.Cond
+	sendWindow     uint32
+
+	closeOnce sync.Once
+}
+
+// Read implements io.Reader, blocking until data, FIN, or RST arrives.
+func (st *Stream) Read(p []byte) (int, error) {
+	st.readMu.Lock()
+	for st.readBuf.Len() == 0 && !st.finRecv && !st.rstRecv {
+		st.readCond.Wait()
+	}
+
+	switch {
+	case st.rstRecv:
+		st.readMu.Unlock()
+		return 0, ErrReset
+	case st.readBuf.Len() == 0 && st.finRecv:
+		st.readMu.Unlock()
+		return 0, io.EOF
+	}
+
+	n, _ := st.readBuf.Read(p)
+	st.readMu.Unlock()
+
+	st.creditRead(n)
+	return n, nil
+}
+
+// Write implements io.Writer, blocking on the stream's flow-control window
+// and splitting p across as many frames as its size and the peer's granted
+// credit require.
+func (st *Stream) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		st.sendWindowMu.Lock()
+		for st.sendWindow == 0 && !st.isReset() {
+			st.sendWindowCond.Wait()
+		}
+		if st.isReset() {
+			st.sendWindowMu.Unlock()
+			return written, ErrReset
+		}
+
+		chunk := len(p) - written
+		if uint32(chunk) > st.sendWindow {
+			chunk = int(st.sendWindow)
+		}
+		if chunk > math.MaxUint16 {
+			chunk = math.MaxUint16
+		}
+		st.sendWindow -= uint32(chunk)
+		st.sendWindowMu.Unlock()
+
+		if err := st.sess.writeFrame(st.id, 0, p[written:written+chunk]); err != nil {
+			return written, fmt.Errorf("mux: write stream %d: %w", st.id, err)
+		}
+		written += chunk
+	}
+	return written, nil
+}
+
+// Close sends FIN and removes the stream from its Session. It does not wait
+// for the peer's own FIN: this is a full close, not TCP-style half-close.
+func (st *Stream) Close() error {
+	var err error
+	st.closeOnce.Do(func() {
+		err = st.sess.writeFrame(st.id, flagFIN, nil)
+		st.sess.removeStream(st.id)
+	})
+	return err
+}
+
+func (st *Stream) pushData(b []byte) {
+	st.readMu.Lock()
+	st.readBuf.Write(b)
+	st.readMu.Unlock()
+	st.readCond.Broadcast()
+}
+
+func (st *Stream) isReset() bool {
+	st.readMu.Lock()
+	defer st.readMu.Unlock()
+	return st.rstRecv
+}
+
+func (st *Stream) onFin() {
+	st.readMu.Lock()
+	st.finRecv = true
+	st.readMu.Unlock()
+	st.readCond.Broadcast()
+}
+
+func (st *Stream) onReset() {
+	st.readMu.Lock()
+	st.rstRecv = true
+	st.readMu.Unlock()
+	st.readCond.Broadcast()
+	st.sendWindowCond.Broadcast()
+}
+
+func (st *Stream) creditRead(n int) {
+	st.recvWindowMu.Lock()
+	st.recvOwed += uint32(n)
+	owed := st.recvOwed
+	if owed < defaultWindow/2 {
+		st.recvWindowMu.Unlock()
+		return
+	}
+	st.recvOwed = 0
+	st.recvWindowMu.Unlock()
+
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], owed)
+	_ = st.sess.writeFrame(st.id, flagWUP, buf[:])
+}
+
+func (st *Stream) grantCredit(n uint32) {
+	st.sendWindowMu.Lock()
+	st.sendWindow += n
+	st.sendWindowMu.Unlock()
+	st.sendWindowCond.Broadcast()
+}
@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package mux_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"go.salty.im/ratchet/session/mux"
+)
+
+func TestMuxOpenAcceptRoundTrip(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	client := mux.NewSession(c1, true)
+	server := mux.NewSession(c2, false)
+
+	serverAccepted := make(chan *mux.Stream, 1)
+	go func() {
+		st, err := server.AcceptStream(ctx)
+		is.NoErr(err)
+		serverAccepted <- st
+	}()
+
+	clientStream, err := client.Open(ctx)
+	is.NoErr(err)
+
+	serverStream := <-serverAccepted
+
+	_, err = clientStream.Write([]byte("hello stream"))
+	is.NoErr(err)
+
+	buf := make([]byte, 64)
+	n, err := serverStream.Read(buf)
+	is.NoErr(err)
+	is.Equal(string(buf[:n]), "hello stream")
+}
+
+func TestMuxConcurrentStreamsDontCross(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	client := mux.NewSession(c1, true)
+	server := mux.NewSession(c2, false)
+
+	const n = 3
+	accepted := make(chan *mux.Stream, n)
+	go func() {
+		for i := 0; i < n; i++ {
+			st, err := server.AcceptStream(ctx)
+			is.NoErr(err)
+			accepted <- st
+		}
+	}()
+
+	clientStreams := make([]*mux.Stream, n)
+	for i := 0; i < n; i++ {
+		st, err := client.Open(ctx)
+		is.NoErr(err)
+		clientStreams[i] = st
+	}
+
+	for i, st := range clientStreams {
+		_, err := st.Write([]byte{byte('a' + i)})
+		is.NoErr(err)
+	}
+
+	seen := map[byte]bool{}
+	for i := 0; i < n; i++ {
+		st := <-accepted
+		buf := make([]byte, 1)
+		_, err := st.Read(buf)
+		is.NoErr(err)
+		seen[buf[0]] = true
+	}
+	is.Equal(len(seen), n)
+}
+
+func TestMuxCloseSendsFin(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	client := mux.NewSession(c1, true)
+	server := mux.NewSession(c2, false)
+
+	serverAccepted := make(chan *mux.Stream, 1)
+	go func() {
+		st, err := server.AcceptStream(ctx)
+		is.NoErr(err)
+		serverAccepted <- st
+	}()
+
+	clientStream, err := client.Open(ctx)
+	is.NoErr(err)
+	serverStream := <-serverAccepted
+
+	is.NoErr(clientStream.Close())
+
+	_, err = serverStream.Read(make([]byte, 1))
+	is.Equal(err, io.EOF)
+}
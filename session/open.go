@@ -0,0 +1,39 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package session
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/keys-pub/keys"
+	"github.com/redis/go-redis/v9"
+)
+
+// Open selects a Manager backend from store, a URI such as
+// redis://host:6379/0, defaulting to a DiskSessionManager rooted at path
+// when store is empty. This is what ratchet's --store flag wires up: the
+// file store stays the default so existing invocations are unaffected, and
+// a redis:// store lets more than one process (an interactive TUI, a
+// send/recv script, a daemon) share session state.
+func Open(store, path, me string, key *keys.EdX25519Key) (Manager, func() error, error) {
+	if store == "" {
+		return NewSessionManager(path, me, key)
+	}
+
+	u, err := url.Parse(store)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse store %q: %w", store, err)
+	}
+
+	switch u.Scheme {
+	case "redis":
+		opts, err := redis.ParseURL(store)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parse store %q: %w", store, err)
+		}
+		return NewRedisSessionManager(redis.NewClient(opts), me, key)
+	default:
+		return nil, nil, fmt.Errorf("unsupported store scheme %q", u.Scheme)
+	}
+}
@@ -0,0 +1,241 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/keys-pub/keys"
+	"github.com/oklog/ulid/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisSessionManager is a Manager backed by a shared Redis instance instead
+// of a local file or database/sql handle: unlike DiskSessionManager and
+// SQLSessionManager, its state is meant to be reached by more than one
+// ratchet process (an interactive TUI, a script piping through send/recv, a
+// daemon) at once, so every mutation is written through immediately and
+// there is nothing to Load or flush on Close.
+//
+// Keys are namespaced per local address so one Redis instance can serve
+// several identities: a session's blob lives at ratchet:{me}:sess:{ulid},
+// and ratchet:{me}:name:{addr} indexes it by peer address. Unlike
+// DiskSessionManager, RedisSessionManager does not encrypt blobs at rest
+// (SQLSessionManager doesn't either) — callers who need that should put
+// Redis behind TLS and ACLs rather than relying on an application-level
+// envelope here.
+type RedisSessionManager struct {
+	rdb *redis.Client
+	me  string
+	key *keys.EdX25519Key
+}
+
+func sessKey(me string, id ulid.ULID) string { return fmt.Sprintf("ratchet:%s:sess:%s", me, id) }
+func nameKey(me, name string) string         { return fmt.Sprintf("ratchet:%s:name:%s", me, name) }
+func deviceIDKey(me string) string           { return fmt.Sprintf("ratchet:%s:device-id", me) }
+func positionKey(me string) string           { return fmt.Sprintf("ratchet:%s:position", me) }
+func devicesKey(me string) string            { return fmt.Sprintf("ratchet:%s:devices", me) }
+
+// NewRedisSessionManager scopes rdb to me, allocating and persisting a
+// device id the first time this address is seen. The caller owns rdb and is
+// responsible for closing it; RedisSessionManager.Close does not close rdb.
+func NewRedisSessionManager(rdb *redis.Client, me string, key *keys.EdX25519Key) (*RedisSessionManager, func() error, error) {
+	sm := &RedisSessionManager{rdb: rdb, me: me, key: key}
+
+	ctx := context.Background()
+	ok, err := rdb.SetNX(ctx, deviceIDKey(me), ulid.Make().String(), 0).Result()
+	if err != nil {
+		return nil, nil, fmt.Errorf("init device id: %w", err)
+	}
+	_ = ok // lost the race with another process; whichever value won is fine
+
+	return sm, sm.Close, nil
+}
+
+func (sm *RedisSessionManager) Identity() *keys.EdX25519Key { return sm.key }
+
+func (sm *RedisSessionManager) DeviceID() string {
+	id, _ := sm.rdb.Get(context.Background(), deviceIDKey(sm.me)).Result()
+	return id
+}
+
+func (sm *RedisSessionManager) Peers() []Device {
+	ctx := context.Background()
+	m, err := sm.rdb.HGetAll(ctx, devicesKey(sm.me)).Result()
+	if err != nil {
+		return nil
+	}
+
+	lis := make([]Device, 0, len(m))
+	for id, b := range m {
+		var d Device
+		if err := unmarshalDevice([]byte(b), &d); err != nil {
+			continue
+		}
+		d.ID = id
+		lis = append(lis, d)
+	}
+	return lis
+}
+func (sm *RedisSessionManager) AddDevice(d Device) error {
+	b, err := marshalDevice(d)
+	if err != nil {
+		return err
+	}
+	return sm.rdb.HSet(context.Background(), devicesKey(sm.me), d.ID, b).Err()
+}
+
+func (sm *RedisSessionManager) Register(name string, id ulid.ULID) {
+	_ = sm.rdb.Set(context.Background(), nameKey(sm.me, name), id.String(), 0).Err()
+}
+func (sm *RedisSessionManager) ByName(name string) ulid.ULID {
+	ctx := context.Background()
+
+	idStr, err := sm.rdb.Get(ctx, nameKey(sm.me, name)).Result()
+	if err == nil {
+		if id, err := ulid.Parse(idStr); err == nil {
+			return id
+		}
+	}
+
+	id := ulid.Make()
+	sm.Register(name, id)
+	return id
+}
+func (sm *RedisSessionManager) New(them string) (*Session, error) {
+	id := sm.ByName(them)
+	addr, err := fetchKey(them)
+	if err != nil {
+		return nil, fmt.Errorf("fetching key for %s: %w", them, err)
+	}
+	return NewSession(id, sm.me, sm.DeviceID(), sm.key, them, addr), nil
+}
+func (sm *RedisSessionManager) Get(id ulid.ULID) (*Session, error) {
+	b, err := sm.rdb.Get(context.Background(), sessKey(sm.me, id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("get %s: %w", id, os.ErrNotExist)
+	} else if err != nil {
+		return nil, fmt.Errorf("get %s: %w", id, err)
+	}
+
+	sess := &Session{}
+	if err := sess.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+
+	// session only needs private key during initial handshake.
+	if !sess.Active() {
+		sess.IdentityKey = sm.key.Private()
+	}
+
+	return sess, nil
+}
+func (sm *RedisSessionManager) Put(sess *Session) error {
+	b, err := sess.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	id := toULID(sess.LocalUUID)
+	ctx := context.Background()
+	if err := sm.rdb.Set(ctx, sessKey(sm.me, id), b, 0).Err(); err != nil {
+		return fmt.Errorf("put %s: %w", id, err)
+	}
+
+	sm.Register(sess.Name, id)
+	return nil
+}
+
+// Delete removes sess's blob and its name index atomically via a pipeline,
+// so another process can't observe one deleted without the other.
+func (sm *RedisSessionManager) Delete(sess *Session) error {
+	id := toULID(sess.LocalUUID)
+	ctx := context.Background()
+
+	n, err := sm.rdb.Exists(ctx, sessKey(sm.me, id)).Result()
+	if err != nil {
+		return fmt.Errorf("delete %s: %w", id, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("delete %s: %w", id, ErrNotExist)
+	}
+
+	_, err = sm.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, sessKey(sm.me, id))
+		pipe.Del(ctx, nameKey(sm.me, sess.Name))
+		return nil
+	})
+	return err
+}
+func (sm *RedisSessionManager) Sessions() []Pair[string, ulid.ULID] {
+	ctx := context.Background()
+
+	var lis []Pair[string, ulid.ULID]
+	iter := sm.rdb.Scan(ctx, 0, nameKey(sm.me, "*"), 0).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		name := key[len(nameKey(sm.me, "")):]
+
+		idStr, err := sm.rdb.Get(ctx, key).Result()
+		if err != nil {
+			continue
+		}
+		id, err := ulid.Parse(idStr)
+		if err != nil {
+			continue
+		}
+		lis = append(lis, Pair[string, ulid.ULID]{name, id})
+	}
+	return lis
+}
+func (sm *RedisSessionManager) Position() int64 {
+	pos, err := sm.rdb.Get(context.Background(), positionKey(sm.me)).Int64()
+	if err != nil {
+		return -1
+	}
+	return pos
+}
+func (sm *RedisSessionManager) SetPosition(pos int64) {
+	_ = sm.rdb.Set(context.Background(), positionKey(sm.me), pos, 0).Err()
+}
+
+// Close releases nothing: the caller opened rdb and owns its lifetime.
+func (sm *RedisSessionManager) Close() error { return nil }
+
+var _ Manager = (*RedisSessionManager)(nil)
+
+// marshalDevice/unmarshalDevice store a Device's key as its keys.ID string
+// form, the same representation session_devices.key uses in sql-session.go.
+func marshalDevice(d Device) ([]byte, error) {
+	return json.Marshal(struct {
+		Key      string
+		Endpoint string
+	}{
+		Key:      d.Key.String(),
+		Endpoint: d.Endpoint,
+	})
+}
+func unmarshalDevice(b []byte, d *Device) error {
+	var o struct {
+		Key      string
+		Endpoint string
+	}
+	if err := json.Unmarshal(b, &o); err != nil {
+		return err
+	}
+
+	id, err := keys.ParseID(o.Key)
+	if err != nil {
+		return err
+	}
+	d.Key, err = keys.NewEdX25519PublicKeyFromID(id)
+	if err != nil {
+		return err
+	}
+	d.Endpoint = o.Endpoint
+	return nil
+}
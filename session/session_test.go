@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/foxcpp/go-mockdns"
@@ -95,6 +96,84 @@ func TestSessionManager(t *testing.T) {
 	is.Equal(len(sm.Sessions()), 0)
 }
 
+func TestDevicePeers(t *testing.T) {
+	is := is.New(t)
+
+	path, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	key := keys.GenerateEdX25519Key()
+	other := keys.GenerateEdX25519Key()
+
+	sm, close, err := session.NewSessionManager(path, "me@sour.is", key)
+	is.NoErr(err)
+
+	// No peers registered yet.
+	is.Equal(len(sm.Peers()), 0)
+
+	err = sm.AddDevice(session.Device{ID: "tablet", Key: other.PublicKey(), Endpoint: "https://ev.sour.is/inbox/tablet"})
+	is.NoErr(err)
+	is.Equal(len(sm.Peers()), 1)
+
+	is.NoErr(close())
+
+	// Devices persist across reopen.
+	sm, close, err = session.NewSessionManager(path, "me@sour.is", key)
+	is.NoErr(err)
+	defer is.NoErr(close())
+
+	peers := sm.Peers()
+	is.Equal(len(peers), 1)
+	is.Equal(peers[0].ID, "tablet")
+}
+
+// TestPutConcurrentSameSession drives many concurrent Put calls for the
+// same session, the one on-disk resource DiskSessionManager's locker
+// doesn't otherwise protect: Put's walLoad/walAppend/walCompact sequence
+// used to run outside sm.state.Use, so two racing writers could read the
+// same Seq and corrupt the WAL. It checks that no Put errors and that the
+// session still reads back afterwards.
+func TestPutConcurrentSameSession(t *testing.T) {
+	is := is.New(t)
+
+	path, err := os.MkdirTemp("", "")
+	is.NoErr(err)
+	defer os.RemoveAll(path)
+
+	key := keys.GenerateEdX25519Key()
+	sm, closeSM, err := session.NewSessionManager(path, "me@sour.is", key)
+	is.NoErr(err)
+	defer closeSM()
+
+	them, err := sm.New("bob@sour.is")
+	is.NoErr(err)
+	is.NoErr(sm.Put(them))
+
+	const writers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- sm.Put(them)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		is.NoErr(err)
+	}
+
+	got, err := sm.Get(sm.ByName("bob@sour.is"))
+	is.NoErr(err)
+	is.Equal(got.Name, "bob@sour.is")
+}
+
 type httpMock func(*http.Request) (*http.Response, error)
 
 func (fn httpMock) RoundTrip(r *http.Request) (*http.Response, error) {
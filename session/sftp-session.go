@@ -0,0 +1,457 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+
+	"github.com/keys-pub/keys"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"go.salty.im/ratchet/locker"
+)
+
+// Store is the byte-level persistence a Manager's session blobs are written
+// through: Get/Put/Delete operate on a single opaque key (sessionhash's
+// filename form), and List enumerates every key currently stored.
+// SFTPSessionManager is the only Manager built on a Store today, but the
+// interface exists so its remote backend can be swapped (or mocked in
+// tests) independently of the bookkeeping in sftpMeta.
+type Store interface {
+	Get(key string) ([]byte, error)
+	Put(key string, b []byte) error
+	Delete(key string) error
+	List() ([]string, error)
+	Close() error
+}
+
+// sftpStore is a Store backed by a directory on a remote host, reached over
+// SFTP, so a user can keep their ratchet state on a remote host and roam
+// between devices instead of being pinned to wherever DiskSessionManager's
+// local XDG path happens to be.
+type sftpStore struct {
+	client *sftp.Client
+	conn   *ssh.Client
+	dir    string
+}
+
+// dialSFTPStore opens an SSH connection to addr and an SFTP session over it,
+// rooted at dir (created if it doesn't already exist). The caller owns
+// config, including its host key verification policy.
+func dialSFTPStore(addr string, config *ssh.ClientConfig, dir string) (*sftpStore, error) {
+	conn, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: dial %s: %w", addr, err)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sftp: new client: %w", err)
+	}
+
+	if err := client.MkdirAll(dir); err != nil {
+		client.Close()
+		conn.Close()
+		return nil, fmt.Errorf("sftp: mkdir %s: %w", dir, err)
+	}
+
+	return &sftpStore{client: client, conn: conn, dir: dir}, nil
+}
+
+func (s *sftpStore) path(key string) string { return path.Join(s.dir, key) }
+
+func (s *sftpStore) Get(key string) ([]byte, error) {
+	f, err := s.client.Open(s.path(key))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+// Put writes via a .tmp sibling then Rename, the same write-temp+rename
+// shape DiskSessionManager.Rekey uses locally, so a write that dies partway
+// through a slow or dropped remote link never leaves a torn file at key's
+// real path.
+func (s *sftpStore) Put(key string, b []byte) error {
+	tmp := s.path(key) + ".tmp"
+
+	f, err := s.client.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("sftp: create %s: %w", key, err)
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("sftp: write %s: %w", key, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("sftp: close %s: %w", key, err)
+	}
+
+	if err := s.client.Rename(tmp, s.path(key)); err != nil {
+		return fmt.Errorf("sftp: rename %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *sftpStore) Delete(key string) error {
+	return s.client.Remove(s.path(key))
+}
+
+func (s *sftpStore) List() ([]string, error) {
+	entries, err := s.client.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: readdir %s: %w", s.dir, err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
+
+func (s *sftpStore) Close() error {
+	cerr := s.client.Close()
+	if err := s.conn.Close(); err != nil && cerr == nil {
+		cerr = err
+	}
+	return cerr
+}
+
+// sftpMeta is the mutable body of an SFTPSessionManager other than the
+// session blobs themselves, guarded by its own locker.Locked the same way
+// DiskSessionManager guards diskState: the name index, device list, and
+// position watermark are small, always read and written together, and
+// unrelated to the per-session locking below.
+type sftpMeta struct {
+	pos      int64
+	deviceID string
+	sessions map[string]ulid.ULID
+	devices  map[string]Device
+}
+
+const sftpMetaKey = "meta.json"
+
+// SFTPSessionManager is a Manager whose session blobs live on a remote host
+// via a Store instead of local disk, Redis, or database/sql — the intended
+// use is roaming one identity between several machines that all reach the
+// same SFTP server. Unlike DiskSessionManager's single state lock,
+// Get/Put/Delete's fetch → mutate → store round trip is serialised per
+// session-UUID via locks (a locker.Keyed), so Client.Use calls against
+// different peers don't queue behind one another waiting on the same remote
+// connection for sessions they don't touch; each still forms an atomic
+// critical section against that session's own remote blob.
+type SFTPSessionManager struct {
+	me  string
+	key *keys.EdX25519Key
+
+	store Store
+	locks *locker.Keyed[ulid.ULID, struct{}]
+	meta  *locker.Locked[*sftpMeta]
+}
+
+// NewSFTPSessionManager dials addr over SSH using config, opens an SFTP
+// session rooted at dir, and scopes it to me, allocating a device id the
+// first time this address is seen.
+func NewSFTPSessionManager(addr string, config *ssh.ClientConfig, dir, me string, key *keys.EdX25519Key) (*SFTPSessionManager, func() error, error) {
+	store, err := dialSFTPStore(addr, config, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newSFTPSessionManager(store, me, key)
+}
+
+// newSFTPSessionManager is split out from NewSFTPSessionManager so tests can
+// supply a fake Store without dialing a real SSH server.
+func newSFTPSessionManager(store Store, me string, key *keys.EdX25519Key) (*SFTPSessionManager, func() error, error) {
+	sm := &SFTPSessionManager{
+		me:    me,
+		key:   key,
+		store: store,
+		locks: locker.NewKeyed[ulid.ULID](func() struct{} { return struct{}{} }),
+		meta: locker.New(&sftpMeta{
+			pos:      -1,
+			sessions: make(map[string]ulid.ULID),
+			devices:  make(map[string]Device),
+		}),
+	}
+
+	if err := sm.loadMeta(); err != nil {
+		store.Close()
+		return nil, nil, err
+	}
+
+	_ = sm.meta.Use(context.Background(), func(_ context.Context, s *sftpMeta) error {
+		if s.deviceID == "" {
+			s.deviceID = ulid.Make().String()
+		}
+		return nil
+	})
+
+	return sm, sm.Close, nil
+}
+
+func (sm *SFTPSessionManager) loadMeta() error {
+	b, err := sm.store.Get(sftpMetaKey)
+	if err != nil {
+		return nil // fresh remote directory: nothing to load yet
+	}
+
+	var data struct {
+		Position int64
+		DeviceID string
+		Sessions []Pair[string, ulid.ULID]
+		Devices  []struct {
+			ID       string
+			Key      string
+			Endpoint string
+		}
+	}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return fmt.Errorf("sftp: unmarshal %s: %w", sftpMetaKey, err)
+	}
+
+	return sm.meta.Use(context.Background(), func(_ context.Context, s *sftpMeta) error {
+		if data.Position > 0 {
+			s.pos = data.Position
+		}
+		s.deviceID = data.DeviceID
+		for _, v := range data.Sessions {
+			s.sessions[v.Name] = v.ID
+		}
+		for _, v := range data.Devices {
+			id, err := keys.ParseID(v.Key)
+			if err != nil {
+				return fmt.Errorf("parse device key for %s: %w", v.ID, err)
+			}
+			k, err := keys.NewEdX25519PublicKeyFromID(id)
+			if err != nil {
+				return fmt.Errorf("parse device key for %s: %w", v.ID, err)
+			}
+			s.devices[v.ID] = Device{ID: v.ID, Key: k, Endpoint: v.Endpoint}
+		}
+		return nil
+	})
+}
+
+func (sm *SFTPSessionManager) saveMeta() error {
+	var data struct {
+		Position int64
+		DeviceID string
+		Sessions []Pair[string, ulid.ULID]
+		Devices  []struct {
+			ID       string
+			Key      string
+			Endpoint string
+		}
+	}
+
+	_ = sm.meta.Use(context.Background(), func(_ context.Context, s *sftpMeta) error {
+		data.Position = s.pos
+		data.DeviceID = s.deviceID
+		for k, v := range s.sessions {
+			data.Sessions = append(data.Sessions, Pair[string, ulid.ULID]{Name: k, ID: v})
+		}
+		for _, d := range s.devices {
+			data.Devices = append(data.Devices, struct {
+				ID       string
+				Key      string
+				Endpoint string
+			}{d.ID, d.Key.String(), d.Endpoint})
+		}
+		return nil
+	})
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return sm.store.Put(sftpMetaKey, b)
+}
+
+func (sm *SFTPSessionManager) Identity() *keys.EdX25519Key { return sm.key }
+
+func (sm *SFTPSessionManager) DeviceID() string {
+	var id string
+	_ = sm.meta.Use(context.Background(), func(_ context.Context, s *sftpMeta) error {
+		id = s.deviceID
+		return nil
+	})
+	return id
+}
+
+func (sm *SFTPSessionManager) Peers() []Device {
+	var lis []Device
+	_ = sm.meta.Use(context.Background(), func(_ context.Context, s *sftpMeta) error {
+		lis = make([]Device, 0, len(s.devices))
+		for _, d := range s.devices {
+			lis = append(lis, d)
+		}
+		return nil
+	})
+	return lis
+}
+
+func (sm *SFTPSessionManager) AddDevice(d Device) error {
+	_ = sm.meta.Use(context.Background(), func(_ context.Context, s *sftpMeta) error {
+		s.devices[d.ID] = d
+		return nil
+	})
+	return sm.saveMeta()
+}
+
+func (sm *SFTPSessionManager) Register(name string, id ulid.ULID) {
+	_ = sm.meta.Use(context.Background(), func(_ context.Context, s *sftpMeta) error {
+		s.sessions[name] = id
+		return nil
+	})
+	_ = sm.saveMeta()
+}
+
+func (sm *SFTPSessionManager) ByName(name string) ulid.ULID {
+	var id ulid.ULID
+	isNew := false
+	_ = sm.meta.Use(context.Background(), func(_ context.Context, s *sftpMeta) error {
+		if u, ok := s.sessions[name]; ok {
+			id = u
+			return nil
+		}
+		id = ulid.Make()
+		s.sessions[name] = id
+		isNew = true
+		return nil
+	})
+	if isNew {
+		_ = sm.saveMeta()
+	}
+	return id
+}
+
+func (sm *SFTPSessionManager) New(them string) (*Session, error) {
+	id := sm.ByName(them)
+	addr, err := fetchKey(them)
+	if err != nil {
+		return nil, fmt.Errorf("fetching key for %s: %w", them, err)
+	}
+	return NewSession(id, sm.me, sm.DeviceID(), sm.key, them, addr), nil
+}
+
+// Get fetches and unmarshals sess's blob, with the fetch serialised against
+// any concurrent Put/Delete for the same id via locks — but not against
+// Get/Put/Delete for any other session, which proceed against the remote
+// store without waiting.
+func (sm *SFTPSessionManager) Get(id ulid.ULID) (*Session, error) {
+	var sess *Session
+
+	err := sm.locks.Use(context.Background(), id, func(_ context.Context, _ struct{}) error {
+		b, err := sm.store.Get(sessionhash(sm.me, id))
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return fs.ErrNotExist
+			}
+			return err
+		}
+
+		sess = &Session{}
+		return sess.UnmarshalBinary(b)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", id, err)
+	}
+
+	// session only needs private key during initial handshake.
+	if !sess.Active() {
+		sess.IdentityKey = sm.key.Private()
+	}
+	return sess, nil
+}
+
+func (sm *SFTPSessionManager) Put(sess *Session) error {
+	id := toULID(sess.LocalUUID)
+
+	err := sm.locks.Use(context.Background(), id, func(_ context.Context, _ struct{}) error {
+		b, err := sess.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		return sm.store.Put(sessionhash(sm.me, id), b)
+	})
+	if err != nil {
+		return fmt.Errorf("put %s: %w", id, err)
+	}
+
+	_ = sm.meta.Use(context.Background(), func(_ context.Context, s *sftpMeta) error {
+		s.sessions[sess.Name] = id
+		return nil
+	})
+	return sm.saveMeta()
+}
+
+func (sm *SFTPSessionManager) Delete(sess *Session) error {
+	id := toULID(sess.LocalUUID)
+
+	err := sm.locks.Use(context.Background(), id, func(_ context.Context, _ struct{}) error {
+		return sm.store.Delete(sessionhash(sm.me, id))
+	})
+	if err != nil {
+		return fmt.Errorf("delete %s: %w", id, err)
+	}
+
+	_ = sm.meta.Use(context.Background(), func(_ context.Context, s *sftpMeta) error {
+		delete(s.sessions, sess.Name)
+		return nil
+	})
+	return sm.saveMeta()
+}
+
+func (sm *SFTPSessionManager) Sessions() []Pair[string, ulid.ULID] {
+	var lis []Pair[string, ulid.ULID]
+	_ = sm.meta.Use(context.Background(), func(_ context.Context, s *sftpMeta) error {
+		lis = make([]Pair[string, ulid.ULID], 0, len(s.sessions))
+		for k, v := range s.sessions {
+			lis = append(lis, Pair[string, ulid.ULID]{Name: k, ID: v})
+		}
+		return nil
+	})
+	return lis
+}
+
+func (sm *SFTPSessionManager) Position() int64 {
+	var pos int64
+	_ = sm.meta.Use(context.Background(), func(_ context.Context, s *sftpMeta) error {
+		pos = s.pos
+		return nil
+	})
+	return pos
+}
+
+func (sm *SFTPSessionManager) SetPosition(pos int64) {
+	_ = sm.meta.Use(context.Background(), func(_ context.Context, s *sftpMeta) error {
+		s.pos = pos
+		return nil
+	})
+	_ = sm.saveMeta()
+}
+
+// Close closes the underlying Store (and, for an SFTPSessionManager dialed
+// via NewSFTPSessionManager, its SSH connection). Every mutation is already
+// written through via saveMeta/Put, so there is nothing left to flush.
+func (sm *SFTPSessionManager) Close() error {
+	return sm.store.Close()
+}
+
+var _ Manager = (*SFTPSessionManager)(nil)
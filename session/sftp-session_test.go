@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+// This file lives in package session (not session_test) so it can drive
+// newSFTPSessionManager directly with a fakeStore, instead of dialing a
+// real SSH/SFTP server.
+package session
+
+import (
+	"io/fs"
+	"sync"
+	"testing"
+
+	"github.com/keys-pub/keys"
+	"github.com/matryer/is"
+	"github.com/oklog/ulid/v2"
+	"go.salty.im/ratchet/xochimilco"
+)
+
+// fakeStore is an in-memory Store, standing in for a real SFTP connection.
+type fakeStore struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func newFakeStore() *fakeStore { return &fakeStore{data: make(map[string][]byte)} }
+
+func (f *fakeStore) Get(key string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, ok := f.data[key]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return b, nil
+}
+func (f *fakeStore) Put(key string, b []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.data[key] = b
+	return nil
+}
+func (f *fakeStore) Delete(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.data, key)
+	return nil
+}
+func (f *fakeStore) List() ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.data))
+	for k := range f.data {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+func (f *fakeStore) Close() error { return nil }
+
+func TestSFTPSessionManager(t *testing.T) {
+	is := is.New(t)
+
+	key := keys.GenerateEdX25519Key()
+
+	sm, closeSM, err := newSFTPSessionManager(newFakeStore(), "me@sour.is", key)
+	is.NoErr(err)
+	defer is.NoErr(closeSM())
+
+	is.Equal(len(sm.Sessions()), 0)
+
+	bobKey := keys.GenerateEdX25519Key()
+	id := ulid.Make()
+	them := &Session{
+		Name:     "bob@sour.is",
+		PeerKey:  bobKey.PublicKey(),
+		Endpoint: "https://example.test/inbox",
+		Session: &xochimilco.Session{
+			IdentityKey: key.Private(),
+			Me:          "me@sour.is",
+			LocalUUID:   id[:],
+		},
+	}
+	them.SetPeerKey("bob@sour.is", bobKey.Public())
+	is.NoErr(sm.Put(them))
+	is.Equal(len(sm.Sessions()), 1)
+
+	got, err := sm.Get(sm.ByName("bob@sour.is"))
+	is.NoErr(err)
+	is.Equal(got.Name, "bob@sour.is")
+
+	is.NoErr(sm.Delete(got))
+	is.Equal(len(sm.Sessions()), 0)
+
+	_, err = sm.Get(id)
+	is.True(err != nil)
+}
+
+func TestSFTPSessionManagerPersistsMeta(t *testing.T) {
+	is := is.New(t)
+
+	key := keys.GenerateEdX25519Key()
+	store := newFakeStore()
+
+	sm, closeSM, err := newSFTPSessionManager(store, "me@sour.is", key)
+	is.NoErr(err)
+	sm.SetPosition(42)
+	is.NoErr(closeSM())
+
+	sm2, closeSM2, err := newSFTPSessionManager(store, "me@sour.is", key)
+	is.NoErr(err)
+	defer is.NoErr(closeSM2())
+
+	is.Equal(sm2.DeviceID(), sm.DeviceID())
+	is.Equal(sm2.Position(), int64(42))
+}
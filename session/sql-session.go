@@ -0,0 +1,246 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package session
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/keys-pub/keys"
+	"github.com/oklog/ulid/v2"
+)
+
+// sqlSchema is driver-agnostic SQL, exercised in this repo against
+// modernc.org/sqlite. ON CONFLICT upserts are standard SQLite/Postgres
+// syntax, but SQLSessionManager's queries use ? placeholders, which
+// Postgres drivers do not accept.
+const sqlSchema = `
+CREATE TABLE IF NOT EXISTS session_meta (
+	me        TEXT PRIMARY KEY,
+	device_id TEXT NOT NULL,
+	position  INTEGER NOT NULL DEFAULT -1
+);
+CREATE TABLE IF NOT EXISTS session_peers (
+	me   TEXT NOT NULL,
+	name TEXT NOT NULL,
+	id   TEXT NOT NULL,
+	PRIMARY KEY (me, name)
+);
+CREATE TABLE IF NOT EXISTS session_data (
+	me   TEXT NOT NULL,
+	id   TEXT NOT NULL,
+	data BLOB NOT NULL,
+	PRIMARY KEY (me, id)
+);
+CREATE TABLE IF NOT EXISTS session_devices (
+	me       TEXT NOT NULL,
+	id       TEXT NOT NULL,
+	key      TEXT NOT NULL,
+	endpoint TEXT NOT NULL,
+	PRIMARY KEY (me, id)
+);
+`
+
+// SQLSessionManager is a Manager backed by a database/sql handle. Unlike
+// DiskSessionManager, which keeps the session index in memory and flushes
+// it to sess-$me.json on Close, SQLSessionManager writes each mutation
+// through immediately: Close only releases the *sql.DB, it does not
+// persist anything. The database can be shared by more than one identity;
+// every row is scoped by the me column.
+//
+// The id column stores the ulid in its canonical string form rather than
+// DiskSessionManager's fnv128a filename hash: a SQL backend doesn't need
+// to hide session ids behind obfuscated filenames, and a plain ulid string
+// is directly indexable and sortable.
+type SQLSessionManager struct {
+	db  *sql.DB
+	me  string
+	key *keys.EdX25519Key
+}
+
+// NewSQLSessionManager opens (creating if necessary) the session tables in
+// db and returns a Manager scoped to me. The caller owns db and is
+// responsible for closing it; SQLSessionManager.Close does not close db.
+func NewSQLSessionManager(db *sql.DB, me string, key *keys.EdX25519Key) (*SQLSessionManager, func() error, error) {
+	if _, err := db.Exec(sqlSchema); err != nil {
+		return nil, nil, fmt.Errorf("create session schema: %w", err)
+	}
+
+	sm := &SQLSessionManager{db: db, me: me, key: key}
+
+	var deviceID string
+	err := db.QueryRow(`SELECT device_id FROM session_meta WHERE me = ?`, me).Scan(&deviceID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		deviceID = ulid.Make().String()
+		_, err = db.Exec(`INSERT INTO session_meta (me, device_id, position) VALUES (?, ?, -1)`, me, deviceID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("init session meta: %w", err)
+		}
+	case err != nil:
+		return nil, nil, fmt.Errorf("load session meta: %w", err)
+	}
+
+	return sm, sm.Close, nil
+}
+
+func (sm *SQLSessionManager) Identity() *keys.EdX25519Key { return sm.key }
+func (sm *SQLSessionManager) DeviceID() string {
+	var deviceID string
+	// Errors here would mean the row inserted by NewSQLSessionManager
+	// disappeared out from under us; there is no sane fallback.
+	_ = sm.db.QueryRow(`SELECT device_id FROM session_meta WHERE me = ?`, sm.me).Scan(&deviceID)
+	return deviceID
+}
+
+func (sm *SQLSessionManager) Peers() []Device {
+	rows, err := sm.db.Query(`SELECT id, key, endpoint FROM session_devices WHERE me = ?`, sm.me)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var lis []Device
+	for rows.Next() {
+		var d Device
+		var keyID string
+		if err := rows.Scan(&d.ID, &keyID, &d.Endpoint); err != nil {
+			return nil
+		}
+		id, err := keys.ParseID(keyID)
+		if err != nil {
+			return nil
+		}
+		d.Key, err = keys.NewEdX25519PublicKeyFromID(id)
+		if err != nil {
+			return nil
+		}
+		lis = append(lis, d)
+	}
+	return lis
+}
+func (sm *SQLSessionManager) AddDevice(d Device) error {
+	_, err := sm.db.Exec(`
+		INSERT INTO session_devices (me, id, key, endpoint) VALUES (?, ?, ?, ?)
+		ON CONFLICT (me, id) DO UPDATE SET key = excluded.key, endpoint = excluded.endpoint
+	`, sm.me, d.ID, d.Key.String(), d.Endpoint)
+	return err
+}
+
+func (sm *SQLSessionManager) Register(name string, id ulid.ULID) {
+	_, _ = sm.db.Exec(`
+		INSERT INTO session_peers (me, name, id) VALUES (?, ?, ?)
+		ON CONFLICT (me, name) DO UPDATE SET id = excluded.id
+	`, sm.me, name, id.String())
+}
+func (sm *SQLSessionManager) ByName(name string) ulid.ULID {
+	var idStr string
+	err := sm.db.QueryRow(`SELECT id FROM session_peers WHERE me = ? AND name = ?`, sm.me, name).Scan(&idStr)
+	if err == nil {
+		id, err := ulid.Parse(idStr)
+		if err == nil {
+			return id
+		}
+	}
+
+	id := ulid.Make()
+	sm.Register(name, id)
+	return id
+}
+func (sm *SQLSessionManager) New(them string) (*Session, error) {
+	id := sm.ByName(them)
+	addr, err := fetchKey(them)
+	if err != nil {
+		return nil, fmt.Errorf("fetching key for %s: %w", them, err)
+	}
+	return NewSession(id, sm.me, sm.DeviceID(), sm.key, them, addr), nil
+}
+func (sm *SQLSessionManager) Get(id ulid.ULID) (*Session, error) {
+	var b []byte
+	err := sm.db.QueryRow(`SELECT data FROM session_data WHERE me = ? AND id = ?`, sm.me, id.String()).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("get %s: %w", id, os.ErrNotExist)
+	} else if err != nil {
+		return nil, fmt.Errorf("get %s: %w", id, err)
+	}
+
+	sess := &Session{}
+	if err := sess.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+
+	// session only needs private key during initial handshake.
+	if !sess.Active() {
+		sess.IdentityKey = sm.key.Private()
+	}
+
+	return sess, nil
+}
+func (sm *SQLSessionManager) Put(sess *Session) error {
+	b, err := sess.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	id := toULID(sess.LocalUUID)
+	_, err = sm.db.Exec(`
+		INSERT INTO session_data (me, id, data) VALUES (?, ?, ?)
+		ON CONFLICT (me, id) DO UPDATE SET data = excluded.data
+	`, sm.me, id.String(), b)
+	if err != nil {
+		return fmt.Errorf("put %s: %w", id, err)
+	}
+
+	sm.Register(sess.Name, id)
+	return nil
+}
+func (sm *SQLSessionManager) Delete(sess *Session) error {
+	id := toULID(sess.LocalUUID)
+
+	res, err := sm.db.Exec(`DELETE FROM session_data WHERE me = ? AND id = ?`, sm.me, id.String())
+	if err != nil {
+		return fmt.Errorf("delete %s: %w", id, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("delete %s: %w", id, ErrNotExist)
+	}
+
+	_, err = sm.db.Exec(`DELETE FROM session_peers WHERE me = ? AND name = ?`, sm.me, sess.Name)
+	return err
+}
+func (sm *SQLSessionManager) Sessions() []Pair[string, ulid.ULID] {
+	rows, err := sm.db.Query(`SELECT name, id FROM session_peers WHERE me = ?`, sm.me)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var lis []Pair[string, ulid.ULID]
+	for rows.Next() {
+		var name, idStr string
+		if err := rows.Scan(&name, &idStr); err != nil {
+			return nil
+		}
+		id, err := ulid.Parse(idStr)
+		if err != nil {
+			continue
+		}
+		lis = append(lis, Pair[string, ulid.ULID]{name, id})
+	}
+	return lis
+}
+func (sm *SQLSessionManager) Position() int64 {
+	var pos int64 = -1
+	_ = sm.db.QueryRow(`SELECT position FROM session_meta WHERE me = ?`, sm.me).Scan(&pos)
+	return pos
+}
+func (sm *SQLSessionManager) SetPosition(pos int64) {
+	_, _ = sm.db.Exec(`UPDATE session_meta SET position = ? WHERE me = ?`, pos, sm.me)
+}
+
+// Close releases nothing: the caller opened db and owns its lifetime.
+func (sm *SQLSessionManager) Close() error { return nil }
+
+var _ Manager = (*SQLSessionManager)(nil)
@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package session
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+)
+
+// Op identifies why a walRecord was appended. It's informational only: Get
+// always trusts the last well-formed record in the file, regardless of Op.
+const (
+	walOpSnapshot    byte = iota // a compacted, self-contained fold of the log
+	walOpRatchetStep             // a normal Put after the ratchet advanced
+	walOpPendingAck              // a Put made while awaiting the handshake ack
+	walOpClose                   // reserved for a future close-as-tombstone record
+)
+
+// walCompactThreshold bounds how many records a session's WAL may grow to
+// before Get or Put folds it back into a single walOpSnapshot record.
+const walCompactThreshold = 64
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// walRecord is one entry in a session's write-ahead log. Seq is the
+// record's position in the log when it was appended (a compacted Snapshot
+// keeps the Seq of the record it was made from), and Payload is the
+// session's full gob-marshaled (and, if a KeyProvider is set, sealed) state
+// as of that append; it is not a binary diff against the previous record.
+type walRecord struct {
+	Seq     uint64
+	Op      byte
+	Payload []byte
+}
+
+// encodeWALRecord frames rec as [length uint32][crc32c uint32][seq uint64][op
+// byte][payload], so walLoad can find record boundaries and detect a torn
+// write without scanning the whole file.
+func encodeWALRecord(rec walRecord) []byte {
+	body := make([]byte, 8+1+len(rec.Payload))
+	binary.BigEndian.PutUint64(body[:8], rec.Seq)
+	body[8] = rec.Op
+	copy(body[9:], rec.Payload)
+
+	buf := make([]byte, 4+4+len(body))
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(body)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(body, crc32cTable))
+	copy(buf[8:], body)
+	return buf
+}
+
+// walAppend appends rec to filename, creating it if necessary. It does not
+// fsync; call walSync to force durability at a meaningful boundary.
+func walAppend(filename string, rec walRecord) error {
+	fp, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	_, err = fp.Write(encodeWALRecord(rec))
+	return err
+}
+
+// walCompact replaces filename's contents with a single walOpSnapshot
+// record carrying rec.Payload. Unlike walAppend, this does rewrite the
+// whole file, so callers only take this path once the log has grown past
+// walCompactThreshold records.
+func walCompact(filename string, rec walRecord) error {
+	rec.Op = walOpSnapshot
+
+	fp, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	_, err = fp.Write(encodeWALRecord(rec))
+	return err
+}
+
+// walLoad reads every well-formed record from filename in order. A length
+// prefix or payload that runs past EOF, or a record whose CRC32C doesn't
+// match, is treated as a torn write from a crash mid-append: walLoad stops
+// there and returns the records read so far rather than erroring.
+func walLoad(filename string) ([]walRecord, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var recs []walRecord
+	for len(b) >= 8 {
+		n := binary.BigEndian.Uint32(b[:4])
+		wantSum := binary.BigEndian.Uint32(b[4:8])
+
+		body := b[8:]
+		if uint64(len(body)) < uint64(n) {
+			break
+		}
+		body = body[:n]
+		if crc32.Checksum(body, crc32cTable) != wantSum {
+			break
+		}
+
+		recs = append(recs, walRecord{
+			Seq:     binary.BigEndian.Uint64(body[:8]),
+			Op:      body[8],
+			Payload: append([]byte(nil), body[9:]...),
+		})
+		b = b[8+n:]
+	}
+
+	return recs, nil
+}
+
+// walSync forces filename's contents to stable storage. Put itself never
+// does this, so a crash loses at most the appends since the last walSync
+// rather than corrupting anything already on disk.
+func walSync(filename string) error {
+	fp, err := os.OpenFile(filename, os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	return fp.Sync()
+}
@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+package sshd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+
+	"go.salty.im/ratchet/cli"
+)
+
+// IdentityResolver maps an incoming SSH public key to the Salty identity
+// (me@domain) it's allowed to host, by reading an authorized_keys-style
+// file where each line's trailing comment is that bound identity, e.g.:
+//
+//	ssh-ed25519 AAAA... jon@sour.is
+//
+// state is the same --state root ratchet's other subcommands use; each
+// bound identity must have a readable keyfile at state/<me>/identity.key.
+type IdentityResolver struct {
+	state         string
+	byFingerprint map[string]string
+}
+
+// LoadIdentityResolver reads keysFile and builds a resolver rooted at
+// state.
+func LoadIdentityResolver(keysFile, state string) (*IdentityResolver, error) {
+	b, err := os.ReadFile(keysFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", keysFile, err)
+	}
+
+	r := &IdentityResolver{state: state, byFingerprint: map[string]string{}}
+
+	for rest := b; len(rest) > 0; {
+		var pub ssh.PublicKey
+		var comment string
+		pub, comment, _, rest, err = ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", keysFile, err)
+		}
+		if comment == "" {
+			return nil, fmt.Errorf("parsing %s: key with no bound identity comment", keysFile)
+		}
+		r.byFingerprint[ssh.FingerprintSHA256(pub)] = comment
+	}
+
+	return r, nil
+}
+
+// Resolve looks up the Salty identity bound to pub and confirms its
+// keyfile still loads, returning the "me" address to run the session as.
+func (r *IdentityResolver) Resolve(pub ssh.PublicKey) (string, error) {
+	me, ok := r.byFingerprint[ssh.FingerprintSHA256(pub)]
+	if !ok {
+		return "", fmt.Errorf("no identity bound to key %s", ssh.FingerprintSHA256(pub))
+	}
+
+	if _, _, err := cli.ReadSaltyIdentity(filepath.Join(r.state, me, "identity.key")); err != nil {
+		return "", fmt.Errorf("identity %s: %w", me, err)
+	}
+
+	return me, nil
+}
@@ -0,0 +1,290 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package sshd hosts the same interactive chat loop ratchet's "chat"
+// subcommand runs locally, but over SSH: one connection per Salty
+// identity, authenticated by public key against an authorized_keys-style
+// file (see IdentityResolver), one readline prompt per session channel.
+package sshd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+
+	"go.salty.im/ratchet/cli"
+	"go.salty.im/ratchet/client"
+	"go.salty.im/ratchet/interactive"
+	rlog "go.salty.im/ratchet/log"
+	"go.salty.im/ratchet/roster"
+	"go.salty.im/ratchet/session"
+	"go.salty.im/ratchet/transport/msgbus"
+)
+
+// Server accepts SSH connections, resolving each client key to a Salty
+// identity via resolver and dropping it straight into interactive.Service.
+type Server struct {
+	config   *ssh.ServerConfig
+	resolver *IdentityResolver
+
+	store, state string
+	format       rlog.Format
+	transportOpt client.Option
+}
+
+// New builds a Server signing with hostKey, authenticating against
+// resolver, and opening sessions the same way ratchet's "chat" subcommand
+// does (store/state/format/transportOpt match its --store, --state,
+// --log-format and --transport options).
+func New(hostKey ssh.Signer, resolver *IdentityResolver, store, state string, format rlog.Format, transportOpt client.Option) *Server {
+	srv := &Server{
+		resolver:     resolver,
+		store:        store,
+		state:        state,
+		format:       format,
+		transportOpt: transportOpt,
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			me, err := resolver.Resolve(key)
+			if err != nil {
+				return nil, err
+			}
+			return &ssh.Permissions{Extensions: map[string]string{"me": me}}, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+	srv.config = config
+
+	return srv
+}
+
+// Serve accepts connections on l, handling each on its own goroutine, until
+// ctx is canceled or accepting fails -- same contract as daemon.Server.Serve.
+func (srv *Server) Serve(ctx context.Context, l net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+				return err
+			}
+		}
+		go srv.handleConn(ctx, conn)
+	}
+}
+
+func (srv *Server) handleConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, srv.config)
+	if err != nil {
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	me := sconn.Permissions.Extensions["me"]
+
+	_, key, err := cli.ReadSaltyIdentity(filepath.Join(srv.state, me, "identity.key"))
+	if err != nil {
+		return
+	}
+
+	sm, closeSM, err := session.Open(srv.store, filepath.Join(srv.state, me), me, key)
+	if err != nil {
+		return
+	}
+	defer closeSM()
+
+	c, err := client.New(sm, me, msgbus.WithMsgbus(sm.Position()), srv.transportOpt)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	c.BaseCTX = func() context.Context { return ctx }
+
+	go c.Run(ctx)
+
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+
+		ch, chReqs, err := newChan.Accept()
+		if err != nil {
+			continue
+		}
+
+		go srv.handleSession(ctx, c, me, ch, chReqs, cancel)
+	}
+}
+
+type ptyRequestMsg struct {
+	Term     string
+	Columns  uint32
+	Rows     uint32
+	Width    uint32
+	Height   uint32
+	Modelist string
+}
+
+type windowChangeMsg struct {
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+}
+
+// winsize tracks the terminal width readline reports against, updated by
+// window-change requests and read back through interactive.WithTerminalSize.
+type winsize struct {
+	mu       sync.Mutex
+	cols     int
+	onChange func()
+}
+
+func (w *winsize) set(cols int) {
+	w.mu.Lock()
+	w.cols = cols
+	onChange := w.onChange
+	w.mu.Unlock()
+
+	if onChange != nil {
+		onChange()
+	}
+}
+
+func (w *winsize) width() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cols == 0 {
+		return 80
+	}
+	return w.cols
+}
+
+func (w *winsize) setOnChange(cb func()) {
+	w.mu.Lock()
+	w.onChange = cb
+	w.mu.Unlock()
+}
+
+// handleSession services one SSH session channel: it honors pty-req,
+// window-change, shell and env requests (rejecting everything else), and
+// once a PTY has been requested and a shell started, drives
+// interactive.Service.Interactive directly over the channel -- not
+// Service.Run, since c.Run is already driven once per connection above and
+// a second driver would race it.
+func (srv *Server) handleSession(ctx context.Context, c *client.Client, me string, ch ssh.Channel, reqs <-chan *ssh.Request, cancel func()) {
+	defer ch.Close()
+
+	ws := &winsize{}
+	var havePTY bool
+	var started sync.Once
+
+	for req := range reqs {
+		switch req.Type {
+		case "pty-req":
+			var msg ptyRequestMsg
+			if err := ssh.Unmarshal(req.Payload, &msg); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			havePTY = true
+			ws.set(int(msg.Columns))
+			req.Reply(true, nil)
+
+		case "window-change":
+			var msg windowChangeMsg
+			if err := ssh.Unmarshal(req.Payload, &msg); err != nil {
+				continue
+			}
+			ws.set(int(msg.Columns))
+
+		case "shell":
+			if !havePTY {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+
+			started.Do(func() {
+				r, err := roster.Open(filepath.Join(srv.state, me, "roster.json"))
+				if err != nil {
+					r = nil
+				}
+
+				svc := interactive.New(c, srv.format,
+					interactive.WithIO(ch, ch),
+					interactive.WithTerminalSize(ws.width, ws.setOnChange),
+					interactive.WithHistoryFile(filepath.Join(srv.state, me, "history")),
+					interactive.WithRoster(r),
+				)
+				go func() {
+					svc.Interactive(ctx, me, "", cancel)
+					ch.Close()
+				}()
+			})
+
+		case "env":
+			req.Reply(true, nil)
+
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// LoadOrCreateHostKey reads an SSH host key from path, generating and
+// persisting a new ed25519 one on first run the same way client/blob.go
+// bootstraps its state directory.
+func LoadOrCreateHostKey(path string) (ssh.Signer, error) {
+	b, err := os.ReadFile(path)
+	if err == nil {
+		return ssh.ParsePrivateKey(b)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating host key: %w", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(priv, "ratchet sshd host key")
+	if err != nil {
+		return nil, fmt.Errorf("marshaling host key: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, err
+	}
+
+	return ssh.NewSignerFromKey(priv)
+}
@@ -0,0 +1,214 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"go.salty.im/ratchet/math"
+)
+
+// Bolt is an EventLog backed by a single BoltDB file, with one bucket per
+// stream. Each value is the record's append time (8 byte big endian unix
+// nano) followed by the caller's data, so Truncate can reap old entries
+// without decoding the payload.
+type Bolt struct {
+	db *bbolt.DB
+}
+
+// NewBolt opens (creating if necessary) a Bolt-backed EventLog at path.
+func NewBolt(path string) (*Bolt, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("store: open %s: %w", path, err)
+	}
+	return &Bolt{db: db}, nil
+}
+
+// Close releases the underlying BoltDB file.
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+func (b *Bolt) Append(ctx context.Context, streamID string, data []byte) (int64, error) {
+	var pos int64
+
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(streamID))
+		if err != nil {
+			return err
+		}
+
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		pos = int64(seq)
+
+		val := make([]byte, 8+len(data))
+		binary.BigEndian.PutUint64(val[:8], uint64(time.Now().UnixNano()))
+		copy(val[8:], data)
+
+		return bucket.Put(encKey(pos), val)
+	})
+
+	return pos, err
+}
+
+func (b *Bolt) Position(ctx context.Context, streamID string) (int64, error) {
+	var pos int64
+
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(streamID))
+		if bucket == nil {
+			return nil
+		}
+		k, _ := bucket.Cursor().Last()
+		if k == nil {
+			return nil
+		}
+		pos = decKey(k)
+		return nil
+	})
+
+	return pos, err
+}
+
+func (b *Bolt) Read(ctx context.Context, streamID string, after, count int64) ([]Record, error) {
+	var recs []Record
+
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(streamID))
+		if bucket == nil {
+			return nil
+		}
+
+		firstKey, _ := bucket.Cursor().First()
+		lastKey, _ := bucket.Cursor().Last()
+		if firstKey == nil || lastKey == nil {
+			return nil
+		}
+		first, last := decKey(firstKey), decKey(lastKey)
+
+		start, n := math.PagerBox(uint64(first), uint64(last), after, count)
+		if n == 0 {
+			return nil
+		}
+
+		c := bucket.Cursor()
+		pos := int64(start)
+		for i := int64(0); i < math.Abs(n); i++ {
+			k, v := c.Seek(encKey(pos))
+			if k == nil || decKey(k) != pos {
+				break
+			}
+
+			rec, err := decRecord(pos, v)
+			if err != nil {
+				return err
+			}
+			recs = append(recs, rec)
+
+			if n > 0 {
+				pos++
+			} else {
+				pos--
+			}
+			if pos < first || pos > last {
+				break
+			}
+		}
+
+		return nil
+	})
+
+	return recs, err
+}
+
+func (b *Bolt) Since(ctx context.Context, streamID string, position int64) (<-chan Record, error) {
+	ch := make(chan Record)
+
+	go func() {
+		defer close(ch)
+
+		_ = b.db.View(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket([]byte(streamID))
+			if bucket == nil {
+				return nil
+			}
+
+			c := bucket.Cursor()
+			for k, v := c.Seek(encKey(position + 1)); k != nil; k, v = c.Next() {
+				rec, err := decRecord(decKey(k), v)
+				if err != nil {
+					return err
+				}
+
+				select {
+				case ch <- rec:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+
+			return nil
+		})
+	}()
+
+	return ch, nil
+}
+
+// Truncate removes every Record in streamID appended before the given time,
+// bounding the stream's growth to a configurable retention window.
+func (b *Bolt) Truncate(ctx context.Context, streamID string, before time.Time) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(streamID))
+		if bucket == nil {
+			return nil
+		}
+
+		var stale [][]byte
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			at := time.Unix(0, int64(binary.BigEndian.Uint64(v[:8])))
+			if at.Before(before) {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+		}
+
+		for _, k := range stale {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func encKey(pos int64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(pos))
+	return k
+}
+
+func decKey(k []byte) int64 {
+	return int64(binary.BigEndian.Uint64(k))
+}
+
+func decRecord(pos int64, v []byte) (Record, error) {
+	if len(v) < 8 {
+		return Record{}, fmt.Errorf("store: corrupt record at position %d", pos)
+	}
+
+	return Record{
+		Position: pos,
+		At:       time.Unix(0, int64(binary.BigEndian.Uint64(v[:8]))),
+		Data:     append([]byte(nil), v[8:]...),
+	}, nil
+}
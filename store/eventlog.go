@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package store gives session/mem a durable backing for the events a Client
+// dispatches, so a restart doesn't lose a session's scrollback.
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Record is a single logged event: the raw gob-encoded payload Append was
+// given, its position within the stream, and the time it was appended.
+type Record struct {
+	Position int64
+	Data     []byte
+	At       time.Time
+}
+
+// EventLog durably journals per-stream event data, keyed the same way
+// session/mem.MemSession keys its in-memory logs ("user:<nick>", "system").
+type EventLog interface {
+	// Append adds data to the end of streamID and returns its position.
+	Append(ctx context.Context, streamID string, data []byte) (position int64, err error)
+
+	// Read returns up to count Records from streamID using the same
+	// after/count pager semantics as math.PagerBox.
+	Read(ctx context.Context, streamID string, after, count int64) ([]Record, error)
+
+	// Position returns the position of the last Record appended to streamID,
+	// or 0 if the stream is empty.
+	Position(ctx context.Context, streamID string) (int64, error)
+
+	// Truncate discards every Record in streamID older than before.
+	Truncate(ctx context.Context, streamID string, before time.Time) error
+}
+
+// Since iterates a stream from just after position to its end. Not every
+// EventLog needs this beyond what Read already offers, so it's kept as an
+// optional extension a reconnecting Client can use to resume from the last
+// durable offset instead of polling Read in a loop.
+type Since interface {
+	Since(ctx context.Context, streamID string, position int64) (<-chan Record, error)
+}
@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package sync carries ratchet session-state deltas between a user's own
+// devices, so every device sharing a salty identity can decrypt history and
+// continue a Double Ratchet conversation without colliding. A Frame is the
+// whole serialized session.Session, not a field-level diff: that mirrors
+// how session.DiskSessionManager.Put already rewrites the session in full,
+// and keeps this package independent of any one persistence backend.
+package sync
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/keys-pub/keys"
+	"github.com/oklog/ulid/v2"
+	"go.mills.io/salty"
+)
+
+// Op identifies the kind of session change a Frame carries.
+type Op byte
+
+const (
+	_ Op = iota
+
+	// OpUpdate carries the session's latest serialized state in State.
+	OpUpdate
+
+	// OpDelete tells other devices the session was closed and should be
+	// removed; State is empty.
+	OpDelete
+)
+
+// Frame is a sync message addressed to a user's other devices. Counter is a
+// per-session lamport clock: a device bumps it on every local mutation, and
+// Wins decides whose copy of a session should take precedence when two
+// devices touched it concurrently.
+type Frame struct {
+	SessionID ulid.ULID
+	DeviceID  string
+	Counter   uint64
+	Op        Op
+
+	// State is the session.Session.MarshalBinary output for OpUpdate, and
+	// empty for OpDelete.
+	State []byte
+}
+
+// frameWire mirrors Frame field-for-field, but without MarshalBinary /
+// UnmarshalBinary methods of its own: gob.NewEncoder auto-detects
+// encoding.BinaryMarshaler on the type it's given, and encoding Frame
+// directly would have it call right back into Frame.MarshalBinary, blowing
+// the stack. Encoding this plain mirror instead breaks that recursion.
+type frameWire struct {
+	SessionID ulid.ULID
+	DeviceID  string
+	Counter   uint64
+	Op        Op
+	State     []byte
+}
+
+func (f Frame) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(frameWire(f))
+	return buf.Bytes(), err
+}
+
+func (f *Frame) UnmarshalBinary(b []byte) error {
+	var w frameWire
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&w); err != nil {
+		return err
+	}
+	*f = Frame(w)
+	return nil
+}
+
+// Encrypt wraps f as a SaltPack message addressed to recipients, the salty
+// key IDs of the user's other devices.
+func Encrypt(identity *keys.EdX25519Key, f Frame, recipients []string) ([]byte, error) {
+	data, err := f.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("marshal sync frame: %w", err)
+	}
+
+	return salty.Encrypt(identity, data, recipients)
+}
+
+// Decrypt unwraps a SaltPack message sent by Encrypt, returning the Frame
+// and the sending device's public key.
+func Decrypt(identity *keys.EdX25519Key, in []byte) (Frame, *keys.EdX25519PublicKey, error) {
+	var f Frame
+
+	data, key, err := salty.Decrypt(identity, in)
+	if err != nil {
+		return f, nil, err
+	}
+
+	err = f.UnmarshalBinary(data)
+	return f, key, err
+}
+
+// Wins reports whether remote should replace local: a higher Counter always
+// wins, and on a tie the lower DeviceID wins so every device reaches the
+// same verdict without further coordination.
+func Wins(remote, local Frame) bool {
+	if remote.Counter != local.Counter {
+		return remote.Counter > local.Counter
+	}
+	return remote.DeviceID < local.DeviceID
+}
@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+//
+// SPDX-License-Identifier: BSD-3-Clause
+package sync_test
+
+import (
+	"testing"
+
+	"github.com/keys-pub/keys"
+	"github.com/matryer/is"
+	"github.com/oklog/ulid/v2"
+	"go.salty.im/ratchet/sync"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	is := is.New(t)
+
+	me := keys.GenerateEdX25519Key()
+
+	want := sync.Frame{
+		SessionID: ulid.Make(),
+		DeviceID:  "laptop",
+		Counter:   3,
+		Op:        sync.OpUpdate,
+		State:     []byte("session bytes"),
+	}
+
+	data, err := sync.Encrypt(me, want, []string{me.ID().String()})
+	is.NoErr(err)
+
+	got, key, err := sync.Decrypt(me, data)
+	is.NoErr(err)
+	is.Equal(key.ID(), me.ID())
+	is.Equal(got.SessionID, want.SessionID)
+	is.Equal(got.DeviceID, want.DeviceID)
+	is.Equal(got.Counter, want.Counter)
+	is.Equal(got.Op, want.Op)
+	is.Equal(string(got.State), string(want.State))
+}
+
+func TestWins(t *testing.T) {
+	is := is.New(t)
+
+	local := sync.Frame{DeviceID: "phone", Counter: 2}
+
+	// Higher counter wins outright.
+	is.True(sync.Wins(sync.Frame{DeviceID: "laptop", Counter: 3}, local))
+	is.True(!sync.Wins(sync.Frame{DeviceID: "laptop", Counter: 1}, local))
+
+	// Tie goes to the lower DeviceID.
+	is.True(sync.Wins(sync.Frame{DeviceID: "laptop", Counter: 2}, local))
+	is.True(!sync.Wins(sync.Frame{DeviceID: "tablet", Counter: 2}, local))
+}
@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package transport
+
+import (
+	"context"
+
+	"go.salty.im/ratchet/client"
+)
+
+// driver adapts a Transport into a client.Driver, subscribing to inbox from
+// position pos and feeding every Envelope it yields to the Client as an
+// OnInput. It exists so every Transport gets a working Driver for free,
+// instead of each one (see client/driver-quic) re-implementing the
+// Subscribe-to-Run plumbing for itself.
+type driver struct {
+	Transport
+	inbox string
+	pos   int64
+	input func(client.OnInput) error
+}
+
+func (d *driver) Run(ctx context.Context) error {
+	ch, err := d.Subscribe(ctx, d.inbox, d.pos)
+	if err != nil {
+		return err
+	}
+
+	for env := range ch {
+		if err := d.input(client.OnInput{Position: env.ID, Payload: string(env.Payload)}); err != nil {
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
+
+// asClientTransport adapts Transport's []byte-based Send to the string-based
+// client.Transport, so the same backend carries both directions.
+type asClientTransport struct{ Transport }
+
+func (t asClientTransport) Send(ctx context.Context, endpoint, payload string) error {
+	return t.Transport.Send(ctx, endpoint, []byte(payload))
+}
+
+type withTransport struct {
+	t     Transport
+	inbox string
+	pos   int64
+}
+
+// WithTransport wires t in as both the Client's inbound Driver, subscribed
+// to inbox starting after pos, and its outbound client.Transport — so
+// ratchet and SaltPack traffic can move over msgbus, a Nostr relay, or any
+// other Transport implementation interchangeably.
+func WithTransport(t Transport, inbox string, pos int64) client.Option {
+	return withTransport{t, inbox, pos}
+}
+
+func (w withTransport) ApplyClient(c *client.Client) {
+	d := &driver{Transport: w.t, inbox: w.inbox, pos: w.pos, input: c.Input}
+	client.WithDriver(d).ApplyClient(c)
+	client.WithTransport(asClientTransport{w.t}).ApplyClient(c)
+}
@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package transport
+
+import (
+	"context"
+	"sync"
+)
+
+// Mem is an in-process Transport keyed by endpoint/inbox name, useful for
+// tests that exercise a Driver without a real network.
+type Mem struct {
+	mu    sync.Mutex
+	boxes map[string][]Envelope
+	subs  map[string][]chan Envelope
+}
+
+// NewMem returns an empty Mem transport.
+func NewMem() *Mem {
+	return &Mem{
+		boxes: make(map[string][]Envelope),
+		subs:  make(map[string][]chan Envelope),
+	}
+}
+
+func (m *Mem) Send(ctx context.Context, endpoint string, payload []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := int64(len(m.boxes[endpoint]))
+	env := Envelope{ID: id, Payload: payload}
+	m.boxes[endpoint] = append(m.boxes[endpoint], env)
+
+	for _, ch := range m.subs[endpoint] {
+		select {
+		case ch <- env:
+		default:
+		}
+	}
+
+	return nil
+}
+
+func (m *Mem) Subscribe(ctx context.Context, inbox string, from int64) (<-chan Envelope, error) {
+	ch := make(chan Envelope, 16)
+
+	m.mu.Lock()
+	for _, env := range m.boxes[inbox] {
+		if env.ID > from {
+			ch <- env
+		}
+	}
+	m.subs[inbox] = append(m.subs[inbox], ch)
+	m.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		subs := m.subs[inbox]
+		for i, c := range subs {
+			if c == ch {
+				m.subs[inbox] = append(subs[:i], subs[i+1:]...)
+				break
+			}
+		}
+		close(ch)
+	}()
+
+	return ch, nil
+}
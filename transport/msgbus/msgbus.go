@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package msgbus implements transport.Transport over
+// git.mills.io/prologic/msgbus, the HTTP/WebSocket pub/sub broker a
+// go.mills.io/saltyim address's endpoint resolves to. It replaces the old
+// client/driver-msgbus package, which wired the same broker directly to a
+// client.Driver instead of going through transport.Transport.
+package msgbus
+
+import (
+	"context"
+
+	"git.mills.io/prologic/msgbus"
+	mb "git.mills.io/prologic/msgbus/client"
+	"go.salty.im/saltyim"
+
+	"go.salty.im/ratchet/client"
+	"go.salty.im/ratchet/transport"
+)
+
+// Transport is a transport.Transport backed by a single msgbus broker at
+// uri; Send publishes to a topic and Subscribe streams one.
+type Transport struct {
+	bus *mb.Client
+}
+
+// New returns a Transport talking to the msgbus broker at uri.
+func New(uri string) *Transport {
+	return &Transport{bus: mb.NewClient(uri, nil)}
+}
+
+// Send implements transport.Transport. endpoint is the full inbox URL (as
+// returned by saltyim.LookupAddr); only its final path segment, the topic,
+// is relevant to this broker.
+func (t *Transport) Send(ctx context.Context, endpoint string, payload []byte) error {
+	_, topic := saltyim.SplitInbox(endpoint)
+	return t.bus.Publish(topic, string(payload))
+}
+
+// Subscribe implements transport.Transport, streaming inbox starting after
+// position from over a WebSocket connection that reconnects on its own.
+func (t *Transport) Subscribe(ctx context.Context, inbox string, from int64) (<-chan transport.Envelope, error) {
+	ch := make(chan transport.Envelope, 16)
+
+	sub := t.bus.Subscribe(inbox, from, msgbus.HandlerFunc(func(msg *msgbus.Message) error {
+		select {
+		case ch <- transport.Envelope{ID: msg.ID, Payload: []byte(msg.Payload)}:
+		case <-ctx.Done():
+		}
+		return nil
+	}))
+
+	go func() {
+		defer close(ch)
+		_ = sub.Run(ctx)
+	}()
+
+	return ch, nil
+}
+
+type withMsgbus struct{ pos int64 }
+
+// WithMsgbus wires a msgbus Transport for the Client's own inbox in as both
+// its inbound Driver and outbound client.Transport, replacing the old
+// client/driver-msgbus.WithMsgbus. pos is the stream position to resume
+// from, same as before.
+func WithMsgbus(pos int64) client.Option {
+	return withMsgbus{pos}
+}
+
+func (w withMsgbus) ApplyClient(c *client.Client) {
+	uri, inbox := saltyim.SplitInbox(c.Me().Endpoint().String())
+	transport.WithTransport(New(uri), inbox, w.pos).ApplyClient(c)
+}
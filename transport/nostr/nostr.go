@@ -0,0 +1,206 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package nostr is a transport.Transport alternative to transport/msgbus
+// that needs no central broker beyond a relay speaking the NIP-01
+// REQ/EVENT/EOSE framing: Send publishes a signed event tagging the
+// recipient's pubkey, and Subscribe opens a REQ filtered on our own pubkey
+// tag. It reuses the keys.EdX25519Key identity already threaded through
+// client.Client to sign events rather than pulling in a secp256k1/Schnorr
+// dependency for NIP-01's actual signature scheme, so it is not
+// wire-compatible with the wider Nostr network — only with another
+// go-ratchet node using this same package against the same relay.
+package nostr
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/keys-pub/keys"
+
+	"go.salty.im/ratchet/transport"
+)
+
+// kindRatchet is the event kind this package reserves on the relay for
+// ratchet/SaltPack payloads, chosen from NIP-01's unreserved application
+// range.
+const kindRatchet = 30100
+
+// event mirrors the wire shape of a NIP-01 event closely enough to round
+// trip through a standard relay, though id/sig use Ed25519 rather than
+// Nostr's secp256k1 Schnorr (see package doc).
+type event struct {
+	ID        string     `json:"id"`
+	PubKey    string     `json:"pubkey"`
+	CreatedAt int64      `json:"created_at"`
+	Kind      int        `json:"kind"`
+	Tags      [][]string `json:"tags"`
+	Content   string     `json:"content"`
+	Sig       string     `json:"sig"`
+}
+
+func (e *event) hash() [32]byte {
+	ser, _ := json.Marshal([]any{0, e.PubKey, e.CreatedAt, e.Kind, e.Tags, e.Content})
+	return sha256.Sum256(ser)
+}
+
+func (e *event) sign(key *keys.EdX25519Key) {
+	h := e.hash()
+	e.ID = hex.EncodeToString(h[:])
+	e.Sig = hex.EncodeToString(key.SignDetached(h[:]))
+}
+
+func (e *event) verify() error {
+	h := e.hash()
+	if got := hex.EncodeToString(h[:]); got != e.ID {
+		return fmt.Errorf("nostr: event %s: id mismatch", e.ID)
+	}
+
+	var pub [32]byte
+	b, err := hex.DecodeString(e.PubKey)
+	if err != nil || len(b) != len(pub) {
+		return fmt.Errorf("nostr: event %s: bad pubkey %q", e.ID, e.PubKey)
+	}
+	copy(pub[:], b)
+
+	sig, err := hex.DecodeString(e.Sig)
+	if err != nil {
+		return fmt.Errorf("nostr: event %s: bad sig: %w", e.ID, err)
+	}
+
+	return keys.NewEdX25519PublicKey(&pub).VerifyDetached(sig, h[:])
+}
+
+// Transport is a transport.Transport backed by a single Nostr-style relay.
+type Transport struct {
+	relay string
+	key   *keys.EdX25519Key
+
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+// New returns a Transport that signs outgoing events with key and talks to
+// the relay at the given wss:// (or ws://) URL.
+func New(relay string, key *keys.EdX25519Key) *Transport {
+	return &Transport{relay: relay, key: key}
+}
+
+func (t *Transport) dial(ctx context.Context) (*websocket.Conn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conn != nil {
+		return t.conn, nil
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, t.relay, nil)
+	if err != nil {
+		return nil, fmt.Errorf("nostr: dial %s: %w", t.relay, err)
+	}
+	t.conn = conn
+	return conn, nil
+}
+
+// Send implements transport.Transport. endpoint is the recipient's
+// hex-encoded Ed25519 public key; payload becomes the event content,
+// tagged so the recipient's REQ filter (see Subscribe) picks it up.
+func (t *Transport) Send(ctx context.Context, endpoint string, payload []byte) error {
+	conn, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+
+	e := event{
+		PubKey:  hex.EncodeToString(t.key.PublicKey().Bytes()),
+		Kind:    kindRatchet,
+		Tags:    [][]string{{"p", endpoint}},
+		Content: string(payload),
+	}
+	e.sign(t.key)
+
+	frame, err := json.Marshal([]any{"EVENT", e})
+	if err != nil {
+		return fmt.Errorf("nostr: marshal event: %w", err)
+	}
+
+	return conn.WriteMessage(websocket.TextMessage, frame)
+}
+
+// Subscribe implements transport.Transport, opening a REQ filtered on our
+// own pubkey tag. from is interpreted as a Unix timestamp (NIP-01's
+// `since`), not a linear offset like transport/msgbus uses — Nostr relays
+// have no notion of the latter.
+func (t *Transport) Subscribe(ctx context.Context, inbox string, from int64) (<-chan transport.Envelope, error) {
+	conn, err := t.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	subID := "ratchet-" + inbox
+	req, err := json.Marshal([]any{"REQ", subID, map[string]any{
+		"kinds": []int{kindRatchet},
+		"#p":    []string{inbox},
+		"since": from,
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("nostr: marshal REQ: %w", err)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, req); err != nil {
+		return nil, fmt.Errorf("nostr: send REQ: %w", err)
+	}
+
+	ch := make(chan transport.Envelope, 16)
+	go t.readLoop(ctx, conn, subID, ch)
+
+	return ch, nil
+}
+
+func (t *Transport) readLoop(ctx context.Context, conn *websocket.Conn, subID string, ch chan<- transport.Envelope) {
+	defer close(ch)
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var frame []json.RawMessage
+		if err := json.Unmarshal(raw, &frame); err != nil || len(frame) < 2 {
+			continue
+		}
+
+		var kind string
+		if err := json.Unmarshal(frame[0], &kind); err != nil || kind != "EVENT" {
+			continue
+		}
+
+		var gotSub string
+		if len(frame) < 3 || json.Unmarshal(frame[1], &gotSub) != nil || gotSub != subID {
+			continue
+		}
+
+		var e event
+		if err := json.Unmarshal(frame[2], &e); err != nil {
+			continue
+		}
+		if err := e.verify(); err != nil {
+			continue
+		}
+
+		select {
+		case ch <- transport.Envelope{ID: e.CreatedAt, Payload: []byte(e.Content)}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package tor is a client.Transport alternative to the default plain HTTPS
+// POST (see client's unexported httpTransport): it dials the same saltyim
+// endpoint through a local SOCKS5 proxy, the way Cwtch layers its messaging
+// over onion services, so an on-path observer between this node and the
+// proxy sees only a connection to Tor, not which saltyim endpoint (and so
+// which peer) is being talked to. It composes with obfs, which disguises
+// the payload's shape rather than the path it travels; this package hides
+// the path and works unmodified against a peer whose saltyim address
+// already resolves to a .onion endpoint.
+package tor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// DefaultProxy is the address Tor's default torrc binds its SOCKS5 port to.
+const DefaultProxy = "127.0.0.1:9050"
+
+// Transport implements client.Transport, delivering outbound ratchet and
+// SaltPack payloads over a SOCKS5 proxy instead of a direct connection.
+type Transport struct {
+	client *http.Client
+}
+
+// New returns a Transport that dials proxyAddr's SOCKS5 proxy for every
+// request. An empty proxyAddr uses DefaultProxy.
+func New(proxyAddr string) (*Transport, error) {
+	if proxyAddr == "" {
+		proxyAddr = DefaultProxy
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", proxyAddr, nil, proxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("tor: dial %s: %w", proxyAddr, err)
+	}
+
+	contextDialer, ok := dialer.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("tor: SOCKS5 dialer for %s does not support context cancellation", proxyAddr)
+	}
+
+	return &Transport{
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: contextDialer.DialContext,
+			},
+			Timeout: 30 * time.Second,
+		},
+	}, nil
+}
+
+// Send implements client.Transport. endpoint may be a regular https:// URL
+// or one resolving to a .onion host; both route through the SOCKS5 proxy
+// identically, since it is the proxy (not this Transport) that knows how to
+// reach onion services.
+func (t *Transport) Send(ctx context.Context, endpoint, payload string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("tor: new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("tor: post %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("tor: post %s: %s", endpoint, resp.Status)
+	}
+	return nil
+}
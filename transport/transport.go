@@ -0,0 +1,32 @@
+// SPDX-FileCopyrightText: 2023 Jon Lundy <jon@xuu.cc>
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package transport abstracts the wire used to carry ratchet frames between
+// nodes, so transport/msgbus's broker-backed path is one implementation
+// among several (see transport/nostr for a relay-backed alternative, and
+// client/driver-quic for a QUIC datagram one). WithTransport adapts any
+// implementation into a client.Driver and client.Transport pair.
+package transport
+
+import "context"
+
+// Envelope is a single received frame along with its position in the
+// inbox's stream, mirroring msgbus.Message so callers can resume a
+// Subscribe from the same offset a msgbus-backed SessionManager already
+// tracks.
+type Envelope struct {
+	ID      int64
+	Payload []byte
+}
+
+// Transport sends and receives the `!RAT!...!CHT!` / saltpack blobs exchanged
+// between ratchet nodes.
+type Transport interface {
+	// Send delivers payload to endpoint.
+	Send(ctx context.Context, endpoint string, payload []byte) error
+
+	// Subscribe streams Envelopes arriving at inbox starting after position
+	// from. The returned channel is closed when ctx is canceled or the
+	// subscription ends.
+	Subscribe(ctx context.Context, inbox string, from int64) (<-chan Envelope, error)
+}
@@ -6,6 +6,7 @@ package ui
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"strings"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/charmbracelet/lipgloss"
 	"github.com/oklog/ulid/v2"
 	"go.salty.im/ratchet/client"
+	"go.salty.im/ratchet/roster"
 )
 
 // You generally won't need this unless you're processing stuff with
@@ -25,6 +27,13 @@ import (
 // tea.EnterAltScreen().
 const useHighPerformanceRenderer = false
 
+// systemPane is the key under which system messages and replies to
+// sessionless commands (/chat with no session, errors, ...) are buffered.
+const systemPane = ""
+
+// nicklistWidth is the fixed width of the left-hand session list column.
+const nicklistWidth = 22
+
 var (
 	titleStyle = func() lipgloss.Style {
 		b := lipgloss.RoundedBorder()
@@ -32,23 +41,36 @@ var (
 		return lipgloss.NewStyle().BorderStyle(b).Padding(0, 1)
 	}()
 
-	// infoStyle = func() lipgloss.Style {
-	// 	b := lipgloss.RoundedBorder()
-	// 	b.Left = "┤"
-	// 	return titleStyle.Copy().BorderStyle(b)
-	// }()
+	nicklistStyle = lipgloss.NewStyle().
+			BorderStyle(lipgloss.NormalBorder()).
+			BorderRight(true).
+			Padding(0, 1)
+
+	activeSessionStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("10")).Bold(true)
 )
 
 type (
 	errMsg error
 )
 
+// model is a multi-pane TUI: a nicklist of sessions on the left, the
+// active session's scrollback in the middle, and an input line at the
+// bottom. Each session keeps its own scrollback buffer, so switching
+// sessions (Ctrl+N/Ctrl+P or /chat) never interleaves unrelated
+// conversations the way a single shared buffer used to.
 type model struct {
-	c *client.Client
+	c      *client.Client
+	roster *roster.Roster // nil if the caller didn't open one; resolve is then a no-op
+
+	them  string   // active session name; systemPane for the system log
+	order []string // session names in nicklist order, systemPane always first
+
+	buffers map[string]*strings.Builder
 
-	them string
+	searching   bool
+	searchInput textinput.Model
+	searchRe    *regexp.Regexp
 
-	content   *strings.Builder
 	ready     bool
 	viewport  viewport.Model
 	nicklist  viewport.Model
@@ -56,7 +78,17 @@ type model struct {
 	err       error
 }
 
-func InitialModel(c *client.Client, them string) model {
+// resolve expands name through m.roster's aliases, falling back to name
+// unchanged if no roster was configured or it isn't a known alias.
+func (m *model) resolve(name string) string {
+	if m.roster == nil {
+		return name
+	}
+	addr, _ := m.roster.Resolve(name)
+	return addr
+}
+
+func InitialModel(c *client.Client, them string, r *roster.Roster) model {
 	ti := textinput.New()
 	ti.Placeholder = "Message"
 	ti.Prompt = "foo? "
@@ -64,25 +96,36 @@ func InitialModel(c *client.Client, them string) model {
 	ti.CharLimit = 156
 	ti.Width = 20
 
+	si := textinput.New()
+	si.Placeholder = "regex"
+	si.Prompt = "/"
+
 	m := model{
-		c:         c,
-		them:      them,
-		content:   &strings.Builder{},
-		textInput: ti,
+		c:           c,
+		roster:      r,
+		them:        them,
+		order:       []string{systemPane},
+		buffers:     map[string]*strings.Builder{systemPane: {}},
+		textInput:   ti,
+		searchInput: si,
+	}
+	if them != "" {
+		m.addSession(them)
 	}
 	m.setPrompt()
 
-	client.On(c, func(ctx context.Context, args client.OnOfferSent) { m.Update(args) })
-	client.On(c, func(ctx context.Context, args client.OnOfferReceived) { m.Update(args) })
-	client.On(c, func(ctx context.Context, args client.OnSessionStarted) { m.Update(args) })
-	client.On(c, func(ctx context.Context, args client.OnSessionClosed) { m.Update(args) })
-	client.On(c, func(ctx context.Context, args client.OnMessageReceived) { m.Update(args) })
-	client.On(c, func(ctx context.Context, args client.OnMessageSent) { m.Update(args) })
-	client.On(c, func(ctx context.Context, args client.OnSaltySent) { m.Update(args) })
-	client.On(c, func(ctx context.Context, args client.OnSaltyTextReceived) { m.Update(args) })
-	client.On(c, func(ctx context.Context, args client.OnSaltyEventReceived) { m.Update(args) })
-	client.On(c, func(ctx context.Context, args client.OnReceived) { m.Update(args) })
-	client.On(c, func(ctx context.Context, args error) { m.Update(args) })
+	client.Subscribe(c, func(ctx context.Context, args client.OnOfferSent) error { m.Update(args); return nil })
+	client.Subscribe(c, func(ctx context.Context, args client.OnOfferReceived) error { m.Update(args); return nil })
+	client.Subscribe(c, func(ctx context.Context, args client.OnSessionStarted) error { m.Update(args); return nil })
+	client.Subscribe(c, func(ctx context.Context, args client.OnSessionClosed) error { m.Update(args); return nil })
+	client.Subscribe(c, func(ctx context.Context, args client.OnMessageReceived) error { m.Update(args); return nil })
+	client.Subscribe(c, func(ctx context.Context, args client.OnMessageSent) error { m.Update(args); return nil })
+	client.Subscribe(c, func(ctx context.Context, args client.OnSaltySent) error { m.Update(args); return nil })
+	client.Subscribe(c, func(ctx context.Context, args client.OnSaltyTextReceived) error { m.Update(args); return nil })
+	client.Subscribe(c, func(ctx context.Context, args client.OnSaltyEventReceived) error { m.Update(args); return nil })
+	client.Subscribe(c, func(ctx context.Context, args client.OnReceived) error { m.Update(args); return nil })
+	client.Subscribe(c, func(ctx context.Context, args client.OnBlobReceived) error { m.Update(args); return nil })
+	client.Subscribe(c, func(ctx context.Context, args error) error { m.Update(args); return nil })
 
 	return m
 }
@@ -90,6 +133,7 @@ func InitialModel(c *client.Client, them string) model {
 func (m model) Init() tea.Cmd {
 	return textinput.Blink
 }
+
 func (m *model) setPrompt() {
 	prompt := ""
 	if m.them == "" {
@@ -100,6 +144,118 @@ func (m *model) setPrompt() {
 	m.textInput.Prompt = prompt
 }
 
+// buffer returns the scrollback buffer for session name, creating it if
+// this is the first message seen for it. It does not touch the nicklist
+// order; addSession does that.
+func (m *model) buffer(name string) *strings.Builder {
+	b, ok := m.buffers[name]
+	if !ok {
+		b = &strings.Builder{}
+		m.buffers[name] = b
+	}
+	return b
+}
+
+// addSession registers name in the nicklist, if it isn't already there.
+func (m *model) addSession(name string) {
+	m.buffer(name)
+	for _, n := range m.order {
+		if n == name {
+			return
+		}
+	}
+	m.order = append(m.order, name)
+}
+
+// switchTo makes name the active session, creating its buffer/nicklist
+// entry if this is the first time it's been seen.
+func (m *model) switchTo(name string) {
+	m.addSession(name)
+	m.them = name
+	m.setPrompt()
+	m.searching = false
+	m.refreshViewport()
+}
+
+// cycleSession moves the active session forward (delta=1) or backward
+// (delta=-1) through m.order, wrapping around.
+func (m *model) cycleSession(delta int) {
+	if len(m.order) == 0 {
+		return
+	}
+	cur := 0
+	for i, n := range m.order {
+		if n == m.them {
+			cur = i
+			break
+		}
+	}
+	next := (cur + delta + len(m.order)) % len(m.order)
+	m.switchTo(m.order[next])
+}
+
+// nameFor returns the session this event belongs to, or systemPane for
+// events with no associated peer.
+func nameFor(msg any) string {
+	switch msg := msg.(type) {
+	case client.OnOfferSent:
+		return msg.Them
+	case client.OnOfferReceived:
+		return msg.Them
+	case client.OnSessionStarted:
+		return msg.Them
+	case client.OnSessionClosed:
+		return msg.Them
+	case client.OnMessageReceived:
+		return msg.Them
+	case client.OnMessageSent:
+		return msg.Them
+	case client.OnBlobReceived:
+		return msg.Them
+	default:
+		return systemPane
+	}
+}
+
+// refreshViewport renders the active session's buffer, or the results of
+// the live search if one is in progress.
+func (m *model) refreshViewport() {
+	content := m.buffer(m.them).String()
+	if m.searching && m.searchRe != nil {
+		content = m.filterLines(content, m.searchRe)
+	}
+	m.viewport.SetContent(content)
+}
+
+func (m *model) filterLines(content string, re *regexp.Regexp) string {
+	var b strings.Builder
+	for _, line := range strings.Split(content, "\n") {
+		if re.MatchString(line) {
+			b.WriteString(line)
+			b.WriteRune('\n')
+		}
+	}
+	return b.String()
+}
+
+func (m *model) renderNicklist() string {
+	var b strings.Builder
+	for _, name := range m.order {
+		label := name
+		if label == systemPane {
+			label = "[system]"
+		}
+		if name == m.them {
+			label = activeSessionStyle.Render("> " + label)
+		} else {
+			label = "  " + label
+		}
+		b.WriteString(label)
+		b.WriteRune('\n')
+	}
+	return b.String()
+}
+
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var (
 		cmd  tea.Cmd
@@ -118,15 +274,47 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		client.OnSessionStarted,
 		client.OnSessionClosed,
 		client.OnReceived,
+		client.OnBlobReceived,
 		error:
-		fmt.Fprintln(m.content, formatMsg(me, msg))
-		m.viewport.GotoBottom()
+		name := nameFor(msg)
+		m.addSession(name)
+		fmt.Fprintln(m.buffer(name), formatMsg(me, msg))
+		if name == m.them {
+			m.viewport.GotoBottom()
+		}
 
 	case tea.KeyMsg:
+		if m.searching {
+			switch msg.Type {
+			case tea.KeyEsc:
+				m.searching = false
+				m.searchInput.SetValue("")
+				m.searchInput.Blur()
+				m.refreshViewport()
+				return m, nil
+			case tea.KeyEnter:
+				if re, err := regexp.Compile(m.searchInput.Value()); err == nil {
+					m.searchRe = re
+					m.refreshViewport()
+				}
+				return m, nil
+			}
+			m.searchInput, cmd = m.searchInput.Update(msg)
+			m.refreshViewport()
+			return m, cmd
+		}
+
 		switch msg.Type {
-		case tea.KeyCtrlC, tea.KeyEsc:
+		case tea.KeyCtrlC:
 			return m, tea.Quit
 
+		case tea.KeyCtrlN:
+			m.cycleSession(1)
+			return m, nil
+		case tea.KeyCtrlP:
+			m.cycleSession(-1)
+			return m, nil
+
 		case tea.KeyEnter:
 			input := m.textInput.Value()
 			if input == "" {
@@ -142,28 +330,31 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				// handle show list of open sessions
 				if len(sp) <= 1 {
 					err := m.c.Use(ctx, func(ctx context.Context, sm client.SessionManager) error {
-						fmt.Fprintln(m.content, "usage: /chat|close username")
+						fmt.Fprintln(m.buffer(systemPane), "usage: /chat|close username")
 						for _, p := range sm.Sessions() {
-							fmt.Fprintln(m.content, "sess: ", p.Name)
+							m.addSession(p.Name)
+							fmt.Fprintln(m.buffer(systemPane), "sess: ", p.Name)
 						}
 						return nil
 					})
 					if err != nil {
-						fmt.Fprintf(m.content, "ERR: %s\n", err)
+						fmt.Fprintf(m.buffer(systemPane), "ERR: %s\n", err)
 					}
+					m.refreshViewport()
 					break
 				}
 
-				if m.c.Me().String() == sp[1] {
-					fmt.Fprintln(m.content, "ERR: cant racthet with self")
+				target := m.resolve(sp[1])
+
+				if me == target {
+					fmt.Fprintln(m.buffer(systemPane), "ERR: cant racthet with self")
 				}
 
-				m.them = sp[1]
-				m.setPrompt()
+				m.switchTo(target)
 
 				_, err := m.c.Chat(ctx, m.them)
 				if err != nil {
-					fmt.Fprintf(m.content, "ERR: %s\n", err)
+					fmt.Fprintf(m.buffer(m.them), "ERR: %s\n", err)
 				}
 				break
 			}
@@ -173,27 +364,25 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				target := m.them
 
 				if len(sp) > 1 {
-					target = sp[1]
+					target = m.resolve(sp[1])
 				}
 
 				if target == "" {
 					break
 				}
 
-				m.them = ""
-				m.setPrompt()
-
 				err := m.c.Close(ctx, target)
 				if err != nil {
-					fmt.Fprintf(m.content, "ERR: %s\n", err)
+					fmt.Fprintf(m.buffer(target), "ERR: %s\n", err)
 				}
+				m.switchTo(systemPane)
 				break
 			}
 			if strings.HasPrefix(input, "/salty") {
 				target, msg, _ := strings.Cut(strings.TrimPrefix(input, "/salty "), " ")
-				err := m.c.SendSalty(ctx, target, msg)
+				err := m.c.SendSalty(ctx, m.resolve(target), msg)
 				if err != nil {
-					fmt.Fprintln(m.content, "ERR: ", err)
+					fmt.Fprintln(m.buffer(systemPane), "ERR: ", err)
 				}
 				break
 			}
@@ -202,11 +391,19 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 
 			if m.them == "" {
-				fmt.Fprintln(m.content, "usage: /chat username")
+				fmt.Fprintln(m.buffer(systemPane), "usage: /chat username")
 				break
 			}
 
 			m.c.Send(ctx, m.them, input)
+
+		default:
+			if msg.String() == "/" && m.textInput.Value() == "" {
+				m.searching = true
+				m.searchInput.SetValue("")
+				m.searchInput.Focus()
+				return m, textinput.Blink
+			}
 		}
 
 	case tea.WindowSizeMsg:
@@ -215,8 +412,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		inputHeight := lipgloss.Height(m.textInput.View())
 		verticalMarginHeight := headerHeight + footerHeight + inputHeight
 
+		mainWidth := max(0, msg.Width-nicklistWidth)
+
 		if !m.ready {
-			m.textInput.Width = msg.Width
+			m.textInput.Width = mainWidth
 
 			// Since this program is using the full size of the viewport we
 			// need to wait until we've received the window dimensions before
@@ -224,12 +423,14 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			// quickly, though asynchronously, which is why we wait for them
 			// here.
 
-			m.viewport = viewport.New(msg.Width, msg.Height-verticalMarginHeight)
+			m.viewport = viewport.New(mainWidth, msg.Height-verticalMarginHeight)
 			m.viewport.YPosition = headerHeight
-			m.viewport.Width = msg.Width
+			m.viewport.Width = mainWidth
 			m.viewport.HighPerformanceRendering = useHighPerformanceRenderer
-			m.viewport.SetContent(m.content.String())
 			m.viewport.MouseWheelEnabled = true
+
+			m.nicklist = viewport.New(nicklistWidth, msg.Height)
+
 			m.ready = true
 
 			// This is only necessary for high performance rendering, which in
@@ -238,8 +439,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			// Render the viewport one line below the header.
 			m.viewport.YPosition = headerHeight + 1
 		} else {
-			m.viewport.Width = msg.Width
+			m.viewport.Width = mainWidth
 			m.viewport.Height = msg.Height - verticalMarginHeight
+			m.nicklist.Width = nicklistWidth
+			m.nicklist.Height = msg.Height
 		}
 
 		if useHighPerformanceRenderer {
@@ -252,7 +455,8 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	}
 
 	// Handle keyboard and mouse events in the viewport
-	m.viewport.SetContent(m.content.String())
+	m.refreshViewport()
+	m.nicklist.SetContent(m.renderNicklist())
 
 	m.viewport, cmd = m.viewport.Update(msg)
 	cmds = append(cmds, cmd)
@@ -267,23 +471,34 @@ func (m model) View() string {
 	if !m.ready {
 		return "\n  Initializing..."
 	}
-	return fmt.Sprintf(
-		"%s\n%s\n%s\n%s",
+
+	main := lipgloss.JoinVertical(
+		lipgloss.Left,
 		m.headerView(),
 		m.viewport.View(),
 		m.footerView(),
-		m.textInput.View(),
 	)
+	body := lipgloss.JoinHorizontal(lipgloss.Top, nicklistStyle.Render(m.nicklist.View()), main)
+
+	bottom := m.textInput.View()
+	if m.searching {
+		bottom = m.searchInput.View()
+	}
+
+	return fmt.Sprintf("%s\n%s", body, bottom)
 }
 
 func (m model) headerView() string {
-	title := titleStyle.Render("Ratchet Chat")
-	line := strings.Repeat("─", max(0, m.viewport.Width-lipgloss.Width(title)))
-	return lipgloss.JoinHorizontal(lipgloss.Center, title, line)
+	title := m.them
+	if title == systemPane {
+		title = "system"
+	}
+	rendered := titleStyle.Render("Ratchet Chat: " + title)
+	line := strings.Repeat("─", max(0, m.viewport.Width-lipgloss.Width(rendered)))
+	return lipgloss.JoinHorizontal(lipgloss.Center, rendered, line)
 }
 
 func (m model) footerView() string {
-	// info := infoStyle.Render(fmt.Sprintf("%3.f%%", m.viewport.ScrollPercent()*100))
 	line := strings.Repeat("─", max(0, m.viewport.Width))
 	return lipgloss.JoinHorizontal(lipgloss.Center, line)
 }
@@ -335,6 +550,8 @@ func formatMsg(me string, msg any) string {
 		return fmt.Sprintf("%s::: event: %s(%s)%s", COLOR_GREY, msg.Event.Command, strings.Join(msg.Event.Args, ", "), RESET_COLOR)
 	case client.OnReceived:
 		return fmt.Sprintf("%s::: unknown message: %s%s", COLOR_GREY, msg.Raw, RESET_COLOR)
+	case client.OnBlobReceived:
+		return fmt.Sprintf("%s::: file received from %s: %s (%s) :::%s", COLOR_GREY, msg.Them, msg.Name, msg.Mime, RESET_COLOR)
 	default:
 		return fmt.Sprint(msg)
 	}
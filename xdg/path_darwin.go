@@ -15,8 +15,8 @@ const (
 	defaultConfigHome = "~/Library/Preferences"
 	defaultConfigDirs = "/Library/Preferences"
 	defaultCacheHome  = "~/Library/Caches"
-	defaultStateHome  = "~/Library/Caches"
-	defaultRuntime    = "~/Library/Application Support"
+	defaultStateHome  = "~/Library/Logs"
+	defaultRuntime    = "$TMPDIR"
 
 	defaultDesktop   = "~/Desktop"
 	defaultDownload  = "~/Downloads"
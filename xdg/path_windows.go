@@ -5,6 +5,8 @@
 
 package xdg
 
+import "golang.org/x/sys/windows"
+
 func literal(name string) string {
 	return "%" + name + "%"
 }
@@ -16,17 +18,32 @@ const (
 	defaultConfigDirs = `%ProgramData%`
 	defaultCacheHome  = `%LOCALAPPDATA%\cache`
 	defaultStateHome  = `%LOCALAPPDATA%\state`
-	defaultRuntime    = `%LOCALAPPDATA%`
-
-	defaultDesktop   = `%USERPROFILE%\Desktop`
-	defaultDownload  = `%USERPROFILE%\Downloads`
-	defaultDocuments = `%USERPROFILE%\Documents`
-	defaultMusic     = `%USERPROFILE%\Music`
-	defaultPictures  = `%USERPROFILE%\Pictures`
-	defaultVideos    = `%USERPROFILE%\Videos`
-	defaultTemplates = `%USERPROFILE%\Templates`
-	defaultPublic    = `%USERPROFILE%\Public`
+	defaultRuntime    = `%TEMP%`
 
 	defaultApplicationDirs = `%APPDATA%\Roaming\Microsoft\Windows\Start Menu\Programs`
 	defaultFontDirs        = `%windir%\Fonts;%LOCALAPPDATA%\Microsoft\Windows\Fonts`
 )
+
+// The per-user shell folders below are resolved through the Known Folder
+// API rather than hardcoded %USERPROFILE% paths, since a user can (and
+// often does, in managed/corporate environments) redirect Desktop,
+// Documents, etc. to OneDrive or a network share. knownFolder falls back to
+// the literal %USERPROFILE%\X default if the lookup fails.
+var (
+	defaultDesktop   = knownFolder(windows.FOLDERID_Desktop, `%USERPROFILE%\Desktop`)
+	defaultDownload  = knownFolder(windows.FOLDERID_Downloads, `%USERPROFILE%\Downloads`)
+	defaultDocuments = knownFolder(windows.FOLDERID_Documents, `%USERPROFILE%\Documents`)
+	defaultMusic     = knownFolder(windows.FOLDERID_Music, `%USERPROFILE%\Music`)
+	defaultPictures  = knownFolder(windows.FOLDERID_Pictures, `%USERPROFILE%\Pictures`)
+	defaultVideos    = knownFolder(windows.FOLDERID_Videos, `%USERPROFILE%\Videos`)
+	defaultTemplates = knownFolder(windows.FOLDERID_Templates, `%USERPROFILE%\Templates`)
+	defaultPublic    = knownFolder(windows.FOLDERID_Public, `%USERPROFILE%\Public`)
+)
+
+func knownFolder(id *windows.KNOWNFOLDERID, fallback string) string {
+	path, err := windows.KnownFolderPath(id, windows.KF_FLAG_DEFAULT)
+	if err != nil {
+		return fallback
+	}
+	return path
+}